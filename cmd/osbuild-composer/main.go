@@ -1,55 +1,337 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/config"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/health"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/metrics"
+	"github.com/osbuild/osbuild-composer/internal/retention"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/webhook"
 	"github.com/osbuild/osbuild-composer/internal/weldr"
 
 	"github.com/coreos/go-systemd/activation"
 )
 
 func main() {
+	var configFile string
 	var verbose bool
-	flag.BoolVar(&verbose, "v", false, "Print access log")
+	flag.StringVar(&configFile, "config", config.DefaultPath, "Path to the configuration file")
+	flag.BoolVar(&verbose, "v", false, "Log debug messages, including every API request")
 	flag.Parse()
 
-	stateFile := "/var/lib/osbuild-composer/state.json"
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		logging.Default().Fatalf("cannot load configuration: %v", err)
+	}
 
-	listeners, err := activation.Listeners()
+	if verbose {
+		cfg.Log.Level = "debug"
+	}
+	logger, err := cfg.Logger(os.Stderr)
 	if err != nil {
-		panic(err)
+		logging.Default().Fatalf("cannot set up logging: %v", err)
 	}
+	logging.SetDefault(logger)
 
-	if len(listeners) != 2 {
-		panic("Unexpected number of sockets. Composer require 2 of them.")
+	weldrListener, jobListener, err := getListeners(cfg)
+	if err != nil {
+		logger.Fatalf("cannot listen: %v", err)
 	}
 
-	weldrListener := listeners[0]
-	jobListener := listeners[1]
+	rpm := rpmmd.NewRPMMDWithOptions(cfg.RPMMDOptions())
 
-	rpm := rpmmd.NewRPMMD()
+	var distribution distro.Distro
+	if len(cfg.Distros) > 0 {
+		distribution = distro.New(cfg.Distros[0])
+	} else {
+		distribution, err = distro.FromHost()
+		if err != nil {
+			logger.Fatalf("cannot detect distro from host: %v", err)
+		}
+	}
 
-	distribution, err := distro.FromHost()
+	if name := distribution.Name(); cfg.DistroRepositories(name) != nil {
+		distribution, err = distro.WithRepositories(distribution, cfg.DistroRepositories(name))
+		if err != nil {
+			logger.Fatalf("cannot configure repositories of %s: %v", name, err)
+		}
+	}
+
+	webhookSecret, err := config.ReadSecret(cfg.Webhooks.SecretFile)
 	if err != nil {
-		panic("cannot detect distro from host: " + err.Error())
+		logger.Fatalf("cannot read webhook secret: %v", err)
 	}
 
-	var logger *log.Logger
-	if verbose {
-		logger = log.New(os.Stdout, "", 0)
+	workerToken, err := config.ReadSecret(cfg.Worker.TokenFile)
+	if err != nil {
+		logger.Fatalf("cannot read worker token: %v", err)
+	}
+
+	authPolicy, err := cfg.AuthPolicy()
+	if err != nil {
+		logger.Fatalf("cannot load auth settings: %v", err)
+	}
+	authenticator := auth.NewAuthenticator(authPolicy)
+
+	signer, err := cfg.ManifestSigner()
+	if err != nil {
+		logger.Fatalf("cannot load signing key: %v", err)
 	}
 
-	store := store.New(&stateFile, distribution)
+	store := store.NewWithOptions(&cfg.Paths.State, distribution, store.Options{
+		OutputDir:      cfg.Paths.Outputs,
+		MaxPendingJobs: cfg.Queue.MaxPendingJobs,
+	})
+	notifier := webhook.New(store, cfg.Webhooks.URLs, webhookSecret)
+
+	stopReaper := make(chan struct{})
+	reaper := retention.NewReaper(store, cfg.RetentionPolicy())
+	reaperDone := reaper.Run(cfg.Retention.Interval.Duration, stopReaper)
+
+	go reloadOnSIGHUP(configFile, reaper, notifier, authenticator)
+
+	metrics.Register(metrics.NewGaugeFunc(
+		"composer_composes",
+		"Number of composes by status.",
+		"status",
+		func() map[string]float64 {
+			counts := map[string]float64{"WAITING": 0, "RUNNING": 0, "FINISHED": 0, "FAILED": 0}
+			for _, compose := range store.GetAllComposes() {
+				counts[compose.QueueStatus]++
+			}
+			return counts
+		},
+	))
 
-	jobAPI := jobqueue.New(logger, store)
+	var auditLog *audit.Log
+	if cfg.Paths.AuditLog != "" {
+		auditLog, err = audit.Open(cfg.Paths.AuditLog)
+		if err != nil {
+			logger.Fatalf("cannot open audit log: %v", err)
+		}
+	}
+
+	jobAPI := jobqueue.New(logger, store, notifier)
+	jobAPI.SetAuditLog(auditLog)
+	if len(workerToken) > 0 {
+		jobAPI.RequireToken(workerToken)
+	}
 	weldrAPI := weldr.New(rpm, distribution, logger, store)
+	weldrAPI.RequireAuthentication(authenticator)
+	weldrAPI.SetAuditLog(auditLog)
+	if signer != nil {
+		jobAPI.SetManifestSigner(signer)
+	}
+
+	checker := health.NewChecker()
+	checker.Add("state", store.CheckState)
+	checker.Add("dnf", health.Cached(rpmmd.CheckDNF, time.Minute))
+	checker.Add("workers", func() error {
+		return jobAPI.CheckWorkers(cfg.Health.WorkerTimeout.Duration)
+	})
+	checker.Add("outputs-free-space", health.DiskSpace(cfg.Paths.Outputs, uint64(cfg.Health.MinFreeSpace)))
+	if cfg.Paths.Cache != "" {
+		checker.Add("cache-free-space", health.DiskSpace(cfg.Paths.Cache, uint64(cfg.Health.MinFreeSpace)))
+	}
+	weldrAPI.SetHealthChecker(checker)
+
+	var metricsServer *http.Server
+	if cfg.Listeners.Metrics != "" {
+		metricsListener, err := listen(cfg.Listeners.Metrics)
+		if err != nil {
+			logger.Fatalf("cannot listen: %v", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/", metrics.Handler())
+		mux.HandleFunc("/health/live", health.LiveHandler)
+		mux.Handle("/health/ready", checker)
+		metricsServer = &http.Server{Handler: mux}
+		go metricsServer.Serve(metricsListener)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	serveErrors := make(chan error, 2)
+	go func() { serveErrors <- jobAPI.Serve(jobListener) }()
+	go func() { serveErrors <- weldrAPI.Serve(weldrListener) }()
+
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		logger.Infof("received %v, shutting down", sig)
+	case err := <-serveErrors:
+		logger.Errorf("cannot serve: %v", err)
+		exitCode = 1
+	}
+
+	// stop accepting requests and let the ones in flight finish, so that
+	// they can still change the store
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Listeners.ShutdownTimeout.Duration)
+
+	var wg sync.WaitGroup
+	shutdown := func(name string, f func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(ctx); err != nil {
+				logger.Warningf("cannot shut down the %s API gracefully: %v", name, err)
+			}
+		}()
+	}
+	shutdown("weldr", weldrAPI.Shutdown)
+	shutdown("job queue", jobAPI.Shutdown)
+	if metricsServer != nil {
+		shutdown("metrics", metricsServer.Shutdown)
+	}
+	wg.Wait()
+
+	// finished composes may still have webhook deliveries in flight
+	delivered := make(chan struct{})
+	go func() {
+		notifier.Wait()
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		logger.Warningf("cannot deliver all webhook notifications: %v", ctx.Err())
+	}
+	cancel()
 
-	go jobAPI.Serve(jobListener)
-	weldrAPI.Serve(weldrListener)
+	// a running reap still deletes composes from the store
+	close(stopReaper)
+	<-reaperDone
+
+	err = store.Close()
+	if err != nil {
+		logger.Errorf("cannot write state: %v", err)
+		exitCode = 1
+	}
+
+	if auditLog != nil {
+		auditLog.Close()
+	}
+
+	os.Exit(exitCode)
+}
+
+// getListeners returns the listeners for the weldr and job queue APIs.
+// Sockets passed by systemd are preferred. Without socket activation,
+// composer listens on the addresses from the configuration.
+func getListeners(cfg *config.Config) (net.Listener, net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch len(listeners) {
+	case 2:
+		return listeners[0], listeners[1], nil
+	case 0:
+	default:
+		return nil, nil, fmt.Errorf("unexpected number of sockets: composer requires 2 of them, got %d", len(listeners))
+	}
+
+	weldrListener, err := listen(cfg.Listeners.Weldr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jobListener, err := listen(cfg.Listeners.JobQueue)
+	if err != nil {
+		weldrListener.Close()
+		return nil, nil, err
+	}
+
+	return weldrListener, jobListener, nil
+}
+
+func listen(address string) (net.Listener, error) {
+	network, address, err := config.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		err = os.MkdirAll(filepath.Dir(address), 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		// remove a socket left behind by a previous instance
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			err = os.Remove(address)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return net.Listen(network, address)
+}
+
+// loadConfig loads the configuration from path. A missing file at the
+// default location is not an error, so that composer works without any
+// configuration.
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if os.IsNotExist(err) && path == config.DefaultPath {
+		return config.Default(), nil
+	}
+	return cfg, err
+}
+
+// reloadOnSIGHUP re-reads the configuration file whenever composer receives
+// SIGHUP and applies the settings which can be changed at runtime. An
+// invalid file is reported and leaves the current settings in place.
+func reloadOnSIGHUP(path string, reaper *retention.Reaper, notifier *webhook.Notifier, authenticator *auth.Authenticator) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			logging.Default().Errorf("cannot reload configuration: %v", err)
+			continue
+		}
+
+		secret, err := config.ReadSecret(cfg.Webhooks.SecretFile)
+		if err != nil {
+			logging.Default().Errorf("cannot reload configuration: cannot read webhook secret: %v", err)
+			continue
+		}
+
+		authPolicy, err := cfg.AuthPolicy()
+		if err != nil {
+			logging.Default().Errorf("cannot reload configuration: %v", err)
+			continue
+		}
+
+		reaper.SetPolicy(cfg.RetentionPolicy())
+		authenticator.SetPolicy(authPolicy)
+		notifier.SetURLs(cfg.Webhooks.URLs)
+		notifier.SetSecret(secret)
+
+		logging.Default().Infof("reloaded configuration")
+	}
 }
@@ -36,7 +36,7 @@ func main() {
 		panic("unknown distro: " + distroArg)
 	}
 
-	pipeline, err := d.Pipeline(blueprint, format)
+	pipeline, err := d.Pipeline(blueprint, d.Repositories(), format)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -5,28 +5,55 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/osbuild/osbuild-composer/internal/config"
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue"
-	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/retention"
 )
 
+// errComposerUnavailable is returned by AddJob when composer is shutting
+// down. Another instance of composer may take over, so it is worth retrying.
+var errComposerUnavailable = errors.New("composer is unavailable")
+
 type ComposerClient struct {
 	client *http.Client
+	token  []byte
 }
 
-func NewClient() *ComposerClient {
+// NewClient creates a client for the job queue listening on address, which
+// is either the path of a unix socket or a TCP address.
+func NewClient(address string, token []byte) (*ComposerClient, error) {
+	network, address, err := config.ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &http.Client{
 		Transport: &http.Transport{
-			DialContext: func(context context.Context, network, addr string) (net.Conn, error) {
-				return net.Dial("unix", "/run/osbuild-composer/job.socket")
+			DialContext: func(context context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial(network, address)
 			},
 		},
 	}
-	return &ComposerClient{client}
+	return &ComposerClient{client, token}, nil
+}
+
+func (c *ComposerClient) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Content-Type", "application/json")
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+string(c.token))
+	}
+	return c.client.Do(req)
 }
 
 func (c *ComposerClient) AddJob() (*jobqueue.Job, error) {
@@ -35,13 +62,20 @@ func (c *ComposerClient) AddJob() (*jobqueue.Job, error) {
 
 	var b bytes.Buffer
 	json.NewEncoder(&b).Encode(request{})
-	response, err := c.client.Post("http://localhost/job-queue/v1/jobs", "application/json", &b)
+	req, err := http.NewRequest("POST", "http://localhost/job-queue/v1/jobs", &b)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusCreated {
+	if response.StatusCode == http.StatusServiceUnavailable {
+		return nil, errComposerUnavailable
+	} else if response.StatusCode != http.StatusCreated {
 		return nil, errors.New("couldn't create job")
 	}
 
@@ -54,16 +88,18 @@ func (c *ComposerClient) AddJob() (*jobqueue.Job, error) {
 	return job, nil
 }
 
-func (c *ComposerClient) UpdateJob(job *jobqueue.Job, status string, image *store.Image) error {
+func (c *ComposerClient) UpdateJob(job *jobqueue.Job, status *jobqueue.JobStatus) error {
 	var b bytes.Buffer
-	json.NewEncoder(&b).Encode(&jobqueue.JobStatus{status, image})
+	json.NewEncoder(&b).Encode(status)
 	req, err := http.NewRequest("PATCH", "http://localhost/job-queue/v1/jobs/"+job.ID.String(), &b)
 	if err != nil {
 		return err
 	}
+	if job.RequestID != "" {
+		req.Header.Set(logging.RequestIDHeader, job.RequestID)
+	}
 
-	req.Header.Set("Content-Type", "application/json")
-	response, err := c.client.Do(req)
+	response, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -76,40 +112,225 @@ func (c *ComposerClient) UpdateJob(job *jobqueue.Job, status string, image *stor
 	return nil
 }
 
-func handleJob(client *ComposerClient, distro distro.Distro) {
-	fmt.Println("Waiting for a new job...")
-	job, err := client.AddJob()
+// Number of times sending an artifact to composer is attempted, resuming
+// where the previous attempt was interrupted.
+const artifactAttempts = 5
+
+// UploadArtifact sends the file at path to composer, as the artifact name of
+// job. It resumes interrupted uploads, and starts over if composer reports
+// that the artifact does not match checksum.
+func (c *ComposerClient) UploadArtifact(job *jobqueue.Job, name, path, checksum string) error {
+	file, err := os.Open(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	defer file.Close()
 
-	client.UpdateJob(job, "RUNNING", nil)
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
-	fmt.Printf("Running job %s\n", job.ID.String())
-	image, err, errs := job.Run(distro)
+	url := "http://localhost/job-queue/v1/jobs/" + job.ID.String() + "/artifacts/" + name
+	var offset int64
+	for attempt := 1; ; attempt++ {
+		offset, err = c.putArtifact(url, file, offset, info.Size(), checksum, job.RequestID)
+		if err == nil || attempt == artifactAttempts {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		// the connection might have broken after composer stored more
+		// than it confirmed
+		if received, err := c.artifactOffset(url); err == nil {
+			offset = received
+		}
+	}
+}
+
+// putArtifact sends the part of file after offset to url. It returns the
+// offset at which composer expects the next part.
+func (c *ComposerClient) putArtifact(url string, file *os.File, offset, length int64, checksum, requestID string) (int64, error) {
+	req, err := http.NewRequest("PUT", url, io.NewSectionReader(file, offset, length-offset))
 	if err != nil {
-		client.UpdateJob(job, "FAILED", nil)
-		return
+		return offset, err
+	}
+	req.ContentLength = length - offset
+	req.Header.Set(jobqueue.ArtifactOffsetHeader, strconv.FormatInt(offset, 10))
+	req.Header.Set(jobqueue.ArtifactLengthHeader, strconv.FormatInt(length, 10))
+	req.Header.Set(jobqueue.ArtifactChecksumHeader, checksum)
+	if requestID != "" {
+		req.Header.Set(logging.RequestIDHeader, requestID)
+	}
+
+	response, err := c.do(req)
+	if err != nil {
+		return offset, err
+	}
+	defer response.Body.Close()
+
+	if received, err := strconv.ParseInt(response.Header.Get(jobqueue.ArtifactOffsetHeader), 10, 64); err == nil {
+		offset = received
 	}
 
-	for _, err := range errs {
+	switch response.StatusCode {
+	case http.StatusCreated:
+		return length, nil
+	case http.StatusAccepted:
+		return offset, fmt.Errorf("composer received only %d of %d bytes", offset, length)
+	default:
+		return offset, fmt.Errorf("composer replied with status %d", response.StatusCode)
+	}
+}
+
+func (c *ComposerClient) artifactOffset(url string) (int64, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := c.do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("composer replied with status %d", response.StatusCode)
+	}
+
+	return strconv.ParseInt(response.Header.Get(jobqueue.ArtifactOffsetHeader), 10, 64)
+}
+
+// waitForJob waits until composer assigns a job to this worker. While
+// composer is unavailable, it retries with an increasing delay.
+func waitForJob(logger *logging.Logger, client *ComposerClient) *jobqueue.Job {
+	const maxBackoff = time.Minute
+	backoff := time.Second
+
+	for {
+		logger.Debugf("waiting for a new job")
+		job, err := client.AddJob()
+		if err == nil {
+			return job
+		} else if err != errComposerUnavailable {
+			logger.Fatalf("cannot get a job from composer: %v", err)
+		}
+
+		logger.Warningf("%v, retrying in %v", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func handleJob(logger *logging.Logger, client *ComposerClient, distro distro.Distro, storeDir string, checksumAlgorithms []string) {
+	job := waitForJob(logger, client)
+
+	logger = logger.With("compose_id", job.ID)
+	if job.RequestID != "" {
+		logger = logger.With("request_id", job.RequestID)
+	}
+
+	update := func(status *jobqueue.JobStatus) {
+		err := client.UpdateJob(job, status)
 		if err != nil {
-			client.UpdateJob(job, "FAILED", nil)
+			logger.Errorf("cannot report job status %s: %v", status.Status, err)
+		}
+	}
+
+	update(&jobqueue.JobStatus{Status: "RUNNING"})
+
+	logger.Infof("running %s job", job.OutputType)
+	image, packages, err, results := job.Run(logger, distro, storeDir, client, checksumAlgorithms)
+	if err != nil {
+		logger.Errorf("cannot build image: %v", err)
+		update(&jobqueue.JobStatus{Status: "FAILED", Failure: jobqueue.FailureBuild})
+		return
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			logger.With("target", r.Name).Errorf("cannot export image: %s", r.Error)
+			update(&jobqueue.JobStatus{Status: "FAILED", Failure: jobqueue.FailureTarget, Targets: results})
 			return
 		}
 	}
 
-	client.UpdateJob(job, "FINISHED", image)
+	logger.Infof("job finished")
+	update(&jobqueue.JobStatus{Status: "FINISHED", Image: image, Targets: results, Packages: packages})
+}
+
+func collectGarbage(logger *logging.Logger, storeDir string, maxUnused time.Duration) {
+	removed, err := retention.CollectObjectStore(storeDir, maxUnused, time.Now())
+	if err == retention.ErrObjectStoreBusy {
+		// another worker is building, try again after the next job
+		logger.Debugf("not cleaning up the object store: %v", err)
+		return
+	} else if err != nil {
+		logger.Errorf("cannot clean up the object store: %v", err)
+		return
+	}
+	if removed > 0 {
+		logger.Infof("removed %d unused objects from the object store", removed)
+	}
 }
 
 func main() {
+	var address string
+	var storeDir string
+	var collectStore bool
+	var storeMaxUnused time.Duration
+	var tokenFile string
+	var logLevel string
+	var logFormat string
+	var sha512 bool
+	flag.StringVar(&address, "composer", "/run/osbuild-composer/job.socket", "Address of composer's job queue (path of a unix socket or host:port)")
+	flag.StringVar(&storeDir, "store", "/var/cache/osbuild-composer/store", "Directory of the osbuild object store")
+	flag.BoolVar(&collectStore, "collect-store", true, "Remove unused osbuild objects after each job")
+	flag.DurationVar(&storeMaxUnused, "store-max-unused", 7*24*time.Hour, "Remove osbuild objects which no job used for this long (0 keeps them until they are left behind by an interrupted build)")
+	flag.StringVar(&tokenFile, "token-file", "", "File containing the token used to authenticate to composer")
+	flag.StringVar(&logLevel, "log-level", "info", "Only log messages of at least this level (debug, info, warning or error)")
+	flag.StringVar(&logFormat, "log-format", "auto", "Log format: text, json, journal, or auto to use the journal under systemd")
+	flag.BoolVar(&sha512, "sha512", false, "Compute SHA-512 checksums of images in addition to SHA-256")
+	flag.Parse()
+
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		logging.Default().Fatalf("%v", err)
+	}
+	output, err := logging.NewOutput(logFormat, os.Stderr)
+	if err != nil {
+		logging.Default().Fatalf("cannot set up logging: %v", err)
+	}
+	logger := logging.New(output, level)
+	logging.SetDefault(logger)
+
 	distro, err := distro.FromHost()
 	if err != nil {
-		panic(err)
+		logger.Fatalf("cannot detect distro from host: %v", err)
+	}
+
+	token, err := config.ReadSecret(tokenFile)
+	if err != nil {
+		logger.Fatalf("cannot read token: %v", err)
+	}
+
+	client, err := NewClient(address, token)
+	if err != nil {
+		logger.Fatalf("cannot connect to composer: %v", err)
+	}
+
+	var checksumAlgorithms []string
+	if sha512 {
+		checksumAlgorithms = append(checksumAlgorithms, "sha512")
 	}
 
-	client := NewClient()
 	for {
-		handleJob(client, distro)
+		handleJob(logger, client, distro, storeDir, checksumAlgorithms)
+		if collectStore {
+			collectGarbage(logger, storeDir, storeMaxUnused)
+		}
 	}
 }
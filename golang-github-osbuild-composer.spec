@@ -31,6 +31,8 @@ BuildRequires:  golang(github.com/google/go-cmp/cmp)
 
 Requires: systemd
 Requires: osbuild
+Requires: rpm
+Requires: git-core
 
 Provides: osbuild-composer
 
@@ -53,6 +55,9 @@ install -m 0755 -vp dnf-json                                %{buildroot}%{_libex
 install -m 0755 -vd                                         %{buildroot}%{_unitdir}
 install -m 0644 -vp distribution/*.{service,socket}         %{buildroot}%{_unitdir}/
 
+install -m 0755 -vd                                         %{buildroot}%{_sysconfdir}/osbuild-composer
+install -m 0644 -vp distribution/osbuild-composer.toml      %{buildroot}%{_sysconfdir}/osbuild-composer/
+
 install -m 0755 -vd                                         %{buildroot}%{_sysusersdir}
 install -m 0644 -vp distribution/osbuild-composer.conf      %{buildroot}%{_sysusersdir}/
 
@@ -76,6 +81,8 @@ install -m 0755 -vd                                         %{buildroot}%{_local
 %{_libexecdir}/osbuild-composer/
 %{_unitdir}/*.{service,socket}
 %{_sysusersdir}/osbuild-composer.conf
+%dir %{_sysconfdir}/osbuild-composer
+%config(noreplace) %{_sysconfdir}/osbuild-composer/osbuild-composer.toml
 
 %changelog
 * Sun Dec 1 11:00:00 CEST 2019 Ondrej Budai <obudai@redhat.com> - 4-1
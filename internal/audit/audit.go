@@ -0,0 +1,150 @@
+// Package audit keeps a durable record of all operations which change the
+// state of composer, and who requested them.
+//
+// Records are appended to a file as one JSON object per line, which makes
+// the log easy to process with standard tools and robust against partial
+// writes.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Record describes one operation.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	// The user who requested the operation.
+	User string `json:"user"`
+	// What was done, for example "blueprint.new" or "compose.start".
+	Action string `json:"action"`
+	// The name or ID of the object the action was applied to.
+	Object  string            `json:"object"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// A Filter selects records from the log. Zero values match all records.
+type Filter struct {
+	Since  *time.Time
+	Until  *time.Time
+	User   string
+	Action string
+}
+
+func (f *Filter) matches(r *Record) bool {
+	if f.Since != nil && r.Timestamp.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && r.Timestamp.After(*f.Until) {
+		return false
+	}
+	if f.User != "" && r.User != f.User {
+		return false
+	}
+	if f.Action != "" && r.Action != f.Action {
+		return false
+	}
+	return true
+}
+
+// A Log is an append-only file of records.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// Open opens the log at path, creating it if it does not exist.
+func Open(path string) (*Log, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// terminate a line which was cut off by a crash, so that it does not
+	// swallow the next record
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() > 0 {
+		last := make([]byte, 1)
+		_, err = file.ReadAt(last, info.Size()-1)
+		if err == nil && last[0] != '\n' {
+			_, err = file.Write([]byte{'\n'})
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &Log{path: path, file: file}, nil
+}
+
+// Append writes r to the log and waits until it is on disk. The timestamp
+// is set to the current time if it is zero.
+func (l *Log) Append(r Record) error {
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.file.Write(line)
+	if err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+// Query returns all records matching filter, oldest first. Lines which
+// cannot be parsed, for example because composer crashed while writing
+// them, are skipped.
+func (l *Log) Query(filter Filter) ([]Record, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if json.Unmarshal(scanner.Bytes(), &r) != nil {
+			continue
+		}
+		if filter.matches(&r) {
+			records = append(records, r)
+		}
+	}
+
+	return records, scanner.Err()
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,85 @@
+package audit_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/audit"
+)
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit", "audit.log")
+	l, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("cannot open log: %v", err)
+	}
+
+	base := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	records := []audit.Record{
+		{base, "alice", "blueprint.new", "http-server", map[string]string{"version": "0.0.1"}},
+		{base.Add(time.Hour), "bob", "source.delete", "fish", nil},
+		{base.Add(2 * time.Hour), "alice", "compose.start", "30000000-0000-0000-0000-000000000000", nil},
+	}
+	for _, r := range records {
+		err = l.Append(r)
+		if err != nil {
+			t.Fatalf("cannot append record: %v", err)
+		}
+	}
+	l.Close()
+
+	// a crash in the middle of writing must not make the log unreadable
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	file.WriteString(`{"timestamp":"2019-12-`)
+	file.Close()
+
+	l, err = audit.Open(path)
+	if err != nil {
+		t.Fatalf("cannot reopen log: %v", err)
+	}
+	defer l.Close()
+
+	records = append(records, audit.Record{base.Add(3 * time.Hour), "bob", "blueprint.delete", "http-server", nil})
+	err = l.Append(records[3])
+	if err != nil {
+		t.Fatalf("cannot append record: %v", err)
+	}
+
+	since := base.Add(30 * time.Minute)
+	until := base.Add(90 * time.Minute)
+
+	var cases = []struct {
+		Filter   audit.Filter
+		Expected []audit.Record
+	}{
+		{audit.Filter{}, records},
+		{audit.Filter{Since: &since}, records[1:]},
+		{audit.Filter{Until: &until}, records[:2]},
+		{audit.Filter{Since: &since, Until: &until}, records[1:2]},
+		{audit.Filter{User: "alice"}, []audit.Record{records[0], records[2]}},
+		{audit.Filter{User: "bob"}, []audit.Record{records[1], records[3]}},
+		{audit.Filter{Action: "source.delete"}, records[1:2]},
+		{audit.Filter{User: "carol"}, []audit.Record{}},
+	}
+
+	for i, c := range cases {
+		got, err := l.Query(c.Filter)
+		if err != nil {
+			t.Errorf("%d: query failed: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("%d: expected %+v, got %+v", i, c.Expected, got)
+		}
+	}
+}
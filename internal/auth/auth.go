@@ -0,0 +1,218 @@
+// Package auth identifies the callers of composer's APIs and decides what
+// they may do.
+//
+// Callers on a unix socket are identified by the credentials of their
+// process (SO_PEERCRED). Callers on a TCP listener must present one of the
+// configured tokens as "Authorization: Bearer <token>".
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Role determines which API calls a caller may make. Each role includes
+// all permissions of the lower roles.
+type Role int
+
+const (
+	// None may not call the API at all.
+	None Role = iota
+	// Viewer may read blueprints, sources and composes.
+	Viewer
+	// Composer may additionally change blueprints and start, cancel and
+	// delete composes.
+	Composer
+	// Admin may additionally change sources and upload providers.
+	Admin
+)
+
+var roleNames = []string{"none", "viewer", "composer", "admin"}
+
+func (r Role) String() string {
+	if r < None || int(r) >= len(roleNames) {
+		return "invalid"
+	}
+	return roleNames[r]
+}
+
+// ParseRole returns the role called name.
+func ParseRole(name string) (Role, error) {
+	for i, n := range roleNames {
+		if n == name {
+			return Role(i), nil
+		}
+	}
+	return None, fmt.Errorf("unknown role: %s", name)
+}
+
+// An Identity is an authenticated caller.
+type Identity struct {
+	User string
+	Role Role
+}
+
+// A Token allows callers on TCP listeners to authenticate as User.
+type Token struct {
+	Secret []byte
+	User   string
+	Role   Role
+}
+
+// A Policy maps callers to roles.
+type Policy struct {
+	// Role of local users which are not mentioned in Users and are not in
+	// any of the groups in Groups.
+	DefaultRole Role
+	// Roles of local users by name.
+	Users map[string]Role
+	// Roles of the members of local groups by group name. Users get the
+	// highest role of all their groups.
+	Groups map[string]Role
+	// Tokens accepted on TCP listeners.
+	Tokens []Token
+}
+
+// An Authenticator identifies callers according to its policy.
+type Authenticator struct {
+	mu     sync.RWMutex
+	policy Policy
+
+	// overridable for tests
+	lookupUser func(uid uint32) (string, []string, error)
+}
+
+func NewAuthenticator(policy Policy) *Authenticator {
+	return &Authenticator{
+		policy:     policy,
+		lookupUser: lookupUser,
+	}
+}
+
+// SetPolicy replaces the policy used for subsequent requests.
+func (a *Authenticator) SetPolicy(policy Policy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.policy = policy
+}
+
+// Identify returns the identity of the caller of request. Requests must have
+// been received on a listener wrapped by Listener.
+func (a *Authenticator) Identify(request *http.Request) (*Identity, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if addr, ok := request.Context().Value(http.LocalAddrContextKey).(*peerAddr); ok {
+		return a.identifyPeer(addr.creds)
+	}
+
+	return a.identifyToken(request.Header.Get("Authorization"))
+}
+
+func (a *Authenticator) identifyPeer(creds *peerCredentials) (*Identity, error) {
+	if creds.err != nil {
+		return nil, fmt.Errorf("cannot read peer credentials: %v", creds.err)
+	}
+
+	name, groups, err := a.lookupUser(creds.uid)
+	if err != nil {
+		return nil, err
+	}
+
+	// root and the user composer runs as can change all state anyway
+	if creds.uid == 0 || creds.uid == uint32(selfUID) {
+		return &Identity{name, Admin}, nil
+	}
+
+	if role, exists := a.policy.Users[name]; exists {
+		return &Identity{name, role}, nil
+	}
+
+	role := None
+	member := false
+	for _, group := range groups {
+		if r, exists := a.policy.Groups[group]; exists {
+			member = true
+			if r > role {
+				role = r
+			}
+		}
+	}
+	if !member {
+		role = a.policy.DefaultRole
+	}
+
+	return &Identity{name, role}, nil
+}
+
+func (a *Authenticator) identifyToken(header string) (*Identity, error) {
+	const prefix = "Bearer "
+
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errors.New("missing authorization token")
+	}
+	secret := []byte(header[len(prefix):])
+
+	for _, token := range a.policy.Tokens {
+		if subtle.ConstantTimeCompare(secret, token.Secret) == 1 {
+			return &Identity{token.User, token.Role}, nil
+		}
+	}
+
+	return nil, errors.New("invalid authorization token")
+}
+
+func lookupUser(uid uint32) (string, []string, error) {
+	id := strconv.FormatUint(uint64(uid), 10)
+
+	u, err := user.LookupId(id)
+	if err != nil {
+		// a user without an entry in the user database, e.g. from a
+		// container, is identified by its uid
+		if _, ok := err.(user.UnknownUserIdError); ok {
+			return id, nil, nil
+		}
+		return "", nil, err
+	}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return "", nil, err
+	}
+
+	var groups []string
+	for _, gid := range gids {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			continue
+		}
+		groups = append(groups, g.Name)
+	}
+
+	return u.Username, groups, nil
+}
+
+type contextKey int
+
+const (
+	identityKey contextKey = iota
+)
+
+// WithIdentity returns a copy of ctx which carries identity.
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
+
+// IdentityFromContext returns the identity stored in ctx by WithIdentity, or
+// nil if there is none.
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey).(*Identity)
+	return identity
+}
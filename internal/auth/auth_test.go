@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIdentifyPeer(t *testing.T) {
+	a := NewAuthenticator(Policy{
+		DefaultRole: Viewer,
+		Users:       map[string]Role{"alice": Admin, "mallory": None},
+		Groups:      map[string]Role{"weldr": Composer, "wheel": Admin, "guests": None},
+	})
+	a.lookupUser = func(uid uint32) (string, []string, error) {
+		users := map[uint32]struct {
+			name   string
+			groups []string
+		}{
+			0:    {"root", nil},
+			1000: {"alice", []string{"guests"}},
+			1001: {"bob", []string{"weldr"}},
+			1002: {"carol", []string{"weldr", "wheel"}},
+			1003: {"dave", []string{"users"}},
+			1004: {"mallory", []string{"wheel"}},
+			1005: {"eve", []string{"guests"}},
+		}
+		u := users[uid]
+		return u.name, u.groups, nil
+	}
+
+	var cases = []struct {
+		UID          uint32
+		ExpectedUser string
+		ExpectedRole Role
+	}{
+		{0, "root", Admin},
+		{1000, "alice", Admin},
+		{1001, "bob", Composer},
+		{1002, "carol", Admin},
+		{1003, "dave", Viewer},
+		{1004, "mallory", None},
+		{1005, "eve", None},
+	}
+
+	for _, c := range cases {
+		identity, err := a.identifyPeer(&peerCredentials{uid: c.UID})
+		if err != nil {
+			t.Errorf("uid %d: unexpected error: %v", c.UID, err)
+			continue
+		}
+		if identity.User != c.ExpectedUser || identity.Role != c.ExpectedRole {
+			t.Errorf("uid %d: expected %s as %s, got %s as %s", c.UID, c.ExpectedUser, c.ExpectedRole, identity.User, identity.Role)
+		}
+	}
+}
+
+func TestIdentifyToken(t *testing.T) {
+	a := NewAuthenticator(Policy{
+		DefaultRole: Admin,
+		Tokens: []Token{
+			{[]byte("secret-1"), "ci", Composer},
+			{[]byte("secret-2"), "dashboard", Viewer},
+		},
+	})
+
+	var cases = []struct {
+		Authorization string
+		ExpectedUser  string
+		ExpectedRole  Role
+	}{
+		{"Bearer secret-1", "ci", Composer},
+		{"Bearer secret-2", "dashboard", Viewer},
+		{"Bearer secret-3", "", None},
+		{"secret-1", "", None},
+		{"", "", None},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest("GET", "/api/status", nil)
+		if c.Authorization != "" {
+			request.Header.Set("Authorization", c.Authorization)
+		}
+
+		identity, err := a.Identify(request)
+		if c.ExpectedUser == "" {
+			if err == nil {
+				t.Errorf("%#v: expected an error, got %+v", c.Authorization, identity)
+			}
+			continue
+		}
+		if err != nil || identity.User != c.ExpectedUser || identity.Role != c.ExpectedRole {
+			t.Errorf("%#v: expected %s as %s, got %+v, %v", c.Authorization, c.ExpectedUser, c.ExpectedRole, identity, err)
+		}
+	}
+}
+
+func TestPeerCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "api.socket"))
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+
+	a := NewAuthenticator(Policy{DefaultRole: None})
+	var identity *Identity
+	var identifyErr error
+	server := http.Server{
+		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			identity, identifyErr = a.Identify(request)
+		}),
+	}
+	go server.Serve(Listener(listener))
+	defer server.Close()
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", filepath.Join(dir, "api.socket"))
+			},
+		},
+	}
+	response, err := client.Get("http://localhost/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	response.Body.Close()
+
+	// the test talks to itself, which is always allowed everything
+	if identifyErr != nil || identity == nil || identity.Role != Admin {
+		t.Errorf("expected to be identified as admin, got %+v, %v", identity, identifyErr)
+	}
+}
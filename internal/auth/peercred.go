@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+var selfUID = os.Getuid()
+
+type peerCredentials struct {
+	uid uint32
+	err error
+}
+
+// Listener wraps listener, so that the credentials of the process on the
+// other end of each unix socket connection are available to Identify.
+func Listener(listener net.Listener) net.Listener {
+	return &peerListener{listener}
+}
+
+type peerListener struct {
+	net.Listener
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+
+	return &peerConn{conn, &peerAddr{conn.LocalAddr(), readPeerCredentials(unixConn)}}, nil
+}
+
+// A peerConn carries the credentials of its peer in its local address,
+// which net/http stores in the context of every request it receives on the
+// connection (http.LocalAddrContextKey).
+type peerConn struct {
+	net.Conn
+	addr *peerAddr
+}
+
+func (c *peerConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+type peerAddr struct {
+	net.Addr
+	creds *peerCredentials
+}
+
+func readPeerCredentials(conn *net.UnixConn) *peerCredentials {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return &peerCredentials{err: err}
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return &peerCredentials{err: err}
+	}
+	if credErr != nil {
+		return &peerCredentials{err: credErr}
+	}
+
+	return &peerCredentials{uid: ucred.Uid}
+}
@@ -0,0 +1,378 @@
+package blueprint
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// MaxArchiveSize is the maximum number of bytes ReadArchive extracts from an
+// archive.
+const MaxArchiveSize = 64 * 1024 * 1024
+
+// An Archived blueprint is a blueprint together with its history, as it is
+// stored in a blueprint archive.
+type Archived struct {
+	Blueprint Blueprint
+	// Oldest first. May be empty if the archive only contains the
+	// blueprint itself.
+	Changes []Change
+}
+
+// archivedChanges is the content of a "<name>.changes.json" file. The name
+// is stored explicitly, because file names cannot represent all blueprint
+// names.
+type archivedChanges struct {
+	Name    string           `json:"name"`
+	Changes []archivedChange `json:"changes"`
+}
+
+// archivedChange is a Change including its blueprint, which Change does not
+// marshal.
+type archivedChange struct {
+	Change
+	Blueprint Blueprint `json:"blueprint"`
+}
+
+// FileName returns the name of the file in which lorax stores the blueprint
+// called name.
+func FileName(name string) string {
+	return strings.Replace(name, " ", "-", -1) + ".toml"
+}
+
+// WriteArchive writes blueprints to w as a tar archive, which contains a
+// TOML file named by FileName for each blueprint, and a JSON file with the
+// same name, but ending in ".changes.json", with its history.
+func WriteArchive(w io.Writer, blueprints []Archived) error {
+	tw := tar.NewWriter(w)
+
+	for _, a := range blueprints {
+		var bp bytes.Buffer
+		err := toml.NewEncoder(&bp).Encode(a.Blueprint)
+		if err != nil {
+			return err
+		}
+
+		changes := archivedChanges{Name: a.Blueprint.Name, Changes: []archivedChange{}}
+		for _, c := range a.Changes {
+			changes.Changes = append(changes.Changes, archivedChange{c, c.Blueprint})
+		}
+		history, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		filename := FileName(a.Blueprint.Name)
+		err = writeTarFile(tw, filename, bp.Bytes())
+		if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, strings.TrimSuffix(filename, ".toml")+".changes.json", history)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(content)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	return err
+}
+
+// ReadArchive reads blueprints from a tar archive, which may be compressed
+// with gzip. It accepts archives written by WriteArchive, plain directories
+// of TOML blueprints, and lorax blueprint repositories, which are git
+// repositories with one TOML file per blueprint. The history of a
+// blueprint in a git repository is read from the commits which changed its
+// file.
+func ReadArchive(r io.Reader) ([]Archived, error) {
+	dir, err := ioutil.TempDir("", "composer-blueprints-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	err = extractArchive(r, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	gitDir, err := findGitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if gitDir != "" {
+		return readGitRepository(gitDir)
+	}
+
+	return readDirectory(dir)
+}
+
+// extractArchive extracts the regular files and directories in the tar
+// archive r into dir. It refuses entries which would end up outside of dir,
+// and archives with more than MaxArchiveSize bytes of content.
+func extractArchive(r io.Reader, dir string) error {
+	remaining := int64(MaxArchiveSize)
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive: %v", err)
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid archive: file outside of the archive: %s", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg, tar.TypeRegA:
+			var n int64
+			n, err = extractFile(tr, target, remaining)
+			remaining -= n
+		default:
+			// links and special files are never part of a blueprint
+			// archive or a git repository
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractFile copies r to target and returns the number of bytes written.
+// It fails if r contains more than limit bytes.
+func extractFile(r io.Reader, target string, limit int64) (int64, error) {
+	err := os.MkdirAll(filepath.Dir(target), 0700)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n, err := io.CopyN(f, r, limit+1)
+	if err == io.EOF {
+		err = nil
+	} else if err == nil {
+		err = fmt.Errorf("invalid archive: content is larger than %d bytes", MaxArchiveSize)
+	}
+	return n, err
+}
+
+// findGitDir returns the first directory below dir which looks like a git
+// repository, or an empty string if there is none.
+func findGitDir(dir string) (string, error) {
+	var gitDir string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || gitDir != "" || !info.IsDir() {
+			return err
+		}
+		head, err1 := os.Stat(filepath.Join(p, "HEAD"))
+		objects, err2 := os.Stat(filepath.Join(p, "objects"))
+		if err1 == nil && err2 == nil && !head.IsDir() && objects.IsDir() {
+			gitDir = p
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return gitDir, err
+}
+
+// readDirectory reads all blueprints in dir and its subdirectories, and the
+// histories written by WriteArchive.
+func readDirectory(dir string) ([]Archived, error) {
+	var blueprints []Archived
+	changes := make(map[string][]Change)
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		switch {
+		case strings.HasSuffix(p, ".changes.json"):
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			var history archivedChanges
+			err = json.Unmarshal(data, &history)
+			if err != nil {
+				return fmt.Errorf("invalid history %s: %v", filepath.Base(p), err)
+			}
+			for _, c := range history.Changes {
+				c.Change.Blueprint = c.Blueprint
+				changes[history.Name] = append(changes[history.Name], c.Change)
+			}
+
+		case strings.HasSuffix(p, ".toml"):
+			var bp Blueprint
+			_, err := toml.DecodeFile(p, &bp)
+			if err != nil {
+				return fmt.Errorf("invalid blueprint %s: %v", filepath.Base(p), err)
+			}
+			blueprints = append(blueprints, Archived{Blueprint: bp})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range blueprints {
+		blueprints[i].Changes = changes[blueprints[i].Blueprint.Name]
+	}
+	return blueprints, nil
+}
+
+// readGitRepository reads the blueprints at HEAD of the git repository in
+// gitDir, with one change for every commit which modified them.
+func readGitRepository(gitDir string) ([]Archived, error) {
+	// the repository comes from an untrusted archive: its configuration
+	// and hooks can make git run arbitrary commands, and alternates can
+	// make it read objects from anywhere on the host
+	for _, name := range []string{"config", "hooks", "objects/info/alternates", "objects/info/http-alternates"} {
+		err := os.RemoveAll(filepath.Join(gitDir, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := git(gitDir, "ls-tree", "--name-only", "-z", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	var blueprints []Archived
+	for _, file := range strings.Split(string(files), "\x00") {
+		if !strings.HasSuffix(file, ".toml") {
+			continue
+		}
+
+		changes, err := readGitHistory(gitDir, file)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		blueprints = append(blueprints, Archived{changes[len(changes)-1].Blueprint, changes})
+	}
+
+	sort.Slice(blueprints, func(i, j int) bool {
+		return blueprints[i].Blueprint.Name < blueprints[j].Blueprint.Name
+	})
+	return blueprints, nil
+}
+
+// readGitHistory returns a change for every commit which modified file,
+// oldest first.
+func readGitHistory(gitDir, file string) ([]Change, error) {
+	// records are separated by RS, fields by US
+	log, err := git(gitDir, "log", "--reverse", "--format=%H%x1f%at%x1f%an%x1f%B%x1e", "HEAD", "--", file)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	for _, record := range strings.Split(string(log), "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		content, err := git(gitDir, "show", fields[0]+":"+file)
+		if err != nil {
+			// the commit removed the file
+			continue
+		}
+		var bp Blueprint
+		_, err = toml.Decode(string(content), &bp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blueprint %s in commit %s: %v", file, fields[0], err)
+		}
+
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time of commit %s: %v", fields[0], err)
+		}
+
+		changes = append(changes, Change{
+			Commit:    fields[0],
+			Message:   strings.TrimSpace(fields[3]),
+			Timestamp: time.Unix(seconds, 0).UTC().Format("2006-01-02T15:04:05Z"),
+			User:      fields[2],
+			Blueprint: bp,
+		})
+	}
+
+	return changes, nil
+}
+
+func git(gitDir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", gitDir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_ALTERNATE_OBJECT_DIRECTORIES=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return nil, errors.New("cannot read git repository: " + message)
+	}
+	return output, nil
+}
@@ -0,0 +1,165 @@
+package blueprint_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func TestArchive(t *testing.T) {
+	v1 := blueprint.Blueprint{Name: "my blueprint", Description: "first", Version: "0.0.1", Packages: []blueprint.Package{}}
+	v2 := blueprint.Blueprint{Name: "my blueprint", Description: "second", Version: "0.0.2", Packages: []blueprint.Package{{Name: "tmux", Version: "*"}}}
+	archived := []blueprint.Archived{
+		{
+			Blueprint: v2,
+			Changes: []blueprint.Change{
+				{Commit: "a", Message: "first", Timestamp: "2019-12-01T10:00:00Z", User: "alice", Blueprint: v1},
+				{Commit: "b", Message: "second", Timestamp: "2019-12-02T10:00:00Z", Blueprint: v2},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := blueprint.WriteArchive(&buf, archived)
+	if err != nil {
+		t.Fatalf("cannot write archive: %v", err)
+	}
+
+	read, err := blueprint.ReadArchive(&buf)
+	if err != nil {
+		t.Fatalf("cannot read archive: %v", err)
+	}
+	if diff := cmp.Diff(archived, read); diff != "" {
+		t.Errorf("archive did not round-trip (-expected +got):\n%s", diff)
+	}
+}
+
+func TestArchiveOutsideFiles(t *testing.T) {
+	for _, name := range []string{"../evil.toml", "/etc/evil.toml", "a/../../evil.toml"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 0})
+		tw.Close()
+
+		if _, err := blueprint.ReadArchive(&buf); err == nil {
+			t.Errorf("archive with file %s was accepted", name)
+		}
+	}
+}
+
+func TestArchiveTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "large.toml", Mode: 0644, Size: blueprint.MaxArchiveSize + 1})
+	zeros := make([]byte, 1024*1024)
+	for n := int64(0); n < blueprint.MaxArchiveSize+1; n += int64(len(zeros)) {
+		if rest := blueprint.MaxArchiveSize + 1 - n; rest < int64(len(zeros)) {
+			zeros = zeros[:rest]
+		}
+		tw.Write(zeros)
+	}
+	tw.Close()
+	gz.Close()
+
+	if _, err := blueprint.ReadArchive(&buf); err == nil {
+		t.Errorf("archive larger than %d bytes was accepted", blueprint.MaxArchiveSize)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	var cases = []struct {
+		Name  string
+		Valid bool
+	}{
+		{"my blueprint", true},
+		{"http-server", true},
+		{"", false},
+		{"..", false},
+		{"a/b", false},
+		{"a\nb", false},
+	}
+
+	for _, c := range cases {
+		err := blueprint.ValidateName(c.Name)
+		if (err == nil) != c.Valid {
+			t.Errorf("%q: unexpected result %v", c.Name, err)
+		}
+	}
+}
+
+func TestArchiveGitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "composer-blueprint-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	repo := filepath.Join(dir, "repo")
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=alice", "GIT_COMMITTER_EMAIL=alice@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, output)
+		}
+	}
+	commit := func(content, message string) {
+		err := ioutil.WriteFile(filepath.Join(repo, "http-server.toml"), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("cannot write blueprint: %v", err)
+		}
+		run("add", "http-server.toml")
+		run("commit", "-q", "-m", message)
+	}
+
+	os.Mkdir(repo, 0755)
+	run("init", "-q")
+	commit("name = \"http-server\"\nversion = \"0.0.1\"\n", "Recipe http-server, version 0.0.1 saved.")
+	commit("name = \"http-server\"\nversion = \"0.0.2\"\n[[packages]]\nname = \"httpd\"\n", "Recipe http-server, version 0.0.2 saved.")
+
+	archive := filepath.Join(dir, "repo.tar")
+	if output, err := exec.Command("tar", "-C", dir, "-cf", archive, "repo").CombinedOutput(); err != nil {
+		t.Fatalf("cannot create archive: %v\n%s", err, output)
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatalf("cannot open archive: %v", err)
+	}
+	defer f.Close()
+
+	read, err := blueprint.ReadArchive(f)
+	if err != nil {
+		t.Fatalf("cannot read archive: %v", err)
+	}
+	if len(read) != 1 {
+		t.Fatalf("expected one blueprint, got %+v", read)
+	}
+	if bp := read[0].Blueprint; bp.Name != "http-server" || bp.Version != "0.0.2" || len(bp.Packages) != 1 {
+		t.Errorf("unexpected blueprint %+v", bp)
+	}
+
+	changes := read[0].Changes
+	if len(changes) != 2 {
+		t.Fatalf("expected two changes, got %+v", changes)
+	}
+	if changes[0].Message != "Recipe http-server, version 0.0.1 saved." || changes[0].User != "alice" || changes[0].Blueprint.Version != "0.0.1" {
+		t.Errorf("unexpected first change %+v", changes[0])
+	}
+	if changes[1].Blueprint.Version != "0.0.2" {
+		t.Errorf("unexpected second change %+v", changes[1])
+	}
+}
@@ -1,6 +1,12 @@
 // Package blueprint contains primitives for representing weldr blueprints
 package blueprint
 
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
 // A Blueprint is a high-level description of an image.
 type Blueprint struct {
 	Name        string    `json:"name" toml:"name"`
@@ -13,6 +19,45 @@ type Blueprint struct {
 	Groups  []Group   `json:"groups" toml:"groups,omitempty"`
 
 	Customizations *Customizations `json:"customizations,omitempty" toml:"customizations,omitempty"`
+
+	// Name of another blueprint which this one is based on. See Resolve.
+	Include string `json:"include,omitempty" toml:"include,omitempty"`
+
+	// Variables which are referenced from the customizations. See
+	// ApplyVariables.
+	Variables []Variable `json:"variables,omitempty" toml:"variables,omitempty"`
+}
+
+// Validate returns an error if b cannot be stored, because its name, one of
+// its customizations or one of its variables is invalid.
+func (b *Blueprint) Validate() error {
+	err := ValidateName(b.Name)
+	if err != nil {
+		return err
+	}
+	err = b.ValidateFiles()
+	if err != nil {
+		return err
+	}
+	return b.ValidateVariables()
+}
+
+// ValidateName returns an error if name cannot be used as the name of a
+// blueprint. Names are part of the routes of the API and the names of the
+// files blueprints are exported to.
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("blueprint name must not be empty")
+	}
+	if name == "." || name == ".." {
+		return fmt.Errorf("invalid blueprint name: %s", name)
+	}
+	for _, r := range name {
+		if r == '/' || unicode.IsControl(r) {
+			return fmt.Errorf("invalid character in blueprint name: %q", r)
+		}
+	}
+	return nil
 }
 
 type Change struct {
@@ -20,6 +65,7 @@ type Change struct {
 	Message   string    `json:"message" toml:"message"`
 	Revision  *string   `json:"revision" toml:"revision"`
 	Timestamp string    `json:"timestamp" toml:"timestamp"`
+	User      string    `json:"user,omitempty" toml:"user,omitempty"`
 	Blueprint Blueprint `json:"-" toml:"-"`
 }
 
@@ -156,6 +202,22 @@ func (b *Blueprint) GetServices() *ServicesCustomization {
 	return b.Customizations.Services
 }
 
+func (b *Blueprint) GetDirectories() []DirectoryCustomization {
+	if b.Customizations == nil {
+		return nil
+	}
+
+	return b.Customizations.Directories
+}
+
+func (b *Blueprint) GetFiles() []FileCustomization {
+	if b.Customizations == nil {
+		return nil
+	}
+
+	return b.Customizations.Files
+}
+
 func (p Package) ToNameVersion() string {
 	// Omit version to prevent all packages with prefix of name to be installed
 	if p.Version == "*" {
@@ -10,6 +10,9 @@ type Customizations struct {
 	Locale   *LocaleCustomization   `json:"locale,omitempty" toml:"locale,omitempty"`
 	Firewall *FirewallCustomization `json:"firewall,omitempty" toml:"firewall,omitempty"`
 	Services *ServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
+
+	Directories []DirectoryCustomization `json:"directories,omitempty" toml:"directories,omitempty"`
+	Files       []FileCustomization      `json:"files,omitempty" toml:"files,omitempty"`
 }
 
 type KernelCustomization struct {
@@ -63,6 +66,29 @@ type ServicesCustomization struct {
 	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
 }
 
+// A DirectoryCustomization creates a directory in the image. Its parent
+// directories are created as well, if they do not exist.
+type DirectoryCustomization struct {
+	Path string `json:"path" toml:"path"`
+	// Octal permissions, such as "0755".
+	Mode  string `json:"mode,omitempty" toml:"mode,omitempty"`
+	Owner string `json:"owner,omitempty" toml:"owner,omitempty"`
+	Group string `json:"group,omitempty" toml:"group,omitempty"`
+}
+
+// A FileCustomization creates a file in the image, or replaces one which is
+// installed by a package. Its content is either set as text in Content, or
+// encoded as base64 in Data.
+type FileCustomization struct {
+	Path string `json:"path" toml:"path"`
+	// Octal permissions, such as "0644".
+	Mode    string `json:"mode,omitempty" toml:"mode,omitempty"`
+	Owner   string `json:"owner,omitempty" toml:"owner,omitempty"`
+	Group   string `json:"group,omitempty" toml:"group,omitempty"`
+	Content string `json:"content,omitempty" toml:"content,omitempty"`
+	Data    string `json:"data,omitempty" toml:"data,omitempty"`
+}
+
 type CustomizationError struct {
 	Message string
 }
@@ -0,0 +1,283 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// A Difference is a change to one part of a blueprint, such as its version
+// or one of its packages. Section names that part, and Old and New hold its
+// value in the older and newer blueprint. Old is nil for parts which were
+// added, and New is nil for parts which were removed.
+type Difference struct {
+	Section string
+	Old     interface{}
+	New     interface{}
+}
+
+// MarshalJSON marshals d in the format of lorax, which nests each value in
+// an object keyed by the section:
+//
+//	{"old": {"Package": {"name": "tmux", "version": "*"}}, "new": null}
+func (d Difference) MarshalJSON() ([]byte, error) {
+	wrap := func(value interface{}) map[string]interface{} {
+		if value == nil {
+			return nil
+		}
+		return map[string]interface{}{d.Section: value}
+	}
+
+	return json.Marshal(struct {
+		New map[string]interface{} `json:"new"`
+		Old map[string]interface{} `json:"old"`
+	}{wrap(d.New), wrap(d.Old)})
+}
+
+// Diff returns the differences between the blueprints a and b, in the order
+// of the fields of Blueprint.
+//
+// Lists are compared element by element: packages, modules, package groups,
+// users, user groups and variables by name, SSH keys by user, directories
+// and files by path, and lists of strings, such as firewall ports or enabled
+// services, by value. Each element that was added, removed or changed is a
+// separate difference, and its section is the name of the list in singular,
+// such as "Package" or "Customizations.user". All other fields are compared
+// as a whole, in sections such as "Version" or
+// "Customizations.timezone.timezone".
+func Diff(a, b *Blueprint) []Difference {
+	d := differ{}
+
+	d.value("Name", a.Name, b.Name)
+	d.value("Description", a.Description, b.Description)
+	d.value("Version", a.Version, b.Version)
+	d.packages("Module", a.Modules, b.Modules)
+	d.packages("Package", a.Packages, b.Packages)
+
+	var oldGroups, newGroups []keyed
+	for _, g := range a.Groups {
+		oldGroups = append(oldGroups, keyed{g.Name, g})
+	}
+	for _, g := range b.Groups {
+		newGroups = append(newGroups, keyed{g.Name, g})
+	}
+	d.keyed("Group", oldGroups, newGroups)
+
+	d.customizations(a.Customizations, b.Customizations)
+	d.value("Include", a.Include, b.Include)
+
+	var oldVariables, newVariables []keyed
+	for _, v := range a.Variables {
+		oldVariables = append(oldVariables, keyed{v.Name, v})
+	}
+	for _, v := range b.Variables {
+		newVariables = append(newVariables, keyed{v.Name, v})
+	}
+	d.keyed("Variable", oldVariables, newVariables)
+
+	return d.differences
+}
+
+type differ struct {
+	differences []Difference
+}
+
+// A keyed value is an element of a list which is identified by key.
+type keyed struct {
+	key   string
+	value interface{}
+}
+
+// value adds a difference if old and new are not equal. Nil pointers and
+// zero values are treated as missing.
+func (d *differ) value(section string, old, new interface{}) {
+	old, new = normalize(old), normalize(new)
+	if !reflect.DeepEqual(old, new) {
+		d.differences = append(d.differences, Difference{section, old, new})
+	}
+}
+
+// keyed adds a difference for each element of new which was added or
+// changed, followed by one for each element of old which was removed.
+func (d *differ) keyed(section string, old, new []keyed) {
+	oldIndex := make(map[string]interface{}, len(old))
+	for _, element := range old {
+		oldIndex[element.key] = element.value
+	}
+	newIndex := make(map[string]bool, len(new))
+
+	for _, element := range new {
+		newIndex[element.key] = true
+		oldValue, found := oldIndex[element.key]
+		if !found {
+			d.differences = append(d.differences, Difference{section, nil, element.value})
+		} else if !reflect.DeepEqual(oldValue, element.value) {
+			d.differences = append(d.differences, Difference{section, oldValue, element.value})
+		}
+	}
+
+	for _, element := range old {
+		if !newIndex[element.key] {
+			d.differences = append(d.differences, Difference{section, element.value, nil})
+		}
+	}
+}
+
+func (d *differ) packages(section string, old, new []Package) {
+	var oldPackages, newPackages []keyed
+	for _, p := range old {
+		oldPackages = append(oldPackages, keyed{p.Name, p})
+	}
+	for _, p := range new {
+		newPackages = append(newPackages, keyed{p.Name, p})
+	}
+	d.keyed(section, oldPackages, newPackages)
+}
+
+func (d *differ) strings(section string, old, new []string) {
+	var oldStrings, newStrings []keyed
+	for _, s := range old {
+		oldStrings = append(oldStrings, keyed{s, s})
+	}
+	for _, s := range new {
+		newStrings = append(newStrings, keyed{s, s})
+	}
+	d.keyed(section, oldStrings, newStrings)
+}
+
+func (d *differ) customizations(a, b *Customizations) {
+	if a == nil {
+		a = &Customizations{}
+	}
+	if b == nil {
+		b = &Customizations{}
+	}
+
+	d.value("Customizations.hostname", a.Hostname, b.Hostname)
+
+	var oldKernel, newKernel string
+	if a.Kernel != nil {
+		oldKernel = a.Kernel.Append
+	}
+	if b.Kernel != nil {
+		newKernel = b.Kernel.Append
+	}
+	d.value("Customizations.kernel.append", oldKernel, newKernel)
+
+	var oldKeys, newKeys []keyed
+	for _, k := range a.SSHKey {
+		oldKeys = append(oldKeys, keyed{k.User, k})
+	}
+	for _, k := range b.SSHKey {
+		newKeys = append(newKeys, keyed{k.User, k})
+	}
+	d.keyed("Customizations.sshkey", oldKeys, newKeys)
+
+	var oldUsers, newUsers []keyed
+	for _, u := range a.User {
+		oldUsers = append(oldUsers, keyed{u.Name, u})
+	}
+	for _, u := range b.User {
+		newUsers = append(newUsers, keyed{u.Name, u})
+	}
+	d.keyed("Customizations.user", oldUsers, newUsers)
+
+	var oldGroups, newGroups []keyed
+	for _, g := range a.Group {
+		oldGroups = append(oldGroups, keyed{g.Name, g})
+	}
+	for _, g := range b.Group {
+		newGroups = append(newGroups, keyed{g.Name, g})
+	}
+	d.keyed("Customizations.group", oldGroups, newGroups)
+
+	var oldTimezone, newTimezone TimezoneCustomization
+	if a.Timezone != nil {
+		oldTimezone = *a.Timezone
+	}
+	if b.Timezone != nil {
+		newTimezone = *b.Timezone
+	}
+	d.value("Customizations.timezone.timezone", oldTimezone.Timezone, newTimezone.Timezone)
+	d.strings("Customizations.timezone.ntpserver", oldTimezone.NTPServers, newTimezone.NTPServers)
+
+	var oldLocale, newLocale LocaleCustomization
+	if a.Locale != nil {
+		oldLocale = *a.Locale
+	}
+	if b.Locale != nil {
+		newLocale = *b.Locale
+	}
+	// the order of languages matters, because the first one is the
+	// primary language
+	d.value("Customizations.locale.languages", oldLocale.Languages, newLocale.Languages)
+	d.value("Customizations.locale.keyboard", oldLocale.Keyboard, newLocale.Keyboard)
+
+	var oldFirewall, newFirewall FirewallCustomization
+	var oldFirewallServices, newFirewallServices FirewallServicesCustomization
+	if a.Firewall != nil {
+		oldFirewall = *a.Firewall
+		if a.Firewall.Services != nil {
+			oldFirewallServices = *a.Firewall.Services
+		}
+	}
+	if b.Firewall != nil {
+		newFirewall = *b.Firewall
+		if b.Firewall.Services != nil {
+			newFirewallServices = *b.Firewall.Services
+		}
+	}
+	d.strings("Customizations.firewall.port", oldFirewall.Ports, newFirewall.Ports)
+	d.strings("Customizations.firewall.services.enabled", oldFirewallServices.Enabled, newFirewallServices.Enabled)
+	d.strings("Customizations.firewall.services.disabled", oldFirewallServices.Disabled, newFirewallServices.Disabled)
+
+	var oldServices, newServices ServicesCustomization
+	if a.Services != nil {
+		oldServices = *a.Services
+	}
+	if b.Services != nil {
+		newServices = *b.Services
+	}
+	d.strings("Customizations.services.enabled", oldServices.Enabled, newServices.Enabled)
+	d.strings("Customizations.services.disabled", oldServices.Disabled, newServices.Disabled)
+
+	var oldDirectories, newDirectories []keyed
+	for _, dir := range a.Directories {
+		oldDirectories = append(oldDirectories, keyed{dir.Path, dir})
+	}
+	for _, dir := range b.Directories {
+		newDirectories = append(newDirectories, keyed{dir.Path, dir})
+	}
+	d.keyed("Customizations.directory", oldDirectories, newDirectories)
+
+	var oldFiles, newFiles []keyed
+	for _, f := range a.Files {
+		oldFiles = append(oldFiles, keyed{f.Path, f})
+	}
+	for _, f := range b.Files {
+		newFiles = append(newFiles, keyed{f.Path, f})
+	}
+	d.keyed("Customizations.file", oldFiles, newFiles)
+}
+
+// normalize returns nil for nil pointers, zero values and empty slices, and
+// dereferences all other pointers.
+func normalize(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return normalize(v.Elem().Interface())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil
+		}
+	}
+	if reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface()) {
+		return nil
+	}
+	return value
+}
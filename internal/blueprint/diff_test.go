@@ -0,0 +1,78 @@
+package blueprint_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func TestDiff(t *testing.T) {
+	old := blueprint.Blueprint{
+		Name:        "web",
+		Description: "Web server",
+		Version:     "0.0.1",
+		Packages:    []blueprint.Package{{Name: "httpd", Version: "2.4.*"}, {Name: "tmux", Version: "*"}},
+		Modules:     []blueprint.Package{},
+		Groups:      []blueprint.Group{{Name: "core"}},
+		Customizations: &blueprint.Customizations{
+			Hostname: stringPtr("web"),
+			Timezone: &blueprint.TimezoneCustomization{NTPServers: []string{"0.pool.ntp.org"}},
+			Services: &blueprint.ServicesCustomization{Enabled: []string{"sshd"}},
+		},
+	}
+	new := blueprint.Blueprint{
+		Name:        "web",
+		Description: "Web server",
+		Version:     "0.0.2",
+		Packages:    []blueprint.Package{{Name: "httpd", Version: "2.4.41"}, {Name: "vim", Version: "*"}},
+		Modules:     []blueprint.Package{{Name: "nodejs", Version: "*"}},
+		Customizations: &blueprint.Customizations{
+			Hostname: stringPtr("web"),
+			Kernel:   &blueprint.KernelCustomization{Append: "quiet"},
+			Timezone: &blueprint.TimezoneCustomization{Timezone: stringPtr("UTC"), NTPServers: []string{"0.pool.ntp.org"}},
+			Services: &blueprint.ServicesCustomization{Enabled: []string{"sshd", "httpd"}},
+			Files:    []blueprint.FileCustomization{{Path: "/etc/motd", Content: "hello"}},
+		},
+	}
+
+	expected := []blueprint.Difference{
+		{Section: "Version", Old: "0.0.1", New: "0.0.2"},
+		{Section: "Module", New: blueprint.Package{Name: "nodejs", Version: "*"}},
+		{Section: "Package", Old: blueprint.Package{Name: "httpd", Version: "2.4.*"}, New: blueprint.Package{Name: "httpd", Version: "2.4.41"}},
+		{Section: "Package", New: blueprint.Package{Name: "vim", Version: "*"}},
+		{Section: "Package", Old: blueprint.Package{Name: "tmux", Version: "*"}},
+		{Section: "Group", Old: blueprint.Group{Name: "core"}},
+		{Section: "Customizations.kernel.append", New: "quiet"},
+		{Section: "Customizations.timezone.timezone", New: "UTC"},
+		{Section: "Customizations.services.enabled", New: "httpd"},
+		{Section: "Customizations.file", New: blueprint.FileCustomization{Path: "/etc/motd", Content: "hello"}},
+	}
+	if diff := cmp.Diff(expected, blueprint.Diff(&old, &new)); diff != "" {
+		t.Errorf("unexpected differences (-expected +got):\n%s", diff)
+	}
+
+	if differences := blueprint.Diff(&old, &old); len(differences) != 0 {
+		t.Errorf("blueprint differs from itself: %+v", differences)
+	}
+
+	// missing customizations are the same as empty ones
+	empty := blueprint.Blueprint{Name: "web", Customizations: &blueprint.Customizations{}}
+	if differences := blueprint.Diff(&blueprint.Blueprint{Name: "web"}, &empty); len(differences) != 0 {
+		t.Errorf("empty customizations differ from missing ones: %+v", differences)
+	}
+}
+
+func TestDifferenceJSON(t *testing.T) {
+	d := blueprint.Difference{Section: "Package", Old: blueprint.Package{Name: "tmux", Version: "*"}}
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("cannot marshal difference: %v", err)
+	}
+	expected := `{"new":null,"old":{"Package":{"name":"tmux","version":"*"}}}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
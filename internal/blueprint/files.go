@@ -0,0 +1,93 @@
+package blueprint
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Paths under which directories and files cannot be created, because they
+// belong to the package manager, the boot loader or the kernel.
+var deniedPaths = []string{
+	"/boot",
+	"/dev",
+	"/proc",
+	"/run",
+	"/sys",
+	"/usr/lib/rpm",
+	"/usr/lib/sysimage/rpm",
+	"/var/lib/dnf",
+	"/var/lib/rpm",
+}
+
+// ValidateFiles returns a *CustomizationError if any of the directory or
+// file customizations of b is invalid.
+func (b *Blueprint) ValidateFiles() error {
+	seen := make(map[string]bool)
+
+	for _, d := range b.GetDirectories() {
+		err := validateCustomPath(d.Path, d.Mode, seen)
+		if err != nil {
+			return &CustomizationError{fmt.Sprintf("directory %s: %v", d.Path, err)}
+		}
+	}
+
+	for _, f := range b.GetFiles() {
+		err := validateCustomPath(f.Path, f.Mode, seen)
+		if err == nil {
+			_, err = f.Bytes()
+		}
+		if err != nil {
+			return &CustomizationError{fmt.Sprintf("file %s: %v", f.Path, err)}
+		}
+	}
+
+	return nil
+}
+
+// Bytes returns the content of f, decoding it if it is set as base64 data.
+func (f *FileCustomization) Bytes() ([]byte, error) {
+	if f.Content != "" && f.Data != "" {
+		return nil, fmt.Errorf("content and data cannot both be set")
+	}
+	if f.Data != "" {
+		data, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return nil, fmt.Errorf("data is not valid base64: %v", err)
+		}
+		return data, nil
+	}
+	return []byte(f.Content), nil
+}
+
+func validateCustomPath(path, mode string, seen map[string]bool) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path must be absolute")
+	}
+	if filepath.Clean(path) != path {
+		return fmt.Errorf("path must be clean, such as %s", filepath.Clean(path))
+	}
+	if path == "/" {
+		return fmt.Errorf("path cannot be the root directory")
+	}
+	for _, denied := range deniedPaths {
+		if path == denied || strings.HasPrefix(path, denied+"/") {
+			return fmt.Errorf("path cannot be in %s", denied)
+		}
+	}
+	if seen[path] {
+		return fmt.Errorf("path is customized more than once")
+	}
+	seen[path] = true
+
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 07777 {
+			return fmt.Errorf("mode must be octal permissions, such as 0644")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package blueprint_test
+
+import (
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func TestValidateFiles(t *testing.T) {
+	var cases = []struct {
+		Name        string
+		Directories []blueprint.DirectoryCustomization
+		Files       []blueprint.FileCustomization
+		Valid       bool
+	}{
+		{"none", nil, nil, true},
+		{"valid", []blueprint.DirectoryCustomization{{Path: "/etc/myapp", Mode: "0750", Owner: "root", Group: "wheel"}},
+			[]blueprint.FileCustomization{{Path: "/etc/myapp/config", Mode: "0640", Content: "debug = false\n"}, {Path: "/etc/motd", Data: "aGVsbG8K"}}, true},
+		{"relative", nil, []blueprint.FileCustomization{{Path: "etc/motd"}}, false},
+		{"unclean", nil, []blueprint.FileCustomization{{Path: "/etc/../usr/lib/rpm/macros"}}, false},
+		{"root", []blueprint.DirectoryCustomization{{Path: "/"}}, nil, false},
+		{"rpm database", nil, []blueprint.FileCustomization{{Path: "/var/lib/rpm/Packages"}}, false},
+		{"rpm configuration", []blueprint.DirectoryCustomization{{Path: "/usr/lib/rpm"}}, nil, false},
+		{"boot", nil, []blueprint.FileCustomization{{Path: "/boot/grub2/grub.cfg"}}, false},
+		{"similar prefix", nil, []blueprint.FileCustomization{{Path: "/bootstrap"}}, true},
+		{"duplicate", []blueprint.DirectoryCustomization{{Path: "/etc/myapp"}}, []blueprint.FileCustomization{{Path: "/etc/myapp"}}, false},
+		{"decimal mode", nil, []blueprint.FileCustomization{{Path: "/etc/motd", Mode: "0998"}}, false},
+		{"large mode", nil, []blueprint.FileCustomization{{Path: "/etc/motd", Mode: "017777"}}, false},
+		{"content and data", nil, []blueprint.FileCustomization{{Path: "/etc/motd", Content: "hello", Data: "aGVsbG8K"}}, false},
+		{"invalid data", nil, []blueprint.FileCustomization{{Path: "/etc/motd", Data: "not base64"}}, false},
+	}
+
+	for _, c := range cases {
+		bp := blueprint.Blueprint{
+			Name: "files",
+			Customizations: &blueprint.Customizations{
+				Directories: c.Directories,
+				Files:       c.Files,
+			},
+		}
+		err := bp.ValidateFiles()
+		if c.Valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.Name, err)
+		} else if !c.Valid {
+			if _, ok := err.(*blueprint.CustomizationError); !ok {
+				t.Errorf("%s: expected a customization error, got %v", c.Name, err)
+			}
+		}
+	}
+}
+
+func TestFileBytes(t *testing.T) {
+	content := blueprint.FileCustomization{Path: "/etc/motd", Content: "hello\n"}
+	data := blueprint.FileCustomization{Path: "/etc/motd", Data: "aGVsbG8K"}
+
+	for _, f := range []blueprint.FileCustomization{content, data} {
+		b, err := f.Bytes()
+		if err != nil || string(b) != "hello\n" {
+			t.Errorf("unexpected content %q of %+v: %v", b, f, err)
+		}
+	}
+}
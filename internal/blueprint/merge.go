@@ -0,0 +1,372 @@
+package blueprint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolve returns a copy of b with the blueprint it includes merged into it,
+// recursively. lookup returns the blueprint called name, or nil if there is
+// none. Blueprints which do not include another one are returned unchanged.
+func (b *Blueprint) Resolve(lookup func(name string) *Blueprint) (*Blueprint, error) {
+	resolved := *b
+	seen := map[string]bool{b.Name: true}
+
+	for resolved.Include != "" {
+		name := resolved.Include
+		if seen[name] {
+			return nil, fmt.Errorf("blueprint %s includes itself through %s", b.Name, name)
+		}
+		seen[name] = true
+
+		base := lookup(name)
+		if base == nil {
+			return nil, fmt.Errorf("blueprint %s includes unknown blueprint %s", b.Name, name)
+		}
+
+		resolved = Merge(base, &resolved)
+		resolved.Include = base.Include
+	}
+
+	return &resolved, nil
+}
+
+// Merge returns b with everything from base that b does not override:
+//
+// Name and version are always taken from b, the description if b does not
+// have one. Packages, modules and groups are combined, and where both
+// specify a package, b's version wins. Users, groups and SSH keys in the
+// customizations are combined by name, and directories and files by path,
+// with b's entries replacing those of base. Enabled and disabled services
+// and firewall services are combined, and a service which b enables or
+// disables is removed from the opposite list of base. Firewall ports are
+// combined. Kernel arguments of b are appended to those of base. All other
+// customizations, such as the hostname, the timezone or the languages, are
+// taken from b if it sets them, and from base otherwise. Variables are
+// combined by name, with b's declarations replacing those of base.
+//
+// The result does not include another blueprint.
+func Merge(base, b *Blueprint) Blueprint {
+	merged := Blueprint{
+		Name:           b.Name,
+		Description:    b.Description,
+		Version:        b.Version,
+		Packages:       mergePackages(base.Packages, b.Packages),
+		Modules:        mergePackages(base.Modules, b.Modules),
+		Groups:         mergeGroups(base.Groups, b.Groups),
+		Customizations: mergeCustomizations(base.Customizations, b.Customizations),
+		Variables:      mergeVariables(base.Variables, b.Variables),
+	}
+	if merged.Description == "" {
+		merged.Description = base.Description
+	}
+	return merged
+}
+
+func mergePackages(base, overrides []Package) []Package {
+	if base == nil {
+		return overrides
+	}
+
+	merged := make([]Package, 0, len(base)+len(overrides))
+	index := make(map[string]int)
+	for _, list := range [][]Package{base, overrides} {
+		for _, pkg := range list {
+			if i, exists := index[pkg.Name]; exists {
+				merged[i] = pkg
+				continue
+			}
+			index[pkg.Name] = len(merged)
+			merged = append(merged, pkg)
+		}
+	}
+	return merged
+}
+
+func mergeGroups(base, overrides []Group) []Group {
+	if base == nil {
+		return overrides
+	}
+
+	merged := append([]Group{}, base...)
+	for _, group := range overrides {
+		if !containsGroup(merged, group.Name) {
+			merged = append(merged, group)
+		}
+	}
+	return merged
+}
+
+func containsGroup(groups []Group, name string) bool {
+	for _, group := range groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeVariables(base, overrides []Variable) []Variable {
+	var merged []Variable
+	for _, v := range base {
+		if !containsVariable(overrides, v.Name) {
+			merged = append(merged, v)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsVariable(variables []Variable, name string) bool {
+	for _, v := range variables {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeCustomizations(base, c *Customizations) *Customizations {
+	if base == nil {
+		return c
+	}
+	if c == nil {
+		return base
+	}
+
+	merged := *c
+	if merged.Hostname == nil {
+		merged.Hostname = base.Hostname
+	}
+	merged.Kernel = mergeKernel(base.Kernel, c.Kernel)
+	merged.SSHKey = mergeSSHKeys(base.SSHKey, c.SSHKey)
+	merged.User = mergeUsers(base.User, c.User)
+	merged.Group = mergeUserGroups(base.Group, c.Group)
+	merged.Timezone = mergeTimezone(base.Timezone, c.Timezone)
+	merged.Locale = mergeLocale(base.Locale, c.Locale)
+	merged.Firewall = mergeFirewall(base.Firewall, c.Firewall)
+	merged.Services = mergeServices(base.Services, c.Services)
+	merged.Directories = mergeDirectories(base.Directories, c.Directories)
+	merged.Files = mergeFiles(base.Files, c.Files)
+	return &merged
+}
+
+func mergeKernel(base, k *KernelCustomization) *KernelCustomization {
+	if base == nil {
+		return k
+	}
+	if k == nil {
+		return base
+	}
+	return &KernelCustomization{
+		Append: strings.TrimSpace(base.Append + " " + k.Append),
+	}
+}
+
+func mergeSSHKeys(base, overrides []SSHKeyCustomization) []SSHKeyCustomization {
+	var merged []SSHKeyCustomization
+	for _, key := range base {
+		if !containsSSHKey(overrides, key.User) {
+			merged = append(merged, key)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsSSHKey(keys []SSHKeyCustomization, user string) bool {
+	for _, key := range keys {
+		if key.User == user {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeUsers(base, overrides []UserCustomization) []UserCustomization {
+	var merged []UserCustomization
+	for _, user := range base {
+		if !containsUser(overrides, user.Name) {
+			merged = append(merged, user)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsUser(users []UserCustomization, name string) bool {
+	for _, user := range users {
+		if user.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeUserGroups(base, overrides []GroupCustomization) []GroupCustomization {
+	var merged []GroupCustomization
+	for _, group := range base {
+		if !containsUserGroup(overrides, group.Name) {
+			merged = append(merged, group)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsUserGroup(groups []GroupCustomization, name string) bool {
+	for _, group := range groups {
+		if group.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeDirectories(base, overrides []DirectoryCustomization) []DirectoryCustomization {
+	var merged []DirectoryCustomization
+	for _, d := range base {
+		if !containsDirectory(overrides, d.Path) {
+			merged = append(merged, d)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsDirectory(directories []DirectoryCustomization, path string) bool {
+	for _, d := range directories {
+		if d.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeFiles(base, overrides []FileCustomization) []FileCustomization {
+	var merged []FileCustomization
+	for _, f := range base {
+		if !containsFile(overrides, f.Path) {
+			merged = append(merged, f)
+		}
+	}
+	return append(merged, overrides...)
+}
+
+func containsFile(files []FileCustomization, path string) bool {
+	for _, f := range files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeTimezone(base, tz *TimezoneCustomization) *TimezoneCustomization {
+	if base == nil {
+		return tz
+	}
+	if tz == nil {
+		return base
+	}
+
+	merged := *tz
+	if merged.Timezone == nil {
+		merged.Timezone = base.Timezone
+	}
+	if len(merged.NTPServers) == 0 {
+		merged.NTPServers = base.NTPServers
+	}
+	return &merged
+}
+
+func mergeLocale(base, l *LocaleCustomization) *LocaleCustomization {
+	if base == nil {
+		return l
+	}
+	if l == nil {
+		return base
+	}
+
+	// the first language is the primary one, so the lists cannot be
+	// combined
+	merged := *l
+	if len(merged.Languages) == 0 {
+		merged.Languages = base.Languages
+	}
+	if merged.Keyboard == nil {
+		merged.Keyboard = base.Keyboard
+	}
+	return &merged
+}
+
+func mergeFirewall(base, fw *FirewallCustomization) *FirewallCustomization {
+	if base == nil {
+		return fw
+	}
+	if fw == nil {
+		return base
+	}
+
+	merged := &FirewallCustomization{
+		Ports: mergeStrings(base.Ports, fw.Ports),
+	}
+
+	var baseServices, services ServicesCustomization
+	if base.Services != nil {
+		baseServices = ServicesCustomization(*base.Services)
+	}
+	if fw.Services != nil {
+		services = ServicesCustomization(*fw.Services)
+	}
+	if base.Services != nil || fw.Services != nil {
+		s := FirewallServicesCustomization(*mergeServices(&baseServices, &services))
+		merged.Services = &s
+	}
+
+	return merged
+}
+
+func mergeServices(base, s *ServicesCustomization) *ServicesCustomization {
+	if base == nil {
+		return s
+	}
+	if s == nil {
+		return base
+	}
+
+	return &ServicesCustomization{
+		Enabled:  mergeStrings(removeStrings(base.Enabled, s.Disabled), s.Enabled),
+		Disabled: mergeStrings(removeStrings(base.Disabled, s.Enabled), s.Disabled),
+	}
+}
+
+// mergeStrings returns the strings in base followed by those in overrides
+// which are not in base.
+func mergeStrings(base, overrides []string) []string {
+	merged := append([]string{}, base...)
+	for _, s := range overrides {
+		if !containsString(merged, s) {
+			merged = append(merged, s)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+// removeStrings returns the strings in list which are not in remove.
+func removeStrings(list, remove []string) []string {
+	var result []string
+	for _, s := range list {
+		if !containsString(remove, s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package blueprint_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestMerge(t *testing.T) {
+	base := blueprint.Blueprint{
+		Name:        "base",
+		Description: "Base server",
+		Version:     "1.0.0",
+		Packages:    []blueprint.Package{{Name: "tmux", Version: "*"}, {Name: "vim", Version: "8.1"}},
+		Groups:      []blueprint.Group{{Name: "core"}},
+		Customizations: &blueprint.Customizations{
+			Hostname: stringPtr("base"),
+			Kernel:   &blueprint.KernelCustomization{Append: "nosmt=force"},
+			User: []blueprint.UserCustomization{
+				{Name: "admin", Shell: stringPtr("/bin/bash")},
+				{Name: "ops"},
+			},
+			Timezone: &blueprint.TimezoneCustomization{Timezone: stringPtr("UTC"), NTPServers: []string{"ntp.example.com"}},
+			Firewall: &blueprint.FirewallCustomization{
+				Ports:    []string{"22:tcp"},
+				Services: &blueprint.FirewallServicesCustomization{Enabled: []string{"ssh"}},
+			},
+			Services: &blueprint.ServicesCustomization{Enabled: []string{"sshd", "cockpit"}, Disabled: []string{"httpd"}},
+		},
+	}
+
+	bp := blueprint.Blueprint{
+		Name:     "web",
+		Version:  "0.1.0",
+		Include:  "base",
+		Packages: []blueprint.Package{{Name: "httpd", Version: "*"}, {Name: "vim", Version: "*"}},
+		Groups:   []blueprint.Group{{Name: "core"}, {Name: "web-server"}},
+		Customizations: &blueprint.Customizations{
+			Hostname: stringPtr("web"),
+			Kernel:   &blueprint.KernelCustomization{Append: "quiet"},
+			User:     []blueprint.UserCustomization{{Name: "admin", Shell: stringPtr("/bin/zsh")}},
+			Timezone: &blueprint.TimezoneCustomization{Timezone: stringPtr("Europe/Berlin")},
+			Firewall: &blueprint.FirewallCustomization{Ports: []string{"80:tcp", "22:tcp"}},
+			Services: &blueprint.ServicesCustomization{Enabled: []string{"httpd"}, Disabled: []string{"cockpit"}},
+		},
+	}
+
+	expected := blueprint.Blueprint{
+		Name:        "web",
+		Description: "Base server",
+		Version:     "0.1.0",
+		Packages:    []blueprint.Package{{Name: "tmux", Version: "*"}, {Name: "vim", Version: "*"}, {Name: "httpd", Version: "*"}},
+		Groups:      []blueprint.Group{{Name: "core"}, {Name: "web-server"}},
+		Customizations: &blueprint.Customizations{
+			Hostname: stringPtr("web"),
+			Kernel:   &blueprint.KernelCustomization{Append: "nosmt=force quiet"},
+			User: []blueprint.UserCustomization{
+				{Name: "ops"},
+				{Name: "admin", Shell: stringPtr("/bin/zsh")},
+			},
+			Timezone: &blueprint.TimezoneCustomization{Timezone: stringPtr("Europe/Berlin"), NTPServers: []string{"ntp.example.com"}},
+			Firewall: &blueprint.FirewallCustomization{
+				Ports:    []string{"22:tcp", "80:tcp"},
+				Services: &blueprint.FirewallServicesCustomization{Enabled: []string{"ssh"}},
+			},
+			Services: &blueprint.ServicesCustomization{Enabled: []string{"sshd", "httpd"}, Disabled: []string{"cockpit"}},
+		},
+	}
+
+	resolved, err := bp.Resolve(func(name string) *blueprint.Blueprint {
+		if name == "base" {
+			return &base
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("cannot resolve blueprint: %v", err)
+	}
+	if diff := cmp.Diff(&expected, resolved); diff != "" {
+		t.Errorf("unexpected resolved blueprint (-expected +got):\n%s", diff)
+	}
+}
+
+func TestResolveChain(t *testing.T) {
+	blueprints := map[string]*blueprint.Blueprint{
+		"a": {Name: "a", Packages: []blueprint.Package{{Name: "a"}}},
+		"b": {Name: "b", Include: "a", Packages: []blueprint.Package{{Name: "b"}}},
+		"c": {Name: "c", Include: "b", Packages: []blueprint.Package{{Name: "c"}}},
+	}
+	lookup := func(name string) *blueprint.Blueprint {
+		return blueprints[name]
+	}
+
+	resolved, err := blueprints["c"].Resolve(lookup)
+	if err != nil {
+		t.Fatalf("cannot resolve blueprint: %v", err)
+	}
+	expected := []blueprint.Package{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	if diff := cmp.Diff(expected, resolved.Packages); diff != "" {
+		t.Errorf("unexpected packages (-expected +got):\n%s", diff)
+	}
+	if resolved.Include != "" {
+		t.Errorf("resolved blueprint still includes %s", resolved.Include)
+	}
+
+	unchanged, err := blueprints["a"].Resolve(lookup)
+	if err != nil || !cmp.Equal(blueprints["a"], unchanged) {
+		t.Errorf("blueprint without include was changed: %+v, %v", unchanged, err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	blueprints := map[string]*blueprint.Blueprint{
+		"self":    {Name: "self", Include: "self"},
+		"loop-a":  {Name: "loop-a", Include: "loop-b"},
+		"loop-b":  {Name: "loop-b", Include: "loop-a"},
+		"missing": {Name: "missing", Include: "nonexistent"},
+	}
+	lookup := func(name string) *blueprint.Blueprint {
+		return blueprints[name]
+	}
+
+	for name, bp := range blueprints {
+		if _, err := bp.Resolve(lookup); err == nil {
+			t.Errorf("resolving %s did not fail", name)
+		}
+	}
+}
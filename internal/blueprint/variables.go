@@ -0,0 +1,265 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// A Variable is a value which is referenced from the customizations of a
+// blueprint as "${name}", and which can be set for each compose.
+type Variable struct {
+	Name        string `json:"name" toml:"name"`
+	Description string `json:"description,omitempty" toml:"description,omitempty"`
+	// One of "string", "integer", "boolean" and "list", which is a list
+	// of strings.
+	Type string `json:"type" toml:"type"`
+	// Value used when a compose does not set the variable. Variables
+	// without a default must be set.
+	Default interface{} `json:"default,omitempty" toml:"default,omitempty"`
+}
+
+var variableReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fileCustomizationType is the type whose content is never expanded, because
+// files often contain shell or systemd syntax, which looks like references.
+var fileCustomizationType = reflect.TypeOf(FileCustomization{})
+
+// ValidateVariables returns an error if any of the variables b declares has
+// an invalid name or type, is declared more than once, or has a default
+// which does not match its type.
+func (b *Blueprint) ValidateVariables() error {
+	seen := make(map[string]bool)
+	for _, v := range b.Variables {
+		if !variableName.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name: %q", v.Name)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variable %s is declared more than once", v.Name)
+		}
+		seen[v.Name] = true
+
+		switch v.Type {
+		case "string", "integer", "boolean", "list":
+		default:
+			return fmt.Errorf("variable %s: unknown type %s", v.Name, v.Type)
+		}
+		if v.Default != nil {
+			_, err := convertVariable(v.Type, v.Default)
+			if err != nil {
+				return fmt.Errorf("variable %s: invalid default: %v", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyVariables returns a copy of b in which all references to variables in
+// its customizations are replaced by their value in values, or their default
+// value. It also returns the value of every variable, converted to its
+// declared type.
+//
+// References are replaced in all strings and lists of strings except the
+// content of files, unless b does not declare any variables. A list variable can only be referenced as a
+// whole element of a list, such as ntpservers = ["${ntp_servers}"], and is
+// expanded into its elements.
+func (b *Blueprint) ApplyVariables(values map[string]interface{}) (*Blueprint, map[string]interface{}, error) {
+	var applied map[string]interface{}
+	if len(b.Variables) > 0 {
+		applied = make(map[string]interface{}, len(b.Variables))
+	}
+	for _, v := range b.Variables {
+		value, ok := values[v.Name]
+		if !ok {
+			value = v.Default
+		}
+		if value == nil {
+			return nil, nil, fmt.Errorf("variable %s is not set", v.Name)
+		}
+
+		converted, err := convertVariable(v.Type, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("variable %s: %v", v.Name, err)
+		}
+		applied[v.Name] = converted
+	}
+	for name := range values {
+		if _, ok := applied[name]; !ok {
+			return nil, nil, fmt.Errorf("blueprint %s has no variable %s", b.Name, name)
+		}
+	}
+
+	// blueprints without variables are left alone, in case they contain
+	// strings which look like references
+	bp := *b
+	if bp.Customizations == nil || len(b.Variables) == 0 {
+		return &bp, applied, nil
+	}
+
+	// copy the customizations, so that replacing references does not
+	// change the pointers shared with b
+	data, err := json.Marshal(bp.Customizations)
+	if err != nil {
+		return nil, nil, err
+	}
+	var customizations Customizations
+	err = json.Unmarshal(data, &customizations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = expandValue(reflect.ValueOf(&customizations).Elem(), applied)
+	if err != nil {
+		return nil, nil, err
+	}
+	bp.Customizations = &customizations
+
+	return &bp, applied, nil
+}
+
+// convertVariable converts value, which was decoded from JSON or TOML, to
+// a string, int64, bool or []string, depending on typ.
+func convertVariable(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case "string":
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case "integer":
+		switch n := value.(type) {
+		case int64:
+			return n, nil
+		case int:
+			return int64(n), nil
+		case float64:
+			if n == math.Trunc(n) {
+				return int64(n), nil
+			}
+		}
+	case "boolean":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case "list":
+		switch list := value.(type) {
+		case []string:
+			return list, nil
+		case []interface{}:
+			strings := make([]string, len(list))
+			for i, element := range list {
+				s, ok := element.(string)
+				if !ok {
+					return nil, fmt.Errorf("expected a list of strings, got %v", value)
+				}
+				strings[i] = s
+			}
+			return strings, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %s", typ)
+	}
+
+	return nil, fmt.Errorf("expected a value of type %s, got %v", typ, value)
+}
+
+// expandValue replaces references to variables in all strings reachable from
+// v, which must be settable.
+func expandValue(v reflect.Value, values map[string]interface{}) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return expandValue(v.Elem(), values)
+		}
+	case reflect.Struct:
+		if v.Type() == fileCustomizationType {
+			return expandFile(v.Addr().Interface().(*FileCustomization), values)
+		}
+		for i := 0; i < v.NumField(); i++ {
+			err := expandValue(v.Field(i), values)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			list, err := expandList(v.Interface().([]string), values)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(list))
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			err := expandValue(v.Index(i), values)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		s, err := expandString(v.String(), values)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	}
+	return nil
+}
+
+func expandFile(f *FileCustomization, values map[string]interface{}) error {
+	var err error
+	for _, s := range []*string{&f.Path, &f.Mode, &f.Owner, &f.Group} {
+		*s, err = expandString(*s, values)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandList(list []string, values map[string]interface{}) ([]string, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	expanded := make([]string, 0, len(list))
+	for _, element := range list {
+		if match := variableReference.FindStringSubmatch(element); match != nil && match[0] == element {
+			if l, ok := values[match[1]].([]string); ok {
+				expanded = append(expanded, l...)
+				continue
+			}
+		}
+
+		s, err := expandString(element, values)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, s)
+	}
+	return expanded, nil
+}
+
+func expandString(s string, values map[string]interface{}) (string, error) {
+	var err error
+	expanded := variableReference.ReplaceAllStringFunc(s, func(reference string) string {
+		name := variableReference.FindStringSubmatch(reference)[1]
+		switch value := values[name].(type) {
+		case string:
+			return value
+		case int64:
+			return strconv.FormatInt(value, 10)
+		case bool:
+			return strconv.FormatBool(value)
+		case []string:
+			err = fmt.Errorf("list variable %s can only be used as an element of a list", name)
+		default:
+			err = fmt.Errorf("unknown variable %s", name)
+		}
+		return reference
+	})
+	return expanded, err
+}
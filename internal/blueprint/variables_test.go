@@ -0,0 +1,142 @@
+package blueprint_test
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+)
+
+const variablesBlueprint = `
+name = "server"
+
+[[variables]]
+name = "hostname"
+type = "string"
+
+[[variables]]
+name = "ntp_servers"
+type = "list"
+default = ["0.pool.ntp.org", "1.pool.ntp.org"]
+
+[[variables]]
+name = "uid"
+type = "integer"
+default = 1000
+
+[customizations]
+hostname = "${hostname}.example.com"
+
+[customizations.timezone]
+ntpservers = ["${ntp_servers}", "ntp.example.com"]
+
+[[customizations.user]]
+name = "admin"
+home = "/home/admin-${uid}"
+
+[[customizations.files]]
+path = "/etc/motd.d/${hostname}"
+content = "Welcome, ${USER}!"
+`
+
+func TestApplyVariables(t *testing.T) {
+	var bp blueprint.Blueprint
+	_, err := toml.Decode(variablesBlueprint, &bp)
+	if err != nil {
+		t.Fatalf("cannot decode blueprint: %v", err)
+	}
+
+	// values as they are decoded from a JSON request
+	applied, values, err := bp.ApplyVariables(map[string]interface{}{"hostname": "web01", "uid": float64(1001)})
+	if err != nil {
+		t.Fatalf("cannot apply variables: %v", err)
+	}
+
+	expectedValues := map[string]interface{}{
+		"hostname":    "web01",
+		"ntp_servers": []string{"0.pool.ntp.org", "1.pool.ntp.org"},
+		"uid":         int64(1001),
+	}
+	if diff := cmp.Diff(expectedValues, values); diff != "" {
+		t.Errorf("unexpected values (-expected +got):\n%s", diff)
+	}
+
+	c := applied.Customizations
+	if *c.Hostname != "web01.example.com" {
+		t.Errorf("unexpected hostname %s", *c.Hostname)
+	}
+	if diff := cmp.Diff([]string{"0.pool.ntp.org", "1.pool.ntp.org", "ntp.example.com"}, c.Timezone.NTPServers); diff != "" {
+		t.Errorf("unexpected NTP servers (-expected +got):\n%s", diff)
+	}
+	if *c.User[0].Home != "/home/admin-1001" {
+		t.Errorf("unexpected home %s", *c.User[0].Home)
+	}
+	if c.Files[0].Path != "/etc/motd.d/web01" || c.Files[0].Content != "Welcome, ${USER}!" {
+		t.Errorf("unexpected file %v", c.Files[0])
+	}
+
+	if *bp.Customizations.Hostname != "${hostname}.example.com" {
+		t.Errorf("applying variables changed the original blueprint")
+	}
+}
+
+func TestValidateVariables(t *testing.T) {
+	var cases = []struct {
+		Variable blueprint.Variable
+		Valid    bool
+	}{
+		{blueprint.Variable{Name: "hostname", Type: "string"}, true},
+		{blueprint.Variable{Name: "uid", Type: "integer", Default: int64(1000)}, true},
+		{blueprint.Variable{Name: "my-name", Type: "string"}, false},
+		{blueprint.Variable{Name: "", Type: "string"}, false},
+		{blueprint.Variable{Name: "count", Type: "float"}, false},
+		{blueprint.Variable{Name: "uid", Type: "integer", Default: "1000"}, false},
+	}
+
+	for _, c := range cases {
+		bp := blueprint.Blueprint{Name: "test", Variables: []blueprint.Variable{c.Variable}}
+		err := bp.ValidateVariables()
+		if (err == nil) != c.Valid {
+			t.Errorf("%v: unexpected result %v", c.Variable, err)
+		}
+	}
+
+	bp := blueprint.Blueprint{Name: "test", Variables: []blueprint.Variable{{Name: "a", Type: "string"}, {Name: "a", Type: "list"}}}
+	if err := bp.ValidateVariables(); err == nil {
+		t.Errorf("duplicate variable was accepted")
+	}
+}
+
+func TestApplyVariablesErrors(t *testing.T) {
+	var bp blueprint.Blueprint
+	_, err := toml.Decode(variablesBlueprint, &bp)
+	if err != nil {
+		t.Fatalf("cannot decode blueprint: %v", err)
+	}
+
+	var cases = []map[string]interface{}{
+		// hostname has no default
+		{},
+		{"hostname": 42},
+		{"hostname": "web01", "uid": 1.5},
+		{"hostname": "web01", "ntp_servers": []interface{}{"a", 1}},
+		{"hostname": "web01", "unknown": "value"},
+	}
+	for _, values := range cases {
+		if _, _, err := bp.ApplyVariables(values); err == nil {
+			t.Errorf("applying %v did not fail", values)
+		}
+	}
+
+	bp.Customizations.Kernel = &blueprint.KernelCustomization{Append: "${undeclared}"}
+	if _, _, err := bp.ApplyVariables(map[string]interface{}{"hostname": "web01"}); err == nil {
+		t.Errorf("reference to an undeclared variable did not fail")
+	}
+
+	bp.Customizations.Kernel = &blueprint.KernelCustomization{Append: "ntp=${ntp_servers}"}
+	if _, _, err := bp.ApplyVariables(map[string]interface{}{"hostname": "web01"}); err == nil {
+		t.Errorf("list variable in a string did not fail")
+	}
+}
@@ -0,0 +1,546 @@
+// Package config contains the configuration of osbuild-composer, which is
+// read from a TOML file on startup.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+	"github.com/osbuild/osbuild-composer/internal/retention"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+	"github.com/osbuild/osbuild-composer/internal/webhook"
+)
+
+// DefaultPath is where osbuild-composer looks for its configuration when
+// no other file is given.
+const DefaultPath = "/etc/osbuild-composer/osbuild-composer.toml"
+
+// A Config contains all settings of osbuild-composer. Only the retention
+// policy, the auth and the webhook settings can be changed at runtime by
+// reloading the file; changes to the other settings are applied on the
+// next start.
+type Config struct {
+	// The distros composer builds images for. Empty means the distro of the
+	// host. Only a single distro is supported for now.
+	Distros []string `toml:"distros"`
+
+	Paths        PathsConfig             `toml:"paths"`
+	Listeners    ListenersConfig         `toml:"listeners"`
+	Log          LogConfig               `toml:"log"`
+	Retention    RetentionConfig         `toml:"retention"`
+	Queue        QueueConfig             `toml:"queue"`
+	Health       HealthConfig            `toml:"health"`
+	Auth         AuthConfig              `toml:"auth"`
+	Worker       WorkerConfig            `toml:"worker"`
+	Webhooks     WebhooksConfig          `toml:"webhooks"`
+	Signing      SigningConfig           `toml:"signing"`
+	DNF          DNFConfig               `toml:"dnf"`
+	Repositories map[string][]RepoConfig `toml:"repositories"`
+}
+
+type PathsConfig struct {
+	State   string `toml:"state"`
+	Outputs string `toml:"outputs"`
+	// Log of all changes made through the APIs. Empty disables it.
+	AuditLog string `toml:"audit_log"`
+	// Cache of workers running on the same machine. Only used to check
+	// its free space. Empty disables the check.
+	Cache string `toml:"cache"`
+}
+
+// ListenersConfig contains the addresses of the APIs, which are used when
+// composer is not started through socket activation. An address is either
+// the absolute path of a unix socket, or a TCP address such as
+// "localhost:8700".
+type ListenersConfig struct {
+	Weldr    string `toml:"weldr"`
+	JobQueue string `toml:"job_queue"`
+	// Address serving only the metrics and health endpoints, without
+	// authentication, so that they can be scraped by Prometheus and probed
+	// by load balancers. Empty disables it. The metrics are always
+	// available to viewers at /metrics on the weldr API, and the health
+	// endpoints to everyone at /health/live and /health/ready.
+	Metrics string `toml:"metrics"`
+	// Time to wait for requests in flight to finish when composer is
+	// stopped.
+	ShutdownTimeout Duration `toml:"shutdown_timeout"`
+}
+
+type LogConfig struct {
+	// One of "debug", "info", "warning" and "error".
+	Level string `toml:"level"`
+	// One of "auto", "text", "json" and "journal". Auto writes to the
+	// journal when running under systemd, and text otherwise.
+	Format string `toml:"format"`
+	// Log every API request. Same as setting level to "debug".
+	AccessLog bool `toml:"access_log"`
+}
+
+type RetentionConfig struct {
+	MaxAge               Duration `toml:"max_age"`
+	MaxCountPerBlueprint int      `toml:"max_count_per_blueprint"`
+	MaxDiskUsage         int64    `toml:"max_disk_usage"`
+	Interval             Duration `toml:"interval"`
+}
+
+// HealthConfig contains the thresholds of the readiness checks.
+type HealthConfig struct {
+	// Composer is not ready if no worker is waiting for a job and none has
+	// contacted it for this long.
+	WorkerTimeout Duration `toml:"worker_timeout"`
+	// Composer is not ready if less space than this, in bytes, is free for
+	// outputs or in the cache.
+	MinFreeSpace int64 `toml:"min_free_space"`
+}
+
+type QueueConfig struct {
+	MaxPendingJobs int `toml:"max_pending_jobs"`
+}
+
+// AuthConfig assigns roles to the callers of the weldr API, see package
+// auth.
+type AuthConfig struct {
+	DefaultRole string            `toml:"default_role"`
+	Users       map[string]string `toml:"users"`
+	Groups      map[string]string `toml:"groups"`
+	Tokens      []TokenConfig     `toml:"tokens"`
+}
+
+type TokenConfig struct {
+	User      string `toml:"user"`
+	Role      string `toml:"role"`
+	TokenFile string `toml:"token_file"`
+}
+
+type WorkerConfig struct {
+	// File containing the token workers must present to the job queue.
+	// Empty disables authentication of workers.
+	TokenFile string `toml:"token_file"`
+}
+
+type WebhooksConfig struct {
+	URLs       []string `toml:"urls"`
+	SecretFile string   `toml:"secret_file"`
+}
+
+// SigningConfig contains the key which signs the manifests of finished
+// composes.
+type SigningConfig struct {
+	// One of "gpg" and "ssh". Empty disables manifests.
+	Type string `toml:"type"`
+	// ID of a gpg secret key, or the path of an ssh private key.
+	Key string `toml:"key"`
+	// Directory of the gpg keyring. Empty means the default keyring.
+	GPGHome string `toml:"gpg_home"`
+}
+
+// DNFConfig contains the settings of fetching package lists and depsolving.
+type DNFConfig struct {
+	// How long package lists are cached before checking whether their
+	// repositories changed.
+	RefreshInterval Duration `toml:"refresh_interval"`
+	// Number of dnf processes, which run one call at a time.
+	Processes int `toml:"processes"`
+}
+
+// A RepoConfig replaces the default repositories of a distro.
+type RepoConfig struct {
+	ID         string `toml:"id"`
+	Name       string `toml:"name"`
+	BaseURL    string `toml:"baseurl"`
+	Metalink   string `toml:"metalink"`
+	MirrorList string `toml:"mirrorlist"`
+	Checksum   string `toml:"checksum"`
+	GPGKey     string `toml:"gpgkey"`
+
+	GPGCheck   bool     `toml:"gpgcheck"`
+	GPGKeyURLs []string `toml:"gpgkey_urls"`
+}
+
+// Duration is a time.Duration which is written as a string such as "72h"
+// in the configuration file.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// Default returns the configuration used when there is no configuration
+// file.
+func Default() *Config {
+	return &Config{
+		Paths: PathsConfig{
+			State:    "/var/lib/osbuild-composer/state.json",
+			Outputs:  "/var/lib/osbuild-composer/outputs",
+			AuditLog: "/var/lib/osbuild-composer/audit.log",
+			Cache:    "/var/cache/osbuild-composer",
+		},
+		Listeners: ListenersConfig{
+			Weldr:           "/run/weldr/api.socket",
+			JobQueue:        "/run/osbuild-composer/job.socket",
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "auto",
+		},
+		Auth: AuthConfig{
+			DefaultRole: "admin",
+		},
+		Health: HealthConfig{
+			WorkerTimeout: Duration{2 * time.Hour},
+			MinFreeSpace:  1024 * 1024 * 1024,
+		},
+		Retention: RetentionConfig{
+			Interval: Duration{time.Hour},
+		},
+		Queue: QueueConfig{
+			MaxPendingJobs: 200,
+		},
+		DNF: DNFConfig{
+			RefreshInterval: Duration{time.Hour},
+			Processes:       1,
+		},
+	}
+}
+
+// Load reads the configuration from path. Settings which are missing from
+// the file keep their default values. Unknown settings are an error, as
+// they are most likely typos.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	md, err := toml.Decode(string(data), c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("%s: unknown settings: %s", path, strings.Join(keys, ", "))
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// Validate checks that all settings have sensible values.
+func (c *Config) Validate() error {
+	if len(c.Distros) > 1 {
+		return errors.New("distros: only a single distro is supported")
+	}
+	for _, name := range c.Distros {
+		if distro.New(name) == nil {
+			return fmt.Errorf("distros: unknown distro: %s", name)
+		}
+	}
+
+	if !filepath.IsAbs(c.Paths.State) {
+		return errors.New("paths.state: must be an absolute path")
+	}
+	if !filepath.IsAbs(c.Paths.Outputs) {
+		return errors.New("paths.outputs: must be an absolute path")
+	}
+	if c.Paths.AuditLog != "" && !filepath.IsAbs(c.Paths.AuditLog) {
+		return errors.New("paths.audit_log: must be an absolute path")
+	}
+	if c.Paths.Cache != "" && !filepath.IsAbs(c.Paths.Cache) {
+		return errors.New("paths.cache: must be an absolute path")
+	}
+
+	if _, _, err := ParseAddress(c.Listeners.Weldr); err != nil {
+		return fmt.Errorf("listeners.weldr: %v", err)
+	}
+	if _, _, err := ParseAddress(c.Listeners.JobQueue); err != nil {
+		return fmt.Errorf("listeners.job_queue: %v", err)
+	}
+	if c.Listeners.Metrics != "" {
+		if _, _, err := ParseAddress(c.Listeners.Metrics); err != nil {
+			return fmt.Errorf("listeners.metrics: %v", err)
+		}
+	}
+	if c.Listeners.ShutdownTimeout.Duration < 0 {
+		return errors.New("listeners.shutdown_timeout: must not be negative")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %v", err)
+	}
+	switch c.Log.Format {
+	case "auto", "text", "json", "journal":
+	default:
+		return fmt.Errorf("log.format: unknown format: %s", c.Log.Format)
+	}
+
+	if _, err := auth.ParseRole(c.Auth.DefaultRole); err != nil {
+		return fmt.Errorf("auth.default_role: %v", err)
+	}
+	for name, role := range c.Auth.Users {
+		if _, err := auth.ParseRole(role); err != nil {
+			return fmt.Errorf("auth.users.%s: %v", name, err)
+		}
+	}
+	for name, role := range c.Auth.Groups {
+		if _, err := auth.ParseRole(role); err != nil {
+			return fmt.Errorf("auth.groups.%s: %v", name, err)
+		}
+	}
+	for _, token := range c.Auth.Tokens {
+		if token.User == "" {
+			return errors.New("auth.tokens: token without user")
+		}
+		if _, err := auth.ParseRole(token.Role); err != nil {
+			return fmt.Errorf("auth.tokens: %s: %v", token.User, err)
+		}
+		if token.TokenFile == "" {
+			return fmt.Errorf("auth.tokens: %s: token_file is required", token.User)
+		}
+	}
+
+	if c.Retention.MaxAge.Duration < 0 {
+		return errors.New("retention.max_age: must not be negative")
+	}
+	if c.Retention.MaxCountPerBlueprint < 0 {
+		return errors.New("retention.max_count_per_blueprint: must not be negative")
+	}
+	if c.Retention.MaxDiskUsage < 0 {
+		return errors.New("retention.max_disk_usage: must not be negative")
+	}
+	if c.Retention.Interval.Duration <= 0 {
+		return errors.New("retention.interval: must be positive")
+	}
+
+	if c.Queue.MaxPendingJobs <= 0 {
+		return errors.New("queue.max_pending_jobs: must be positive")
+	}
+	if c.Health.WorkerTimeout.Duration <= 0 {
+		return errors.New("health.worker_timeout: must be positive")
+	}
+	if c.Health.MinFreeSpace < 0 {
+		return errors.New("health.min_free_space: must not be negative")
+	}
+
+	for _, u := range c.Webhooks.URLs {
+		if err := webhook.ValidateURL(u); err != nil {
+			return fmt.Errorf("webhooks.urls: invalid URL %s: %v", u, err)
+		}
+	}
+
+	switch c.Signing.Type {
+	case "":
+	case "gpg", "ssh":
+		if c.Signing.Key == "" {
+			return errors.New("signing.key: is required")
+		}
+		if c.Signing.Type == "ssh" && !filepath.IsAbs(c.Signing.Key) {
+			return errors.New("signing.key: must be an absolute path")
+		}
+		if c.Signing.GPGHome != "" && !filepath.IsAbs(c.Signing.GPGHome) {
+			return errors.New("signing.gpg_home: must be an absolute path")
+		}
+	default:
+		return fmt.Errorf("signing.type: unknown type: %s", c.Signing.Type)
+	}
+
+	if c.DNF.RefreshInterval.Duration <= 0 {
+		return errors.New("dnf.refresh_interval: must be positive")
+	}
+	if c.DNF.Processes <= 0 {
+		return errors.New("dnf.processes: must be positive")
+	}
+
+	for name, repos := range c.Repositories {
+		if distro.New(name) == nil {
+			return fmt.Errorf("repositories: unknown distro: %s", name)
+		}
+		if len(repos) == 0 {
+			return fmt.Errorf("repositories.%s: at least one repository is required", name)
+		}
+
+		ids := make(map[string]bool)
+		for _, repo := range repos {
+			if repo.ID == "" {
+				return fmt.Errorf("repositories.%s: repository without id", name)
+			}
+			if ids[repo.ID] {
+				return fmt.Errorf("repositories.%s: duplicate repository: %s", name, repo.ID)
+			}
+			ids[repo.ID] = true
+
+			if repo.BaseURL == "" && repo.Metalink == "" && repo.MirrorList == "" {
+				return fmt.Errorf("repositories.%s: %s: one of baseurl, metalink or mirrorlist is required", name, repo.ID)
+			}
+			// images are built with osbuild, which only has gpgkey
+			if repo.GPGCheck && repo.GPGKey == "" {
+				return fmt.Errorf("repositories.%s: %s: gpgcheck requires gpgkey", name, repo.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RetentionPolicy returns the policy for removing old composes.
+func (c *Config) RetentionPolicy() retention.Policy {
+	return retention.Policy{
+		MaxAge:               c.Retention.MaxAge.Duration,
+		MaxCountPerBlueprint: c.Retention.MaxCountPerBlueprint,
+		MaxDiskUsage:         c.Retention.MaxDiskUsage,
+	}
+}
+
+// Logger returns a logger with the configured level and format. Text and
+// JSON are written to w.
+func (c *Config) Logger(w io.Writer) (*logging.Logger, error) {
+	// the level was checked by Validate
+	level, _ := logging.ParseLevel(c.Log.Level)
+	if c.Log.AccessLog {
+		level = logging.Debug
+	}
+
+	output, err := logging.NewOutput(c.Log.Format, w)
+	if err != nil {
+		return nil, err
+	}
+
+	return logging.New(output, level), nil
+}
+
+// AuthPolicy returns the policy for identifying callers of the weldr API,
+// reading all token files.
+func (c *Config) AuthPolicy() (auth.Policy, error) {
+	// roles were checked by Validate
+	policy := auth.Policy{
+		Users:  make(map[string]auth.Role),
+		Groups: make(map[string]auth.Role),
+	}
+	policy.DefaultRole, _ = auth.ParseRole(c.Auth.DefaultRole)
+	for name, role := range c.Auth.Users {
+		policy.Users[name], _ = auth.ParseRole(role)
+	}
+	for name, role := range c.Auth.Groups {
+		policy.Groups[name], _ = auth.ParseRole(role)
+	}
+
+	for _, t := range c.Auth.Tokens {
+		secret, err := ReadSecret(t.TokenFile)
+		if err != nil {
+			return auth.Policy{}, err
+		}
+		if len(secret) == 0 {
+			return auth.Policy{}, fmt.Errorf("token file of %s is empty", t.User)
+		}
+
+		role, _ := auth.ParseRole(t.Role)
+		policy.Tokens = append(policy.Tokens, auth.Token{
+			Secret: secret,
+			User:   t.User,
+			Role:   role,
+		})
+	}
+
+	return policy, nil
+}
+
+// ManifestSigner returns the signer of compose manifests, or nil if manifests
+// are disabled.
+func (c *Config) ManifestSigner() (manifest.Signer, error) {
+	if c.Signing.Type == "" {
+		return nil, nil
+	}
+	return manifest.NewSigner(c.Signing.Type, c.Signing.Key, c.Signing.GPGHome)
+}
+
+// RPMMDOptions returns the settings of fetching package lists and
+// depsolving.
+func (c *Config) RPMMDOptions() rpmmd.Options {
+	return rpmmd.Options{
+		RefreshInterval: c.DNF.RefreshInterval.Duration,
+		Processes:       c.DNF.Processes,
+	}
+}
+
+// DistroRepositories returns the repositories configured for the distro
+// called name, or nil if its default repositories should be used.
+func (c *Config) DistroRepositories(name string) []rpmmd.RepoConfig {
+	repos, exists := c.Repositories[name]
+	if !exists {
+		return nil
+	}
+
+	result := make([]rpmmd.RepoConfig, len(repos))
+	for i, repo := range repos {
+		result[i] = rpmmd.RepoConfig{
+			Id:         repo.ID,
+			Name:       repo.Name,
+			BaseURL:    repo.BaseURL,
+			Metalink:   repo.Metalink,
+			MirrorList: repo.MirrorList,
+			Checksum:   repo.Checksum,
+			GPGKey:     repo.GPGKey,
+			CheckGPG:   repo.GPGCheck,
+			GPGKeyURLs: repo.GPGKeyURLs,
+		}
+	}
+	return result
+}
+
+// ParseAddress returns the network and address to pass to net.Listen or
+// net.Dial for an address from the configuration.
+func ParseAddress(address string) (string, string, error) {
+	if filepath.IsAbs(address) {
+		return "unix", address, nil
+	}
+
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid address %#v: must be an absolute path or host:port", address)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid port in address %#v", address)
+	}
+
+	return "tcp", address, nil
+}
+
+// ReadSecret returns the trimmed contents of path, or nil if path is empty.
+func ReadSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	secret, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.TrimSpace(string(secret))), nil
+}
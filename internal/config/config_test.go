@@ -0,0 +1,219 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/auth"
+	"github.com/osbuild/osbuild-composer/internal/config"
+	"github.com/osbuild/osbuild-composer/internal/retention"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+func load(t *testing.T, content string) (*config.Config, error) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "osbuild-composer.toml")
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("cannot write configuration: %v", err)
+	}
+
+	return config.Load(path)
+}
+
+func TestDistributedConfig(t *testing.T) {
+	c, err := config.Load("../../distribution/osbuild-composer.toml")
+	if err != nil {
+		t.Fatalf("cannot load distributed configuration: %v", err)
+	}
+
+	if !reflect.DeepEqual(c, config.Default()) {
+		t.Errorf("distributed configuration differs from the defaults: %+v", c)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	c, err := load(t, `
+distros = ["fedora-30"]
+
+[paths]
+state = "/srv/composer/state.json"
+
+[retention]
+max_age = "72h"
+max_count_per_blueprint = 3
+
+[queue]
+max_pending_jobs = 10
+
+[webhooks]
+urls = ["https://example.com/hook"]
+
+[[repositories.fedora-30]]
+id = "fedora"
+baseurl = "http://mirror.example.com/fedora/30/os"
+gpgkey = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+gpgcheck = true
+`)
+	if err != nil {
+		t.Fatalf("cannot load configuration: %v", err)
+	}
+
+	if c.Paths.State != "/srv/composer/state.json" {
+		t.Errorf("unexpected state path: %s", c.Paths.State)
+	}
+	if c.Paths.Outputs != config.Default().Paths.Outputs {
+		t.Errorf("missing setting did not keep its default: %s", c.Paths.Outputs)
+	}
+	if c.Queue.MaxPendingJobs != 10 {
+		t.Errorf("unexpected queue limit: %d", c.Queue.MaxPendingJobs)
+	}
+
+	expectedPolicy := retention.Policy{MaxAge: 72 * time.Hour, MaxCountPerBlueprint: 3}
+	if policy := c.RetentionPolicy(); policy != expectedPolicy {
+		t.Errorf("unexpected retention policy: %+v", policy)
+	}
+
+	expectedRepos := []rpmmd.RepoConfig{{
+		Id:       "fedora",
+		BaseURL:  "http://mirror.example.com/fedora/30/os",
+		GPGKey:   "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+		CheckGPG: true,
+	}}
+	if repos := c.DistroRepositories("fedora-30"); !reflect.DeepEqual(repos, expectedRepos) {
+		t.Errorf("unexpected repositories: %+v", repos)
+	}
+	if repos := c.DistroRepositories("rhel-8.2"); repos != nil {
+		t.Errorf("unexpected repositories for unconfigured distro: %+v", repos)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	var cases = []struct {
+		Content       string
+		ExpectedError string
+	}{
+		{`distros = ["fedora-1"]`, "unknown distro"},
+		{`distros = ["fedora-30", "rhel-8.2"]`, "only a single distro"},
+		{"[paths]\nstate = \"state.json\"", "paths.state"},
+		{"[paths]\nstates = \"/state.json\"", "unknown settings: paths.states"},
+		{"[listeners]\nweldr = \"api.socket\"", "listeners.weldr"},
+		{"[listeners]\njob_queue = \"localhost:http\"", "listeners.job_queue"},
+		{"[log]\nlevel = \"verbose\"", "log.level"},
+		{"[log]\nformat = \"xml\"", "log.format"},
+		{"[auth]\ndefault_role = \"root\"", "auth.default_role"},
+		{"[auth.groups]\nweldr = \"superuser\"", "auth.groups.weldr"},
+		{"[[auth.tokens]]\nuser = \"ci\"\nrole = \"composer\"", "token_file is required"},
+		{"[retention]\nmax_age = \"forever\"", "invalid duration"},
+		{"[retention]\nmax_count_per_blueprint = -1", "retention.max_count_per_blueprint"},
+		{"[retention]\ninterval = \"0s\"", "retention.interval"},
+		{"[listeners]\nshutdown_timeout = \"-1s\"", "listeners.shutdown_timeout"},
+		{"[queue]\nmax_pending_jobs = 0", "queue.max_pending_jobs"},
+		{"[webhooks]\nurls = [\"ftp://example.com\"]", "webhooks.urls"},
+		{"[dnf]\nrefresh_interval = \"0s\"", "dnf.refresh_interval"},
+		{"[dnf]\nprocesses = 0", "dnf.processes"},
+		{"[signing]\ntype = \"x509\"\nkey = \"/key\"", "signing.type"},
+		{"[signing]\ntype = \"gpg\"", "signing.key"},
+		{"[signing]\ntype = \"ssh\"\nkey = \"id_ed25519\"", "signing.key"},
+		{"[[repositories.fedora-30]]\nbaseurl = \"http://example.com\"", "repository without id"},
+		{"[[repositories.fedora-30]]\nid = \"fedora\"", "one of baseurl, metalink or mirrorlist"},
+		{"[[repositories.fedora-1]]\nid = \"fedora\"\nbaseurl = \"http://example.com\"", "unknown distro"},
+		{"[[repositories.fedora-30]]\nid = \"fedora\"\nbaseurl = \"http://example.com\"\nsslclientcert = \"/cert.pem\"", "unknown settings: repositories.fedora-30.sslclientcert"},
+		{"[[repositories.fedora-30]]\nid = \"fedora\"\nbaseurl = \"http://example.com\"\ngpgcheck = true", "gpgcheck requires gpgkey"},
+		{"[[repositories.fedora-30]]\nid = \"fedora\"\nbaseurl = \"http://example.com\"\ngpgcheck = true\ngpgkey_urls = [\"http://example.com/key\"]", "gpgcheck requires gpgkey"},
+		{"[[repositories.fedora-30]]\nid = \"fedora\"\nbaseurl = \"http://example.com\"\nproxy = \"http://proxy.example.com\"", "unknown settings"},
+	}
+
+	for _, c := range cases {
+		_, err := load(t, c.Content)
+		if err == nil || !strings.Contains(err.Error(), c.ExpectedError) {
+			t.Errorf("%#v: expected error containing %#v, got %v", c.Content, c.ExpectedError, err)
+		}
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	var cases = []struct {
+		Address         string
+		ExpectedNetwork string
+	}{
+		{"/run/weldr/api.socket", "unix"},
+		{"localhost:8700", "tcp"},
+		{":8700", "tcp"},
+		{"[::1]:8700", "tcp"},
+		{"run/weldr/api.socket", ""},
+		{"localhost", ""},
+		{"localhost:99999", ""},
+	}
+
+	for _, c := range cases {
+		network, address, err := config.ParseAddress(c.Address)
+		if c.ExpectedNetwork == "" {
+			if err == nil {
+				t.Errorf("%#v: expected an error", c.Address)
+			}
+			continue
+		}
+		if err != nil || network != c.ExpectedNetwork || address != c.Address {
+			t.Errorf("%#v: got %#v, %#v, %v", c.Address, network, address, err)
+		}
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "ci-token")
+	err = ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600)
+	if err != nil {
+		t.Fatalf("cannot write token: %v", err)
+	}
+
+	c, err := load(t, `
+[auth]
+default_role = "viewer"
+
+[auth.users]
+alice = "admin"
+
+[auth.groups]
+weldr = "composer"
+
+[[auth.tokens]]
+user = "ci"
+role = "composer"
+token_file = "`+tokenFile+`"
+`)
+	if err != nil {
+		t.Fatalf("cannot load configuration: %v", err)
+	}
+
+	policy, err := c.AuthPolicy()
+	if err != nil {
+		t.Fatalf("cannot read auth policy: %v", err)
+	}
+
+	expected := auth.Policy{
+		DefaultRole: auth.Viewer,
+		Users:       map[string]auth.Role{"alice": auth.Admin},
+		Groups:      map[string]auth.Role{"weldr": auth.Composer},
+		Tokens:      []auth.Token{{Secret: []byte("secret"), User: "ci", Role: auth.Composer}},
+	}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+}
@@ -16,6 +16,9 @@ import (
 )
 
 type Distro interface {
+	// Returns the name of the distro, as used in distro.New().
+	Name() string
+
 	// Returns a list of repositories from which this distribution gets its
 	// content.
 	Repositories() []rpmmd.RepoConfig
@@ -29,8 +32,9 @@ type Distro interface {
 
 	// Returns an osbuild pipeline that generates an image in the given
 	// output format with all packages and customizations specified in the
-	// given blueprint.
-	Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipeline.Pipeline, error)
+	// given blueprint. Packages are installed from repos, which usually
+	// are the distro's repositories and any additional sources.
+	Pipeline(b *blueprint.Blueprint, repos []rpmmd.RepoConfig, outputFormat string) (*pipeline.Pipeline, error)
 
 	// Returns a osbuild runner that can be used on this distro.
 	Runner() string
@@ -76,6 +80,19 @@ func FromHost() (Distro, error) {
 	return d, nil
 }
 
+// WithRepositories returns a copy of d which gets its content from repos
+// instead of its default repositories.
+func WithRepositories(d Distro, repos []rpmmd.RepoConfig) (Distro, error) {
+	switch d := d.(type) {
+	case *fedora30.Fedora30:
+		return d.WithRepositories(repos), nil
+	case *rhel82.RHEL82:
+		return d.WithRepositories(repos), nil
+	}
+
+	return nil, errors.New("distro does not support custom repositories")
+}
+
 func Register(name string, distro Distro) {
 	if _, exists := registered[name]; exists {
 		panic("a distro with this name already exists: " + name)
@@ -9,6 +9,7 @@ import (
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/pipeline"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 )
 
 func TestDistro_Pipeline(t *testing.T) {
@@ -45,7 +46,7 @@ func TestDistro_Pipeline(t *testing.T) {
 				t.Errorf("unknown distro: %v", tt.Compose.Distro)
 				return
 			}
-			got, err := d.Pipeline(tt.Compose.Blueprint, tt.Compose.OutputFormat)
+			got, err := d.Pipeline(tt.Compose.Blueprint, d.Repositories(), tt.Compose.OutputFormat)
 			if (err != nil) != (tt.Pipeline == nil) {
 				t.Errorf("distro.Pipeline() error = %v", err)
 				return
@@ -61,3 +62,80 @@ func TestDistro_Pipeline(t *testing.T) {
 		})
 	}
 }
+
+func TestWithRepositories(t *testing.T) {
+	repos := []rpmmd.RepoConfig{
+		{
+			Id:         "mirror",
+			Name:       "Mirror",
+			BaseURL:    "http://mirror.example.com/os",
+			GPGKey:     "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+			CheckGPG:   true,
+			GPGKeyURLs: []string{"http://mirror.example.com/RPM-GPG-KEY"},
+		},
+	}
+	// osbuild only verifies packages with the gpgkey
+	expectedRepo := &pipeline.DNFRepository{
+		BaseURL: "http://mirror.example.com/os",
+		GPGKey:  "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+	}
+
+	for _, name := range []string{"fedora-30", "rhel-8.2"} {
+		t.Run(name, func(t *testing.T) {
+			original := distro.New(name)
+			defaults := original.Repositories()
+
+			d, err := distro.WithRepositories(original, repos)
+			if err != nil {
+				t.Fatalf("distro.WithRepositories() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(d.Repositories(), repos) {
+				t.Errorf("d.Repositories() = %v, want %v", d.Repositories(), repos)
+			}
+			if !reflect.DeepEqual(original.Repositories(), defaults) {
+				t.Errorf("repositories of the original distro changed")
+			}
+
+			p, err := d.Pipeline(&blueprint.Blueprint{}, d.Repositories(), d.ListOutputFormats()[0])
+			if err != nil {
+				t.Fatalf("d.Pipeline() error = %v", err)
+			}
+			options := p.Stages[0].Options.(*pipeline.DNFStageOptions)
+			if len(options.Repositories) != 1 || !reflect.DeepEqual(options.Repositories[0], expectedRepo) {
+				t.Errorf("pipeline does not use the custom repositories: %v", options.Repositories)
+			}
+		})
+	}
+}
+
+func TestPipelineRepositories(t *testing.T) {
+	source := rpmmd.RepoConfig{
+		Id:      "custom",
+		Name:    "custom",
+		BaseURL: "http://example.com/custom/os",
+	}
+
+	for _, name := range []string{"fedora-30", "rhel-8.2"} {
+		t.Run(name, func(t *testing.T) {
+			d := distro.New(name)
+			repos := append(d.Repositories(), source)
+
+			p, err := d.Pipeline(&blueprint.Blueprint{}, repos, d.ListOutputFormats()[0])
+			if err != nil {
+				t.Fatalf("d.Pipeline() error = %v", err)
+			}
+
+			options := p.Stages[0].Options.(*pipeline.DNFStageOptions)
+			if len(options.Repositories) != len(repos) || options.Repositories[len(repos)-1].BaseURL != source.BaseURL {
+				t.Errorf("image is not built from the given repositories: %v", options.Repositories)
+			}
+
+			// the build environment only uses the distro's repositories
+			build := p.Build.Pipeline.Stages[0].Options.(*pipeline.DNFStageOptions)
+			if len(build.Repositories) != len(d.Repositories()) {
+				t.Errorf("build environment uses other repositories: %v", build.Repositories)
+			}
+		})
+	}
+}
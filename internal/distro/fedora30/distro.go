@@ -14,7 +14,8 @@ import (
 )
 
 type Fedora30 struct {
-	outputs map[string]output
+	outputs      map[string]output
+	repositories []rpmmd.RepoConfig
 }
 
 type output struct {
@@ -215,7 +216,23 @@ func New() *Fedora30 {
 	return &r
 }
 
+// WithRepositories returns a copy of r which gets its content from repos
+// instead of the default repositories.
+func (r *Fedora30) WithRepositories(repos []rpmmd.RepoConfig) *Fedora30 {
+	c := *r
+	c.repositories = repos
+	return &c
+}
+
+func (r *Fedora30) Name() string {
+	return "fedora-30"
+}
+
 func (r *Fedora30) Repositories() []rpmmd.RepoConfig {
+	if r.repositories != nil {
+		return r.repositories
+	}
+
 	return []rpmmd.RepoConfig{
 		{
 			Id:       "fedora",
@@ -271,7 +288,7 @@ func (r *Fedora30) FilenameFromType(outputFormat string) (string, string, error)
 	return "", "", errors.New("invalid output format: " + outputFormat)
 }
 
-func (r *Fedora30) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipeline.Pipeline, error) {
+func (r *Fedora30) Pipeline(b *blueprint.Blueprint, repos []rpmmd.RepoConfig, outputFormat string) (*pipeline.Pipeline, error) {
 	output, exists := r.outputs[outputFormat]
 	if !exists {
 		return nil, errors.New("invalid output format: " + outputFormat)
@@ -281,7 +298,7 @@ func (r *Fedora30) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipel
 	p.SetBuild(r.buildPipeline(), "org.osbuild.fedora30")
 
 	packages := append(output.Packages, b.GetPackages()...)
-	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(packages, output.ExcludedPackages)))
+	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(repos, packages, output.ExcludedPackages)))
 	p.AddStage(pipeline.NewFixBLSStage())
 
 	// TODO support setting all languages and install corresponding langpack-* package
@@ -337,6 +354,14 @@ func (r *Fedora30) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipel
 		p.AddStage(pipeline.NewFirewallStage(r.firewallStageOptions(firewall)))
 	}
 
+	if len(b.GetDirectories()) > 0 || len(b.GetFiles()) > 0 {
+		options, err := r.filesStageOptions(b)
+		if err != nil {
+			return nil, err
+		}
+		p.AddStage(pipeline.NewScriptStage(options))
+	}
+
 	p.AddStage(pipeline.NewSELinuxStage(r.selinuxStageOptions()))
 	p.Assembler = output.Assembler
 
@@ -358,16 +383,16 @@ func (r *Fedora30) buildPipeline() *pipeline.Pipeline {
 		"tar",
 	}
 	p := &pipeline.Pipeline{}
-	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(packages, nil)))
+	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(r.Repositories(), packages, nil)))
 	return p
 }
 
-func (r *Fedora30) dnfStageOptions(packages, excludedPackages []string) *pipeline.DNFStageOptions {
+func (r *Fedora30) dnfStageOptions(repos []rpmmd.RepoConfig, packages, excludedPackages []string) *pipeline.DNFStageOptions {
 	options := &pipeline.DNFStageOptions{
 		ReleaseVersion:   "30",
 		BaseArchitecture: "x86_64",
 	}
-	for _, repo := range r.Repositories() {
+	for _, repo := range repos {
 		options.AddRepository(&pipeline.DNFRepository{
 			BaseURL:    repo.BaseURL,
 			MetaLink:   repo.Metalink,
@@ -461,6 +486,40 @@ func (r *Fedora30) firewallStageOptions(firewall *blueprint.FirewallCustomizatio
 	return &options
 }
 
+func (r *Fedora30) filesStageOptions(b *blueprint.Blueprint) (*pipeline.ScriptStageOptions, error) {
+	err := b.ValidateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var directories []pipeline.ScriptDirectory
+	for _, d := range b.GetDirectories() {
+		directories = append(directories, pipeline.ScriptDirectory{
+			Path:  d.Path,
+			Mode:  d.Mode,
+			Owner: d.Owner,
+			Group: d.Group,
+		})
+	}
+
+	var files []pipeline.ScriptFile
+	for _, f := range b.GetFiles() {
+		data, err := f.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pipeline.ScriptFile{
+			Path:  f.Path,
+			Mode:  f.Mode,
+			Owner: f.Owner,
+			Group: f.Group,
+			Data:  data,
+		})
+	}
+
+	return pipeline.NewFilesScriptStageOptions(directories, files), nil
+}
+
 func (r *Fedora30) systemdStageOptions(enabledServices, disabledServices []string, s *blueprint.ServicesCustomization) *pipeline.SystemdStageOptions {
 	if s != nil {
 		enabledServices = append(enabledServices, s.Enabled...)
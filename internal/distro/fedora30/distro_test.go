@@ -4,7 +4,9 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/pipeline"
 )
 
 func TestListOutputFormats(t *testing.T) {
@@ -109,3 +111,46 @@ func TestFilenameFromType(t *testing.T) {
 		})
 	}
 }
+
+func TestPipelineFiles(t *testing.T) {
+	bp := blueprint.Blueprint{
+		Name: "files",
+		Customizations: &blueprint.Customizations{
+			Directories: []blueprint.DirectoryCustomization{{Path: "/etc/myapp", Mode: "0750"}},
+			Files:       []blueprint.FileCustomization{{Path: "/etc/myapp/config", Owner: "root", Content: "hello\n"}},
+		},
+	}
+
+	f30 := distro.New("fedora-30")
+	p, err := f30.Pipeline(&bp, f30.Repositories(), "qcow2")
+	if err != nil {
+		t.Fatalf("cannot create pipeline: %v", err)
+	}
+
+	var options *pipeline.ScriptStageOptions
+	for _, stage := range p.Stages {
+		if stage.Name == "org.osbuild.script" {
+			options = stage.Options.(*pipeline.ScriptStageOptions)
+		}
+	}
+	expected := &pipeline.ScriptStageOptions{
+		Script: `#!/bin/sh
+set -e
+mkdir -p '/etc/myapp'
+chmod '0750' '/etc/myapp'
+mkdir -p '/etc/myapp'
+base64 -d > '/etc/myapp/config' <<'EOF'
+aGVsbG8K
+EOF
+chown 'root' '/etc/myapp/config'
+`,
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("unexpected script stage options %+v", options)
+	}
+
+	bp.Customizations.Files[0].Path = "/var/lib/rpm/Packages"
+	if _, err := f30.Pipeline(&bp, f30.Repositories(), "qcow2"); err == nil {
+		t.Errorf("file in the rpm database was accepted")
+	}
+}
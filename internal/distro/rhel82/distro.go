@@ -14,7 +14,8 @@ import (
 )
 
 type RHEL82 struct {
-	outputs map[string]output
+	outputs      map[string]output
+	repositories []rpmmd.RepoConfig
 }
 
 type output struct {
@@ -263,7 +264,23 @@ func New() *RHEL82 {
 	return &r
 }
 
+// WithRepositories returns a copy of r which gets its content from repos
+// instead of the default repositories.
+func (r *RHEL82) WithRepositories(repos []rpmmd.RepoConfig) *RHEL82 {
+	c := *r
+	c.repositories = repos
+	return &c
+}
+
+func (r *RHEL82) Name() string {
+	return "rhel-8.2"
+}
+
 func (r *RHEL82) Repositories() []rpmmd.RepoConfig {
+	if r.repositories != nil {
+		return r.repositories
+	}
+
 	return []rpmmd.RepoConfig{
 		{
 			Id:       "baseos",
@@ -296,7 +313,7 @@ func (r *RHEL82) FilenameFromType(outputFormat string) (string, string, error) {
 	return "", "", errors.New("invalid output format: " + outputFormat)
 }
 
-func (r *RHEL82) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipeline.Pipeline, error) {
+func (r *RHEL82) Pipeline(b *blueprint.Blueprint, repos []rpmmd.RepoConfig, outputFormat string) (*pipeline.Pipeline, error) {
 	output, exists := r.outputs[outputFormat]
 	if !exists {
 		return nil, errors.New("invalid output format: " + outputFormat)
@@ -306,7 +323,7 @@ func (r *RHEL82) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipelin
 	p.SetBuild(r.buildPipeline(), "org.osbuild.rhel82")
 
 	packages := append(output.Packages, b.GetPackages()...)
-	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(packages, output.ExcludedPackages)))
+	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(repos, packages, output.ExcludedPackages)))
 	p.AddStage(pipeline.NewFixBLSStage())
 
 	if output.IncludeFSTab {
@@ -367,6 +384,14 @@ func (r *RHEL82) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipelin
 		p.AddStage(pipeline.NewFirewallStage(r.firewallStageOptions(firewall)))
 	}
 
+	if len(b.GetDirectories()) > 0 || len(b.GetFiles()) > 0 {
+		options, err := r.filesStageOptions(b)
+		if err != nil {
+			return nil, err
+		}
+		p.AddStage(pipeline.NewScriptStage(options))
+	}
+
 	p.AddStage(pipeline.NewSELinuxStage(r.selinuxStageOptions()))
 	p.Assembler = output.Assembler
 
@@ -392,22 +417,23 @@ func (r *RHEL82) buildPipeline() *pipeline.Pipeline {
 		"xfsprogs",
 	}
 	p := &pipeline.Pipeline{}
-	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(packages, nil)))
+	p.AddStage(pipeline.NewDNFStage(r.dnfStageOptions(r.Repositories(), packages, nil)))
 	return p
 }
 
-func (r *RHEL82) dnfStageOptions(packages, excludedPackages []string) *pipeline.DNFStageOptions {
+func (r *RHEL82) dnfStageOptions(repos []rpmmd.RepoConfig, packages, excludedPackages []string) *pipeline.DNFStageOptions {
 	options := &pipeline.DNFStageOptions{
 		ReleaseVersion:   "8",
 		BaseArchitecture: "x86_64",
 		ModulePlatformId: "platform:el8",
 	}
-	for _, repo := range r.Repositories() {
+	for _, repo := range repos {
 		options.AddRepository(&pipeline.DNFRepository{
 			BaseURL:    repo.BaseURL,
 			MetaLink:   repo.Metalink,
 			MirrorList: repo.MirrorList,
 			Checksum:   repo.Checksum,
+			GPGKey:     repo.GPGKey,
 		})
 	}
 
@@ -495,6 +521,40 @@ func (r *RHEL82) firewallStageOptions(firewall *blueprint.FirewallCustomization)
 	return &options
 }
 
+func (r *RHEL82) filesStageOptions(b *blueprint.Blueprint) (*pipeline.ScriptStageOptions, error) {
+	err := b.ValidateFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var directories []pipeline.ScriptDirectory
+	for _, d := range b.GetDirectories() {
+		directories = append(directories, pipeline.ScriptDirectory{
+			Path:  d.Path,
+			Mode:  d.Mode,
+			Owner: d.Owner,
+			Group: d.Group,
+		})
+	}
+
+	var files []pipeline.ScriptFile
+	for _, f := range b.GetFiles() {
+		data, err := f.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pipeline.ScriptFile{
+			Path:  f.Path,
+			Mode:  f.Mode,
+			Owner: f.Owner,
+			Group: f.Group,
+			Data:  data,
+		})
+	}
+
+	return pipeline.NewFilesScriptStageOptions(directories, files), nil
+}
+
 func (r *RHEL82) systemdStageOptions(s *blueprint.ServicesCustomization, target string) *pipeline.SystemdStageOptions {
 	return &pipeline.SystemdStageOptions{
 		EnabledServices:  s.Enabled,
@@ -15,12 +15,18 @@ func init() {
 	distro.Register("test", &TestDistro{})
 }
 
+func (d *TestDistro) Name() string {
+	return "test"
+}
+
 func (d *TestDistro) Repositories() []rpmmd.RepoConfig {
 	return []rpmmd.RepoConfig{
 		{
-			Id:      "test",
-			Name:    "Test",
-			BaseURL: "http://example.com/test/os",
+			Id:       "test",
+			Name:     "Test",
+			BaseURL:  "http://example.com/test/os",
+			GPGKey:   "-----BEGIN PGP PUBLIC KEY BLOCK-----",
+			CheckGPG: true,
 		},
 	}
 }
@@ -33,7 +39,7 @@ func (d *TestDistro) FilenameFromType(outputFormat string) (string, string, erro
 	return "", "", errors.New("invalid output format: " + outputFormat)
 }
 
-func (d *TestDistro) Pipeline(b *blueprint.Blueprint, outputFormat string) (*pipeline.Pipeline, error) {
+func (d *TestDistro) Pipeline(b *blueprint.Blueprint, repos []rpmmd.RepoConfig, outputFormat string) (*pipeline.Pipeline, error) {
 	return nil, errors.New("invalid output format: " + outputFormat)
 }
 
@@ -0,0 +1,196 @@
+// Package health reports whether composer is alive and whether it is ready
+// to serve requests, for use by load balancers and monitoring.
+//
+// Liveness only tells that composer can answer requests at all. Readiness
+// additionally runs a set of checks on composer's dependencies, such as
+// free disk space or whether workers are connected.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Check returns an error if a dependency of composer is unhealthy.
+type Check func() error
+
+// A Result is the outcome of one check.
+type Result struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+
+	// the run of check which has not returned yet, if any
+	mu      sync.Mutex
+	pending *pendingCheck
+}
+
+type pendingCheck struct {
+	done chan struct{}
+	err  error
+}
+
+// A Checker runs a set of named checks.
+type Checker struct {
+	// Time after which a check which has not returned is reported as failed.
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks []*namedCheck
+}
+
+func NewChecker() *Checker {
+	return &Checker{Timeout: 10 * time.Second}
+}
+
+// Add adds a check which is run by Run under name.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &namedCheck{name: name, check: check})
+}
+
+// Run runs all checks concurrently and returns their results in the order
+// they were added, and whether all of them passed.
+func (c *Checker) Run() ([]Result, bool) {
+	c.mu.Lock()
+	checks := c.checks
+	c.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc *namedCheck) {
+			defer wg.Done()
+			results[i] = c.run(nc)
+		}(i, nc)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		ok = ok && r.OK
+	}
+	return results, ok
+}
+
+// run waits for nc to return, for at most c.Timeout. A check which has not
+// returned yet is not started again: later runs wait for the pending one
+// instead, so that a hanging check does not pile up goroutines.
+func (c *Checker) run(nc *namedCheck) Result {
+	nc.mu.Lock()
+	p := nc.pending
+	if p == nil {
+		p = &pendingCheck{done: make(chan struct{})}
+		nc.pending = p
+		go func() {
+			p.err = nc.check()
+
+			nc.mu.Lock()
+			nc.pending = nil
+			nc.mu.Unlock()
+			close(p.done)
+		}()
+	}
+	nc.mu.Unlock()
+
+	var err error
+	select {
+	case <-p.done:
+		err = p.err
+	case <-time.After(c.Timeout):
+		err = fmt.Errorf("timed out after %v", c.Timeout)
+	}
+
+	if err != nil {
+		return Result{nc.name, false, err.Error()}
+	}
+	return Result{nc.name, true, ""}
+}
+
+// LiveHandler responds with 200 to every request.
+func LiveHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// ServeHTTP runs all checks and responds with their results, with status
+// 200 if all of them passed and 503 otherwise. A nil Checker has no checks.
+func (c *Checker) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	results := []Result{}
+	ok := true
+	if c != nil {
+		results, ok = c.Run()
+	}
+
+	status := "ok"
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if !ok {
+		status = "unavailable"
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(writer).Encode(struct {
+		Status string   `json:"status"`
+		Checks []Result `json:"checks"`
+	}{status, results})
+}
+
+// Cached returns a check which runs check at most once per ttl and reports
+// its last result otherwise. Use it for checks which are too expensive to
+// run on every request.
+func Cached(check Check, ttl time.Duration) Check {
+	var mu sync.Mutex
+	var lastRun time.Time
+	var lastErr error
+
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if lastRun.IsZero() || time.Since(lastRun) >= ttl {
+			lastErr = check()
+			lastRun = time.Now()
+		}
+		return lastErr
+	}
+}
+
+// DiskSpace returns a check which fails if less than minFree bytes are
+// available to unprivileged users on the file system containing path. If
+// path does not exist yet, the file system it would be created on is
+// checked.
+func DiskSpace(path string, minFree uint64) Check {
+	return func() error {
+		var stat syscall.Statfs_t
+		dir := path
+		err := syscall.Statfs(dir, &stat)
+		for os.IsNotExist(err) && dir != filepath.Dir(dir) {
+			dir = filepath.Dir(dir)
+			err = syscall.Statfs(dir, &stat)
+		}
+		if err != nil {
+			return err
+		}
+
+		free := stat.Bavail * uint64(stat.Bsize)
+		if free < minFree {
+			return fmt.Errorf("only %d bytes are free in %s, need %d", free, path, minFree)
+		}
+		return nil
+	}
+}
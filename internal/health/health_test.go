@@ -0,0 +1,150 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/health"
+)
+
+func TestReady(t *testing.T) {
+	var cases = []struct {
+		Checks         map[string]error
+		ExpectedStatus int
+		ExpectedOK     bool
+	}{
+		{nil, http.StatusOK, true},
+		{map[string]error{"a": nil, "b": nil}, http.StatusOK, true},
+		{map[string]error{"a": nil, "b": errors.New("broken")}, http.StatusServiceUnavailable, false},
+	}
+
+	for i, c := range cases {
+		checker := health.NewChecker()
+		for name, err := range c.Checks {
+			err := err
+			checker.Add(name, func() error { return err })
+		}
+
+		recorder := httptest.NewRecorder()
+		checker.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/ready", nil))
+		if recorder.Code != c.ExpectedStatus {
+			t.Errorf("case %d: expected status %d, got %d", i, c.ExpectedStatus, recorder.Code)
+		}
+
+		var reply struct {
+			Status string          `json:"status"`
+			Checks []health.Result `json:"checks"`
+		}
+		err := json.Unmarshal(recorder.Body.Bytes(), &reply)
+		if err != nil {
+			t.Fatalf("case %d: cannot parse reply %s: %v", i, recorder.Body.String(), err)
+		}
+		if len(reply.Checks) != len(c.Checks) {
+			t.Errorf("case %d: expected %d results, got %v", i, len(c.Checks), reply.Checks)
+		}
+		for _, r := range reply.Checks {
+			if r.OK != (c.Checks[r.Name] == nil) {
+				t.Errorf("case %d: unexpected result %v", i, r)
+			}
+		}
+		if (reply.Status == "ok") != c.ExpectedOK {
+			t.Errorf("case %d: unexpected status %s", i, reply.Status)
+		}
+	}
+}
+
+func TestNilChecker(t *testing.T) {
+	var checker *health.Checker
+	recorder := httptest.NewRecorder()
+	checker.ServeHTTP(recorder, httptest.NewRequest("GET", "/health/ready", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", recorder.Code)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	checker := health.NewChecker()
+	checker.Timeout = 10 * time.Millisecond
+	checker.Add("slow", func() error {
+		<-block
+		return nil
+	})
+
+	results, ok := checker.Run()
+	if ok || len(results) != 1 || results[0].OK {
+		t.Errorf("slow check did not time out: %v", results)
+	}
+}
+
+func TestPendingCheck(t *testing.T) {
+	block := make(chan struct{})
+	var calls int32
+
+	checker := health.NewChecker()
+	checker.Timeout = 10 * time.Millisecond
+	checker.Add("slow", func() error {
+		atomic.AddInt32(&calls, 1)
+		<-block
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, ok := checker.Run(); ok {
+			t.Errorf("slow check did not time out")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("expected pending check to run once, ran %d times", n)
+	}
+
+	close(block)
+	checker.Timeout = time.Second
+	if results, ok := checker.Run(); !ok {
+		t.Errorf("check failed after it returned: %v", results)
+	}
+}
+
+func TestCached(t *testing.T) {
+	calls := 0
+	check := health.Cached(func() error {
+		calls++
+		return nil
+	}, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if err := check(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected check to run once, ran %d times", calls)
+	}
+}
+
+func TestDiskSpace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-health-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "does", "not", "exist")
+	if err := health.DiskSpace(missing, 0)(); err != nil {
+		t.Errorf("check on nonexistent path failed: %v", err)
+	}
+	if err := health.DiskSpace(dir, math.MaxUint64)(); err == nil {
+		t.Errorf("check did not fail with an impossible threshold")
+	}
+}
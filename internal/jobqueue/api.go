@@ -1,30 +1,66 @@
 package jobqueue
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+	"github.com/osbuild/osbuild-composer/internal/metrics"
 	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/webhook"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
 type API struct {
-	logger *log.Logger
-	store  *store.Store
-	router *httprouter.Router
+	logger   *logging.Logger
+	store    *store.Store
+	notifier *webhook.Notifier
+	router   *metrics.Router
+	token    []byte
+	auditLog *audit.Log
+	signer   manifest.Signer
+
+	server  *http.Server
+	stopped context.Context // done when requests waiting for a job must give up
+	stop    context.CancelFunc
+
+	workersMu      sync.Mutex
+	waitingWorkers int       // number of workers waiting for a job
+	lastContact    time.Time // last time a worker finished a request
 }
 
-func New(logger *log.Logger, store *store.Store) *API {
+// New creates a job queue API. It logs to the default logger if logger is
+// nil.
+func New(logger *logging.Logger, store *store.Store, notifier *webhook.Notifier) *API {
+	if logger == nil {
+		logger = logging.Default()
+	}
+
 	api := &API{
-		logger: logger,
-		store:  store,
+		logger:   logger,
+		store:    store,
+		notifier: notifier,
 	}
 
-	api.router = httprouter.New()
+	api.stopped, api.stop = context.WithCancel(context.Background())
+	api.server = &http.Server{
+		Handler: api,
+	}
+
+	api.router = metrics.NewRouter("job-queue")
 	api.router.RedirectTrailingSlash = false
 	api.router.RedirectFixedPath = false
 	api.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
@@ -32,14 +68,31 @@ func New(logger *log.Logger, store *store.Store) *API {
 
 	api.router.POST("/job-queue/v1/jobs", api.addJobHandler)
 	api.router.PATCH("/job-queue/v1/jobs/:id", api.updateJobHandler)
+	api.router.HEAD("/job-queue/v1/jobs/:id/artifacts/:name", api.artifactOffsetHandler)
+	api.router.PUT("/job-queue/v1/jobs/:id/artifacts/:name", api.uploadArtifactHandler)
 
 	return api
 }
 
-func (api *API) Serve(listener net.Listener) error {
-	server := http.Server{Handler: api}
+// RequireToken makes the API reject all requests which do not carry token
+// in their Authorization header, as in "Authorization: Bearer <token>".
+func (api *API) RequireToken(token []byte) {
+	api.token = token
+}
+
+// SetAuditLog makes the API record all changes in auditLog.
+func (api *API) SetAuditLog(auditLog *audit.Log) {
+	api.auditLog = auditLog
+}
+
+// SetManifestSigner makes the API sign the manifests of finished composes
+// with signer.
+func (api *API) SetManifestSigner(signer manifest.Signer) {
+	api.signer = signer
+}
 
-	err := server.Serve(listener)
+func (api *API) Serve(listener net.Listener) error {
+	err := api.server.Serve(listener)
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -47,15 +100,94 @@ func (api *API) Serve(listener net.Listener) error {
 	return nil
 }
 
+// Shutdown stops accepting requests and waits until all requests in flight
+// have finished, or until ctx is done. Workers waiting for a job are sent
+// away immediately, so that they can retry with the next instance.
+func (api *API) Shutdown(ctx context.Context) error {
+	api.stop()
+	return api.server.Shutdown(ctx)
+}
+
 func (api *API) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	if api.logger != nil {
-		log.Println(request.Method, request.URL.Path)
+	logger := api.logger
+	if requestID := request.Header.Get(logging.RequestIDHeader); requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	request = request.WithContext(logging.NewContext(request.Context(), logger))
+	logger.Debugf("%s %s", request.Method, request.URL.Path)
+
+	if len(api.token) > 0 && !api.authorized(request) {
+		statusResponseError(writer, http.StatusUnauthorized)
+		return
 	}
 
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 	api.router.ServeHTTP(writer, request)
 }
 
+func (api *API) authorized(request *http.Request) bool {
+	const prefix = "Bearer "
+
+	auth := request.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), api.token) == 1
+}
+
+// CheckWorkers returns an error if no worker is waiting for a job right now
+// and none has contacted the job queue within timeout.
+func (api *API) CheckWorkers(timeout time.Duration) error {
+	api.workersMu.Lock()
+	defer api.workersMu.Unlock()
+
+	if api.waitingWorkers > 0 {
+		return nil
+	}
+	if api.lastContact.IsZero() {
+		return errors.New("no worker has connected yet")
+	}
+	if since := time.Since(api.lastContact); since > timeout {
+		return fmt.Errorf("no worker has connected for %v", since.Round(time.Second))
+	}
+	return nil
+}
+
+// workerWaiting records contact with a worker and changes the number of
+// workers waiting for a job by delta.
+func (api *API) workerWaiting(delta int) {
+	api.workersMu.Lock()
+	defer api.workersMu.Unlock()
+	api.waitingWorkers += delta
+	api.lastContact = time.Now()
+}
+
+// workerContacted records that a worker finished a request.
+func (api *API) workerContacted() {
+	api.workersMu.Lock()
+	defer api.workersMu.Unlock()
+	api.lastContact = time.Now()
+}
+
+// audit records a change made by a worker in the audit log. Workers only
+// authenticate with a shared token, so they cannot be told apart.
+func (api *API) audit(action, object string, details map[string]string) {
+	if api.auditLog == nil {
+		return
+	}
+
+	err := api.auditLog.Append(audit.Record{
+		User:    "worker",
+		Action:  action,
+		Object:  object,
+		Details: details,
+	})
+	if err != nil {
+		api.logger.Errorf("cannot write audit record: %v", err)
+	}
+}
+
 func methodNotAllowedHandler(writer http.ResponseWriter, request *http.Request) {
 	writer.WriteHeader(http.StatusMethodNotAllowed)
 }
@@ -90,10 +222,31 @@ func (api *API) addJobHandler(writer http.ResponseWriter, request *http.Request,
 		return
 	}
 
-	nextJob := api.store.PopCompose()
+	// give up when either the worker goes away or composer shuts down
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-api.stopped.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	api.workerWaiting(1)
+	nextJob, err := api.store.PopComposeContext(ctx)
+	api.workerWaiting(-1)
+	if err != nil {
+		// the worker went away or composer is shutting down
+		statusResponseError(writer, http.StatusServiceUnavailable)
+		return
+	}
+
+	api.audit("job.start", nextJob.ComposeID.String(), nil)
+	api.logger.With("compose_id", nextJob.ComposeID).With("request_id", nextJob.RequestID).Infof("assigned compose to a worker")
 
 	writer.WriteHeader(http.StatusCreated)
-	json.NewEncoder(writer).Encode(replyBody{nextJob.ComposeID, nextJob.Pipeline, nextJob.Targets, nextJob.OutputType})
+	json.NewEncoder(writer).Encode(replyBody{nextJob.ComposeID, nextJob.Pipeline, nextJob.Targets, nextJob.OutputType, nextJob.RequestID})
 }
 
 func (api *API) updateJobHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -116,6 +269,17 @@ func (api *API) updateJobHandler(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
+	if body.Status == "FINISHED" && body.Image != nil {
+		err = api.resolveImage(id, body.Image)
+		if err != nil {
+			statusResponseError(writer, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	// only known while the compose is running
+	artifactDir, _ := api.store.GetComposeArtifactDir(id)
+
 	err = api.store.UpdateCompose(id, body.Status, body.Image)
 	if err != nil {
 		switch err.(type) {
@@ -130,5 +294,58 @@ func (api *API) updateJobHandler(writer http.ResponseWriter, request *http.Reque
 		}
 		return
 	}
+
+	api.workerContacted()
+	api.audit("job.update", id.String(), map[string]string{"status": body.Status})
+	logger := logging.FromContext(request.Context()).With("compose_id", id)
+	if body.Status == "FAILED" {
+		logger.Warningf("compose failed: %s", body.Failure)
+	} else {
+		logger.Infof("compose is %s", strings.ToLower(body.Status))
+	}
+
+	if body.Status == "FINISHED" && body.Packages != nil {
+		err = api.store.SetManifestPackages(id, body.Packages)
+		if err != nil {
+			logger.Errorf("cannot record the packages of the image: %v", err)
+		}
+	}
+
+	if body.Status == "FINISHED" || body.Status == "FAILED" {
+		if artifactDir != "" {
+			err = removePartialArtifacts(artifactDir)
+			if err != nil {
+				logger.Warningf("cannot remove incomplete artifacts: %v", err)
+			}
+		}
+		if compose, exists := api.store.GetCompose(id); exists {
+			if body.Status == "FINISHED" {
+				api.writeManifest(logger, compose)
+			}
+			observe(compose.OutputType, &body)
+			if api.notifier != nil {
+				api.notifier.ComposeFinished(id, compose)
+			}
+		}
+	}
+
 	statusResponseOK(writer)
 }
+
+// writeManifest completes the manifest of the finished compose with its image,
+// and writes it next to the image together with its signature. Nothing is
+// written if the API has no signer, or the compose has no manifest or image.
+func (api *API) writeManifest(logger *logging.Logger, compose store.Compose) {
+	if api.signer == nil || compose.Manifest == nil || compose.Image == nil {
+		return
+	}
+
+	m := *compose.Manifest
+	m.Image = filepath.Base(compose.Image.Path)
+	m.Checksums = compose.Image.Checksums
+
+	err := manifest.Write(filepath.Dir(compose.Image.Path), &m, api.signer)
+	if err != nil {
+		logger.Errorf("cannot write manifest: %v", err)
+	}
+}
@@ -1,12 +1,19 @@
 package jobqueue_test
 
 import (
+	"context"
+	"encoding/json"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
 	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/metrics"
 	"github.com/osbuild/osbuild-composer/internal/store"
 	"github.com/osbuild/osbuild-composer/internal/test"
 
@@ -32,7 +39,7 @@ func TestBasic(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		api := jobqueue.New(nil, store.New(nil, distro.New("fedora-30")))
+		api := jobqueue.New(nil, store.New(nil, distro.New("fedora-30")), nil)
 
 		test.TestRoute(t, api, false, c.Method, c.Path, c.Body, c.ExpectedStatus, c.ExpectedJSON)
 	}
@@ -41,9 +48,9 @@ func TestBasic(t *testing.T) {
 func TestCreate(t *testing.T) {
 	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
 	store := store.New(nil, distro.New("fedora-30"))
-	api := jobqueue.New(nil, store)
+	api := jobqueue.New(nil, store, nil)
 
-	err := store.PushCompose(id, &blueprint.Blueprint{}, "tar", nil)
+	err := store.PushCompose(id, &blueprint.Blueprint{}, "tar", nil, nil)
 	if err != nil {
 		t.Fatalf("error pushing compose: %v", err)
 	}
@@ -55,10 +62,10 @@ func TestCreate(t *testing.T) {
 func testUpdateTransition(t *testing.T, from, to string, expectedStatus int) {
 	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
 	store := store.New(nil, distro.New("fedora-30"))
-	api := jobqueue.New(nil, store)
+	api := jobqueue.New(nil, store, nil)
 
 	if from != "VOID" {
-		err := store.PushCompose(id, &blueprint.Blueprint{}, "tar", nil)
+		err := store.PushCompose(id, &blueprint.Blueprint{}, "tar", nil, nil)
 		if err != nil {
 			t.Fatalf("error pushing compose: %v", err)
 		}
@@ -105,3 +112,146 @@ func TestUpdate(t *testing.T) {
 		testUpdateTransition(t, c.From, c.To, c.ExpectedStatus)
 	}
 }
+
+func TestToken(t *testing.T) {
+	api := jobqueue.New(nil, store.New(nil, distro.New("fedora-30")), nil)
+	api.RequireToken([]byte("secret"))
+
+	var cases = []struct {
+		Authorization  string
+		ExpectedStatus int
+	}{
+		{"", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		request := httptest.NewRequest("PATCH", "/job-queue/v1/jobs/aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa", strings.NewReader(`{"status":"RUNNING"}`))
+		request.Header.Set("Content-Type", "application/json")
+		if c.Authorization != "" {
+			request.Header.Set("Authorization", c.Authorization)
+		}
+		response := httptest.NewRecorder()
+		api.ServeHTTP(response, request)
+
+		if response.Code != c.ExpectedStatus {
+			t.Errorf("%#v: expected status %d, got %d", c.Authorization, c.ExpectedStatus, response.Code)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	store := store.New(nil, distro.New("fedora-30"))
+	api := jobqueue.New(nil, store, nil)
+
+	err := store.PushCompose(id, &blueprint.Blueprint{}, "qcow2", nil, nil)
+	if err != nil {
+		t.Fatalf("error pushing compose: %v", err)
+	}
+	test.SendHTTP(api, false, "POST", "/job-queue/v1/jobs", `{}`)
+	test.SendHTTP(api, false, "PATCH", "/job-queue/v1/jobs/ffffffff-ffff-ffff-ffff-ffffffffffff",
+		`{"status":"FAILED","failure":"target","targets":[{"name":"org.osbuild.aws","duration":2.5,"error":"access denied"}]}`)
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	for _, expected := range []string{
+		`composer_compose_failures_total{output_type="qcow2",reason="target"} 1`,
+		`composer_target_duration_seconds_sum{target="org.osbuild.aws",result="failure"} 2.5`,
+		`composer_compose_build_seconds_count{distro="fedora-30",output_type="qcow2",status="FAILED"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), expected+"\n") {
+			t.Errorf("expected metrics to contain %s, got:\n%s", expected, recorder.Body.String())
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	s := store.New(nil, distro.New("fedora-30"))
+	api := jobqueue.New(nil, s, nil)
+
+	err := s.PushCompose(id, &blueprint.Blueprint{}, "tar", nil, &store.ComposeOptions{RequestID: "abc"})
+	if err != nil {
+		t.Fatalf("error pushing compose: %v", err)
+	}
+
+	response := test.SendHTTP(api, false, "POST", "/job-queue/v1/jobs", `{}`)
+	defer response.Body.Close()
+
+	var job jobqueue.Job
+	err = json.NewDecoder(response.Body).Decode(&job)
+	if err != nil {
+		t.Fatalf("cannot decode job: %v", err)
+	}
+	if job.RequestID != "abc" {
+		t.Errorf("expected request ID abc, got %#v", job.RequestID)
+	}
+}
+
+func TestCheckWorkers(t *testing.T) {
+	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	s := store.New(nil, distro.New("fedora-30"))
+	api := jobqueue.New(nil, s, nil)
+
+	if err := api.CheckWorkers(time.Hour); err == nil {
+		t.Errorf("check passed before any worker connected")
+	}
+
+	err := s.PushCompose(id, &blueprint.Blueprint{}, "tar", nil, nil)
+	if err != nil {
+		t.Fatalf("error pushing compose: %v", err)
+	}
+	test.SendHTTP(api, false, "POST", "/job-queue/v1/jobs", `{}`)
+
+	if err := api.CheckWorkers(time.Hour); err != nil {
+		t.Errorf("check failed after a worker connected: %v", err)
+	}
+	if err := api.CheckWorkers(0); err == nil {
+		t.Errorf("check passed although the worker timed out")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	api := jobqueue.New(nil, store.New(nil, distro.New("fedora-30")), nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- api.Serve(listener) }()
+
+	// a worker waiting for a job must not keep composer from stopping
+	response := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+listener.Addr().String()+"/job-queue/v1/jobs", "application/json", strings.NewReader(`{}`))
+		if err != nil {
+			response <- 0
+			return
+		}
+		resp.Body.Close()
+		response <- resp.StatusCode
+	}()
+
+	for api.CheckWorkers(time.Hour) != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = api.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("cannot shut down: %v", err)
+	}
+
+	if code := <-response; code != http.StatusServiceUnavailable {
+		t.Errorf("expected waiting worker to get status 503, got %d", code)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve returned an error: %v", err)
+	}
+}
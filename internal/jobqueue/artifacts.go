@@ -0,0 +1,266 @@
+package jobqueue
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// Headers of artifact uploads. Workers send artifacts in one or more PUT
+// requests, each continuing at the offset composer has received so far, which
+// is returned by HEAD requests and in the replies to incomplete uploads.
+const (
+	// Number of bytes of the artifact which this request starts at, or
+	// which composer has received.
+	ArtifactOffsetHeader = "Upload-Offset"
+	// Size of the whole artifact.
+	ArtifactLengthHeader = "Upload-Length"
+	// Checksum of the whole artifact, as in "sha256:<hex digest>".
+	ArtifactChecksumHeader = "Upload-Checksum"
+)
+
+// partialArtifactPath returns the path at which the artifact name is stored
+// while it is being uploaded. It is hidden, so that it cannot collide with
+// other artifacts.
+func partialArtifactPath(dir, name string) string {
+	return filepath.Join(dir, "."+name+".part")
+}
+
+func validArtifactName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+func artifactErrorStatus(err error) int {
+	switch err.(type) {
+	case *store.NotFoundError:
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (api *API) artifactOffsetHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		statusResponseError(writer, http.StatusBadRequest, "invalid compose id: "+err.Error())
+		return
+	}
+
+	name := params.ByName("name")
+	if !validArtifactName(name) {
+		statusResponseError(writer, http.StatusBadRequest, "invalid artifact name")
+		return
+	}
+
+	dir, err := api.store.GetComposeArtifactDir(id)
+	if err != nil {
+		statusResponseError(writer, artifactErrorStatus(err), err.Error())
+		return
+	}
+
+	offset := partialArtifactSize(partialArtifactPath(dir, name))
+	writer.Header().Set(ArtifactOffsetHeader, strconv.FormatInt(offset, 10))
+	statusResponseOK(writer)
+}
+
+func (api *API) uploadArtifactHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id, err := uuid.Parse(params.ByName("id"))
+	if err != nil {
+		statusResponseError(writer, http.StatusBadRequest, "invalid compose id: "+err.Error())
+		return
+	}
+
+	name := params.ByName("name")
+	if !validArtifactName(name) {
+		statusResponseError(writer, http.StatusBadRequest, "invalid artifact name")
+		return
+	}
+
+	offset, err := strconv.ParseInt(request.Header.Get(ArtifactOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		statusResponseError(writer, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	length, err := strconv.ParseInt(request.Header.Get(ArtifactLengthHeader), 10, 64)
+	if err != nil || length < offset {
+		statusResponseError(writer, http.StatusBadRequest, "invalid length")
+		return
+	}
+	checksum := request.Header.Get(ArtifactChecksumHeader)
+	if !strings.HasPrefix(checksum, "sha256:") {
+		statusResponseError(writer, http.StatusBadRequest, "invalid checksum")
+		return
+	}
+
+	dir, err := api.store.GetComposeArtifactDir(id)
+	if err != nil {
+		statusResponseError(writer, artifactErrorStatus(err), err.Error())
+		return
+	}
+
+	logger := logging.FromContext(request.Context()).With("compose_id", id)
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		logger.Errorf("cannot create artifact directory: %v", err)
+		statusResponseError(writer, http.StatusInternalServerError)
+		return
+	}
+
+	partialPath := partialArtifactPath(dir, name)
+	if size := partialArtifactSize(partialPath); offset != 0 && offset != size {
+		// the worker lost track of what was received, or another worker
+		// is uploading the same artifact
+		writer.Header().Set(ArtifactOffsetHeader, strconv.FormatInt(size, 10))
+		statusResponseError(writer, http.StatusConflict, fmt.Sprintf("expected offset %d", size))
+		return
+	}
+
+	body := &bodyReader{Reader: request.Body}
+	size, err := appendArtifact(partialPath, offset, length, body)
+	if err != nil && body.err == nil {
+		// composer cannot store the artifact, which a retry won't fix
+		os.Remove(partialPath)
+		logger.Errorf("cannot store artifact %s: %v", name, err)
+		writer.Header().Set(ArtifactOffsetHeader, "0")
+		statusResponseError(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set(ArtifactOffsetHeader, strconv.FormatInt(size, 10))
+	if err != nil {
+		// keep what was received, so that the worker can resume
+		logger.Warningf("upload of artifact %s was interrupted at %d bytes: %v", name, size, err)
+		statusResponseError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if size < length {
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	checksums, err := ArtifactChecksums(partialPath, nil)
+	if actual := "sha256:" + checksums["sha256"]; err == nil && actual != checksum {
+		err = fmt.Errorf("expected checksum %s, got %s", checksum, actual)
+	}
+	if err != nil {
+		// start over from the beginning
+		os.Remove(partialPath)
+		logger.Warningf("discarding artifact %s: %v", name, err)
+		writer.Header().Set(ArtifactOffsetHeader, "0")
+		statusResponseError(writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = os.Rename(partialPath, filepath.Join(dir, name))
+	if err != nil {
+		os.Remove(partialPath)
+		logger.Errorf("cannot store artifact %s: %v", name, err)
+		writer.Header().Set(ArtifactOffsetHeader, "0")
+		statusResponseError(writer, http.StatusInternalServerError)
+		return
+	}
+
+	api.workerContacted()
+	api.audit("job.artifact", id.String(), map[string]string{"name": name, "checksum": checksum})
+	logger.Infof("received artifact %s", name)
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// resolveImage points image, which a worker reports for the running compose
+// id, to the artifact composer received, and returns an error if it has not
+// been uploaded.
+func (api *API) resolveImage(id uuid.UUID, image *store.Image) error {
+	dir, err := api.store.GetComposeArtifactDir(id)
+	switch err.(type) {
+	case nil:
+	case *store.InvalidRequestError:
+		return err
+	default:
+		// UpdateCompose rejects the update with a more specific error
+		return nil
+	}
+
+	path := filepath.Join(dir, filepath.Base(image.Path))
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("image %s was not uploaded", filepath.Base(image.Path))
+	}
+
+	image.Path = path
+	image.Size = info.Size()
+	return nil
+}
+
+// removePartialArtifacts removes the artifacts in dir whose upload did not
+// finish.
+func removePartialArtifacts(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, ".*.part"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// A bodyReader remembers the last error of reading from Reader, to tell
+// it apart from errors of writing what was read.
+type bodyReader struct {
+	io.Reader
+	err error
+}
+
+func (r *bodyReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// partialArtifactSize returns the number of bytes of the partial artifact at
+// path, which is 0 if it does not exist.
+func partialArtifactSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// appendArtifact writes data to the partial artifact at path, replacing
+// everything after offset. It stops after length bytes of the whole
+// artifact, and returns how many bytes of it are stored.
+func appendArtifact(path string, offset, length int64, data io.Reader) (int64, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	err = file.Truncate(offset)
+	if err != nil {
+		return 0, err
+	}
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(file, io.LimitReader(data, length-offset))
+	return offset + n, err
+}
@@ -0,0 +1,200 @@
+package jobqueue_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/test"
+)
+
+func putArtifact(api *jobqueue.API, path, body string, offset, length int, checksum string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("PUT", path, strings.NewReader(body))
+	request.Header.Set(jobqueue.ArtifactOffsetHeader, strconv.Itoa(offset))
+	request.Header.Set(jobqueue.ArtifactLengthHeader, strconv.Itoa(length))
+	request.Header.Set(jobqueue.ArtifactChecksumHeader, checksum)
+	response := httptest.NewRecorder()
+	api.ServeHTTP(response, request)
+	return response
+}
+
+func TestArtifacts(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "composer-outputs-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	auditLog, err := audit.Open(filepath.Join(outputDir, "audit.log"))
+	if err != nil {
+		t.Fatalf("cannot open audit log: %v", err)
+	}
+	defer auditLog.Close()
+
+	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	s := store.NewWithOptions(nil, distro.New("fedora-30"), store.Options{OutputDir: outputDir})
+	api := jobqueue.New(nil, s, nil)
+	api.SetAuditLog(auditLog)
+	path := "/job-queue/v1/jobs/" + id.String() + "/artifacts/root.tar.xz"
+
+	content := "0123456789"
+	hash := sha256.Sum256([]byte(content))
+	checksum := "sha256:" + hex.EncodeToString(hash[:])
+
+	// composes must be running to receive artifacts
+	if response := putArtifact(api, path, content, 0, 10, checksum); response.Code != http.StatusNotFound {
+		t.Errorf("unknown compose: expected status 404, got %d", response.Code)
+	}
+	err = s.PushCompose(id, &blueprint.Blueprint{}, "tar", nil, nil)
+	if err != nil {
+		t.Fatalf("error pushing compose: %v", err)
+	}
+	if response := putArtifact(api, path, content, 0, 10, checksum); response.Code != http.StatusBadRequest {
+		t.Errorf("waiting compose: expected status 400, got %d", response.Code)
+	}
+	test.SendHTTP(api, false, "POST", "/job-queue/v1/jobs", `{}`)
+
+	// an interrupted upload
+	response := putArtifact(api, path, content[:4], 0, 10, checksum)
+	if response.Code != http.StatusAccepted || response.Header().Get(jobqueue.ArtifactOffsetHeader) != "4" {
+		t.Errorf("partial upload: unexpected status %d and offset %s", response.Code, response.Header().Get(jobqueue.ArtifactOffsetHeader))
+	}
+
+	head := httptest.NewRecorder()
+	api.ServeHTTP(head, httptest.NewRequest("HEAD", path, nil))
+	if head.Code != http.StatusOK || head.Header().Get(jobqueue.ArtifactOffsetHeader) != "4" {
+		t.Errorf("offset: unexpected status %d and offset %s", head.Code, head.Header().Get(jobqueue.ArtifactOffsetHeader))
+	}
+
+	response = putArtifact(api, path, content[2:], 2, 10, checksum)
+	if response.Code != http.StatusConflict || response.Header().Get(jobqueue.ArtifactOffsetHeader) != "4" {
+		t.Errorf("wrong offset: unexpected status %d and offset %s", response.Code, response.Header().Get(jobqueue.ArtifactOffsetHeader))
+	}
+
+	// resuming it
+	if response := putArtifact(api, path, content[4:], 4, 10, checksum); response.Code != http.StatusCreated {
+		t.Errorf("resumed upload: expected status 201, got %d", response.Code)
+	}
+	received, err := ioutil.ReadFile(filepath.Join(outputDir, id.String(), "root.tar.xz"))
+	if err != nil || string(received) != content {
+		t.Errorf("unexpected artifact %q: %v", received, err)
+	}
+	records, err := auditLog.Query(audit.Filter{Action: "job.artifact"})
+	if err != nil || len(records) != 1 || records[0].Object != id.String() || records[0].Details["name"] != "root.tar.xz" {
+		t.Errorf("unexpected audit records %+v: %v", records, err)
+	}
+
+	// a corrupted upload is discarded
+	corruptPath := "/job-queue/v1/jobs/" + id.String() + "/artifacts/corrupt"
+	if response := putArtifact(api, corruptPath, "9876543210", 0, 10, checksum); response.Code != http.StatusBadRequest {
+		t.Errorf("corrupted upload: expected status 400, got %d", response.Code)
+	}
+	if files, _ := filepath.Glob(filepath.Join(outputDir, id.String(), "*corrupt*")); len(files) > 0 {
+		t.Errorf("corrupted artifact was kept: %v", files)
+	}
+
+	for _, name := range []string{".hidden", ".."} {
+		if response := putArtifact(api, "/job-queue/v1/jobs/"+id.String()+"/artifacts/"+name, content, 0, 10, checksum); response.Code != http.StatusBadRequest {
+			t.Errorf("artifact %s: expected status 400, got %d", name, response.Code)
+		}
+	}
+
+	// an upload which is never finished
+	putArtifact(api, "/job-queue/v1/jobs/"+id.String()+"/artifacts/abandoned", content[:4], 0, 10, checksum)
+
+	// the image must have been uploaded to finish the compose
+	test.TestRoute(t, api, false, "PATCH", "/job-queue/v1/jobs/"+id.String(),
+		`{"status":"FINISHED","image":{"Path":"/elsewhere/disk.qcow2","Mime":"application/x-qemu-disk","Size":10}}`, http.StatusBadRequest, ``)
+	test.TestRoute(t, api, false, "PATCH", "/job-queue/v1/jobs/"+id.String(),
+		`{"status":"FINISHED","image":{"Path":"/elsewhere/root.tar.xz","Mime":"application/x-tar","Size":0}}`, http.StatusOK, ``)
+
+	compose, _ := s.GetCompose(id)
+	if image := compose.Image; image.Path != filepath.Join(outputDir, id.String(), "root.tar.xz") || image.Size != 10 {
+		t.Errorf("unexpected image %+v", image)
+	}
+	if files, _ := filepath.Glob(filepath.Join(outputDir, id.String(), ".*.part")); len(files) > 0 {
+		t.Errorf("incomplete artifacts were kept: %v", files)
+	}
+}
+
+type fakeSigner struct{}
+
+func (fakeSigner) Sign(data []byte) ([]byte, error) {
+	return []byte("signed"), nil
+}
+
+func (fakeSigner) Extension() string {
+	return ".sig"
+}
+
+func TestManifest(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "composer-outputs-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	id, _ := uuid.Parse("ffffffff-ffff-ffff-ffff-ffffffffffff")
+	s := store.NewWithOptions(nil, distro.New("fedora-30"), store.Options{OutputDir: outputDir})
+	api := jobqueue.New(nil, s, nil)
+	api.SetManifestSigner(fakeSigner{})
+
+	bp := &blueprint.Blueprint{Name: "test"}
+	err = s.PushCompose(id, bp, "tar", nil, &store.ComposeOptions{
+		Manifest: &manifest.Manifest{Blueprint: bp},
+	})
+	if err != nil {
+		t.Fatalf("error pushing compose: %v", err)
+	}
+	test.SendHTTP(api, false, "POST", "/job-queue/v1/jobs", `{}`)
+
+	content := "0123456789"
+	hash := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(hash[:])
+	putArtifact(api, "/job-queue/v1/jobs/"+id.String()+"/artifacts/root.tar.xz", content, 0, 10, "sha256:"+checksum)
+
+	test.TestRoute(t, api, false, "PATCH", "/job-queue/v1/jobs/"+id.String(),
+		`{"status":"FINISHED","image":{"Path":"root.tar.xz","Mime":"application/x-tar","Size":10,"Checksums":{"sha256":"`+checksum+`"}},`+
+			`"packages":[{"name":"bash","epoch":0,"version":"5.0.7","release":"1.fc30","arch":"x86_64"}]}`, http.StatusOK, ``)
+
+	dir := filepath.Join(outputDir, id.String())
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifest.FileName))
+	if err != nil {
+		t.Fatalf("manifest was not written: %v", err)
+	}
+	var m manifest.Manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if m.ComposeID != id || m.Blueprint.Name != "test" || m.Pipeline == nil || m.Image != "root.tar.xz" || m.Checksums["sha256"] != checksum {
+		t.Errorf("unexpected manifest: %s", data)
+	}
+	if len(m.Packages) != 1 || m.Packages[0].Name != "bash" || m.Packages[0].Release != "1.fc30" {
+		t.Errorf("manifest does not list the installed packages: %+v", m.Packages)
+	}
+	if compose, _ := s.GetCompose(id); len(compose.Manifest.Packages) != 1 {
+		t.Errorf("compose does not list the installed packages: %+v", compose.Manifest.Packages)
+	}
+
+	signature, err := ioutil.ReadFile(filepath.Join(dir, manifest.FileName+".sig"))
+	if err != nil || string(signature) != "signed" {
+		t.Errorf("unexpected signature %q: %v", signature, err)
+	}
+}
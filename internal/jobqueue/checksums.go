@@ -0,0 +1,84 @@
+package jobqueue
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ChecksumExtension is appended to the image's file name to name the file
+// which lists the checksums of all artifacts of a compose.
+const ChecksumExtension = ".CHECKSUM"
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ArtifactChecksums returns the checksums of the file at path as hexadecimal
+// strings, keyed by algorithm. SHA-256 is always included, and algorithms can
+// add "sha512".
+func ArtifactChecksums(path string, algorithms []string) (map[string]string, error) {
+	hashes := map[string]hash.Hash{"sha256": sha256.New()}
+	for _, algorithm := range algorithms {
+		newHash, ok := checksumHashes[algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unknown checksum algorithm: %s", algorithm)
+		}
+		hashes[algorithm] = newHash()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	writers := make([]io.Writer, 0, len(hashes))
+	for _, h := range hashes {
+		writers = append(writers, h)
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), file)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		checksums[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return checksums, nil
+}
+
+// WriteChecksumFile writes the checksums of artifacts, keyed by their file
+// name, to w in the format of "sha256sum --tag", which "sha256sum -c" and
+// "sha512sum -c" can verify.
+func WriteChecksumFile(w io.Writer, artifacts map[string]map[string]string) error {
+	var names []string
+	for name := range artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var algorithms []string
+		for algorithm := range artifacts[name] {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+
+		for _, algorithm := range algorithms {
+			_, err := fmt.Fprintf(w, "%s (%s) = %s\n", strings.ToUpper(algorithm), name, artifacts[name][algorithm])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
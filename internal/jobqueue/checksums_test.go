@@ -0,0 +1,71 @@
+package jobqueue_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+)
+
+func TestArtifactChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-checksums-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image")
+	err = ioutil.WriteFile(path, []byte("abc"), 0644)
+	if err != nil {
+		t.Fatalf("cannot write image: %v", err)
+	}
+
+	var cases = []struct {
+		Algorithms        []string
+		ExpectedChecksums map[string]string
+	}{
+		{nil, map[string]string{
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		}},
+		{[]string{"sha512"}, map[string]string{
+			"sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+			"sha512": "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		}},
+	}
+
+	for _, c := range cases {
+		checksums, err := jobqueue.ArtifactChecksums(path, c.Algorithms)
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", c.Algorithms, err)
+		} else if !reflect.DeepEqual(checksums, c.ExpectedChecksums) {
+			t.Errorf("%v: unexpected checksums %v", c.Algorithms, checksums)
+		}
+	}
+
+	if _, err := jobqueue.ArtifactChecksums(path, []string{"md5"}); err == nil {
+		t.Errorf("unknown algorithm was accepted")
+	}
+	if _, err := jobqueue.ArtifactChecksums(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("missing file was accepted")
+	}
+}
+
+func TestWriteChecksumFile(t *testing.T) {
+	var buf bytes.Buffer
+	err := jobqueue.WriteChecksumFile(&buf, map[string]map[string]string{
+		"disk.qcow2": {"sha512": "cd", "sha256": "ab"},
+		"disk.log":   {"sha256": "ef"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "SHA256 (disk.log) = ef\nSHA256 (disk.qcow2) = ab\nSHA512 (disk.qcow2) = cd\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected checksum file:\n%s", buf.String())
+	}
+}
@@ -1,16 +1,26 @@
 package jobqueue
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/logging"
 	"github.com/osbuild/osbuild-composer/internal/pipeline"
+	"github.com/osbuild/osbuild-composer/internal/retention"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
 	"github.com/osbuild/osbuild-composer/internal/target"
 	"github.com/osbuild/osbuild-composer/internal/upload/awsupload"
@@ -21,146 +31,425 @@ type Job struct {
 	Pipeline   *pipeline.Pipeline `json:"pipeline"`
 	Targets    []*target.Target   `json:"targets"`
 	OutputType string             `json:"output_type"`
+	// ID of the API request which started the compose, to be sent along
+	// with all requests about this job.
+	RequestID string `json:"request_id,omitempty"`
 }
 
+// Reasons for which a job can fail.
+const (
+	// osbuild could not build the image
+	FailureBuild = "build"
+	// the image could not be exported to one of the targets
+	FailureTarget = "target"
+)
+
 type JobStatus struct {
 	Status string       `json:"status"`
 	Image  *store.Image `json:"image"`
+	// One of the Failure* constants if Status is "FAILED".
+	Failure string `json:"failure,omitempty"`
+	// Results of exporting the image, once the job is done.
+	Targets []TargetResult `json:"targets,omitempty"`
+	// Packages installed in the image, once it is built.
+	Packages []rpmmd.PackageSpec `json:"packages,omitempty"`
+}
+
+// A TargetResult describes how exporting the image to one target went.
+type TargetResult struct {
+	// Name of the target, for example "org.osbuild.aws"
+	Name string `json:"name"`
+	// Time taken in seconds
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// OsbuildLogName is the name of the artifact which holds the output of
+// osbuild. It is sent for failed builds as well.
+const OsbuildLogName = "osbuild.log"
+
+// An ArtifactUploader sends the files a job produced to composer. checksum
+// is the SHA-256 of the file, as in "sha256:<hex digest>".
+type ArtifactUploader interface {
+	UploadArtifact(job *Job, name, path, checksum string) error
 }
 
-func (job *Job) Run(d distro.Distro) (*store.Image, error, []error) {
+// Run builds the job's pipeline with osbuild, using storeDir as osbuild's
+// object store, and exports the result to all of the job's targets. Files
+// for the local target are sent to composer with uploader, together with a
+// file listing their checksums with the given algorithms in addition to
+// SHA-256, as is osbuild's log. It returns an error if the image could not be
+// built, and the packages installed in the image and the result of exporting
+// it to each target otherwise. The packages are left out with a warning on
+// logger if they cannot be determined.
+func (job *Job) Run(logger *logging.Logger, d distro.Distro, storeDir string, uploader ArtifactUploader, checksumAlgorithms []string) (*store.Image, []rpmmd.PackageSpec, error, []TargetResult) {
 	build := pipeline.Build{
 		Runner: d.Runner(),
 	}
 
 	buildFile, err := ioutil.TempFile("", "osbuild-worker-build-env-*")
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 	defer os.Remove(buildFile.Name())
 
 	err = json.NewEncoder(buildFile).Encode(build)
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 
+	// keep the object store's garbage collection from removing the image
+	// until it is exported
+	lock, err := retention.LockObjectStore(storeDir)
+	if err != nil {
+		return nil, nil, err, nil
+	}
+	defer lock.Unlock()
+
 	cmd := exec.Command(
 		"osbuild",
-		"--store", "/var/cache/osbuild-composer/store",
+		"--store", storeDir,
 		"--build-env", buildFile.Name(),
 		"--json", "-",
 	)
-	cmd.Stderr = os.Stderr
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 
 	err = cmd.Start()
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 
 	err = json.NewEncoder(stdin).Encode(job.Pipeline)
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 	stdin.Close()
 
-	var result struct {
-		TreeID   string `json:"tree_id"`
-		OutputID string `json:"output_id"`
+	output, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return nil, nil, err, nil
+	}
+
+	buildErr := cmd.Wait()
+
+	// osbuild prints its result even if the build failed, but not if it
+	// could not start building
+	var result osbuildResult
+	err = json.Unmarshal(output, &result)
+	if err != nil && buildErr == nil {
+		buildErr = fmt.Errorf("cannot parse the output of osbuild: %v", err)
+	}
+
+	if job.hasLocalTarget() {
+		err = job.uploadFile(OsbuildLogName, func(w io.Writer) error {
+			return writeOsbuildLog(w, &result, stderr.Bytes())
+		}, uploader)
+		if err != nil {
+			return nil, nil, err, nil
+		}
 	}
-	err = json.NewDecoder(stdout).Decode(&result)
+
+	if buildErr != nil {
+		return nil, nil, buildErr, nil
+	}
+
+	// keep the objects of this build for the next one
+	err = retention.MarkObjectsUsed(storeDir, result.objectIDs(), time.Now())
 	if err != nil {
-		return nil, err, nil
+		logger.Warningf("cannot mark objects as used: %v", err)
 	}
 
-	err = cmd.Wait()
+	// the host's rpm might not be able to read the image's database,
+	// which must not fail a build that osbuild finished
+	packages, err := installedPackages(filepath.Join(storeDir, "refs", result.TreeID))
 	if err != nil {
-		return nil, err, nil
+		logger.Warningf("cannot list the packages of the image: %v", err)
+		packages = nil
 	}
 
 	filename, mimeType, err := d.FilenameFromType(job.OutputType)
 	if err != nil {
-		return nil, err, nil
+		return nil, nil, err, nil
 	}
 
 	var image store.Image
-
-	var r []error
+	var results []TargetResult
 
 	for _, t := range job.Targets {
-		switch options := t.Options.(type) {
-		case *target.LocalTargetOptions:
-			err = os.MkdirAll(options.Location, 0755)
-			if err != nil {
-				r = append(r, err)
-				continue
-			}
+		start := time.Now()
 
-			cp := exec.Command("cp", "-a", "-L", "/var/cache/osbuild-composer/store/refs/"+result.OutputID+"/.", options.Location)
-			cp.Stderr = os.Stderr
-			cp.Stdout = os.Stdout
-			err = cp.Run()
-			if err != nil {
-				r = append(r, err)
-				continue
-			}
+		targetImage, err := job.export(t, filepath.Join(storeDir, "refs", result.OutputID), filename, mimeType, uploader, checksumAlgorithms)
+		if targetImage != nil {
+			image = *targetImage
+		}
+
+		r := TargetResult{
+			Name:     t.Name,
+			Duration: time.Since(start).Seconds(),
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+
+	return &image, packages, nil, results
+}
 
-			imagePath := options.Location + "/" + filename
-			file, err := os.Open(imagePath)
+// osbuildResult is what osbuild prints when it is called with --json. The
+// result of the pipeline which built the tree is nested in Build.
+type osbuildResult struct {
+	TreeID    string               `json:"tree_id"`
+	OutputID  string               `json:"output_id"`
+	Build     *osbuildResult       `json:"build"`
+	Stages    []osbuildStageResult `json:"stages"`
+	Assembler *osbuildStageResult  `json:"assembler"`
+	Success   bool                 `json:"success"`
+}
+
+type osbuildStageResult struct {
+	Name    string `json:"name"`
+	Success bool   `json:"success"`
+	Output  string `json:"output"`
+}
+
+// writeOsbuildLog writes the output of all stages and of the assembler in
+// result, starting with the build pipeline, followed by stderr.
+func writeOsbuildLog(w io.Writer, result *osbuildResult, stderr []byte) error {
+	var write func(result *osbuildResult) error
+	write = func(result *osbuildResult) error {
+		if result.Build != nil {
+			err := write(result.Build)
 			if err != nil {
-				r = append(r, err)
-				continue
+				return err
 			}
+		}
 
-			fileStat, err := file.Stat()
+		stages := append([]osbuildStageResult{}, result.Stages...)
+		if result.Assembler != nil {
+			stages = append(stages, *result.Assembler)
+		}
+		for _, stage := range stages {
+			status := "succeeded"
+			if !stage.Success {
+				status = "failed"
+			}
+			_, err := fmt.Fprintf(w, "%s (%s):\n%s\n", stage.Name, status, stage.Output)
 			if err != nil {
-				return nil, err, nil
+				return err
 			}
+		}
+		return nil
+	}
 
-			image = store.Image{
-				Path: imagePath,
-				Mime: mimeType,
-				Size: fileStat.Size(),
+	err := write(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(stderr)
+	return err
+}
+
+// objectIDs returns the IDs of the trees and outputs of result, and of its
+// build pipeline.
+func (result *osbuildResult) objectIDs() []string {
+	var ids []string
+	for r := result; r != nil; r = r.Build {
+		for _, id := range []string{r.TreeID, r.OutputID} {
+			if id != "" {
+				ids = append(ids, id)
 			}
+		}
+	}
+	return ids
+}
+
+func (job *Job) hasLocalTarget() bool {
+	for _, t := range job.Targets {
+		if _, ok := t.Options.(*target.LocalTargetOptions); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// installedPackages returns the packages in the rpm database of the file
+// system tree in treeDir, sorted by name.
+func installedPackages(treeDir string) ([]rpmmd.PackageSpec, error) {
+	// the location of the database depends on the version of rpm in
+	// the tree, which might differ from the host's
+	var dbPath string
+	for _, dir := range []string{"usr/lib/sysimage/rpm", "var/lib/rpm"} {
+		files, err := ioutil.ReadDir(filepath.Join(treeDir, dir))
+		if err == nil && len(files) > 0 {
+			dbPath = filepath.Join(treeDir, dir)
+			break
+		}
+	}
+	if dbPath == "" {
+		return nil, fmt.Errorf("no rpm database in %s", treeDir)
+	}
+
+	cmd := exec.Command("rpm", "--dbpath", dbPath, "-qa", "--qf", "%{NAME}\\t%|EPOCH?{%{EPOCH}}:{0}|\\t%{VERSION}\\t%{RELEASE}\\t%{ARCH}\\n")
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInstalledPackages(string(output))
+}
+
+func parseInstalledPackages(output string) ([]rpmmd.PackageSpec, error) {
+	var packages []rpmmd.PackageSpec
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected output of rpm: %s", line)
+		}
+		// imported signing keys show up as packages
+		if fields[0] == "gpg-pubkey" {
+			continue
+		}
+
+		epoch, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid epoch of %s: %s", fields[0], fields[1])
+		}
+		packages = append(packages, rpmmd.PackageSpec{
+			Name:    fields[0],
+			Epoch:   uint(epoch),
+			Version: fields[2],
+			Release: fields[3],
+			Arch:    fields[4],
+		})
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
+	})
+	return packages, nil
+}
+
+// export sends or uploads the image in outputDir to t. It returns the image
+// if t keeps it available to composer.
+func (job *Job) export(t *target.Target, outputDir, filename, mimeType string, uploader ArtifactUploader, checksumAlgorithms []string) (*store.Image, error) {
+	switch options := t.Options.(type) {
+	case *target.LocalTargetOptions:
+		// options.Location is on composer's side, which might not
+		// share a file system with the worker
+		files, err := ioutil.ReadDir(outputDir)
+		if err != nil {
+			return nil, err
+		}
 
-		case *target.AWSTargetOptions:
-			a, err := awsupload.New(options.Region, options.AccessKeyID, options.SecretAccessKey)
+		checksums := make(map[string]map[string]string)
+		for _, file := range files {
+			path := filepath.Join(outputDir, file.Name())
+			// osbuild's outputs may be symlinks into its store
+			info, err := os.Stat(path)
 			if err != nil {
-				r = append(r, err)
-				continue
+				return nil, err
 			}
-
-			if options.Key == "" {
-				options.Key = job.ID.String()
+			if !info.Mode().IsRegular() {
+				continue
 			}
 
-			_, err = a.Upload("/var/cache/osbuild-composer/store/refs/"+result.OutputID+"/image.raw.xz", options.Bucket, options.Key)
+			checksums[file.Name()], err = ArtifactChecksums(path, checksumAlgorithms)
 			if err != nil {
-				r = append(r, err)
-				continue
+				return nil, err
 			}
 
-			/* TODO: communicate back the AMI */
-			_, err = a.Register(t.ImageName, options.Bucket, options.Key)
+			err = uploader.UploadArtifact(job, file.Name(), path, "sha256:"+checksums[file.Name()]["sha256"])
 			if err != nil {
-				r = append(r, err)
-				continue
+				return nil, fmt.Errorf("cannot send %s to composer: %v", file.Name(), err)
 			}
-		case *target.AzureTargetOptions:
-		default:
-			r = append(r, fmt.Errorf("invalid target type"))
 		}
-		r = append(r, nil)
+
+		err = job.uploadFile(filename+ChecksumExtension, func(w io.Writer) error {
+			return WriteChecksumFile(w, checksums)
+		}, uploader)
+		if err != nil {
+			return nil, err
+		}
+
+		imagePath := filepath.Join(outputDir, filename)
+		fileStat, err := os.Stat(imagePath)
+		if err != nil {
+			return nil, err
+		}
+
+		return &store.Image{
+			Path:      filepath.Join(options.Location, filename),
+			Mime:      mimeType,
+			Size:      fileStat.Size(),
+			Checksums: checksums[filename],
+		}, nil
+
+	case *target.AWSTargetOptions:
+		a, err := awsupload.New(options.Region, options.AccessKeyID, options.SecretAccessKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if options.Key == "" {
+			options.Key = job.ID.String()
+		}
+
+		_, err = a.Upload(outputDir+"/image.raw.xz", options.Bucket, options.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		/* TODO: communicate back the AMI */
+		_, err = a.Register(t.ImageName, options.Bucket, options.Key)
+		return nil, err
+
+	case *target.AzureTargetOptions:
+		return nil, nil
 	}
 
-	return &image, nil, r
+	return nil, fmt.Errorf("invalid target type")
+}
+
+// uploadFile sends the artifact name, whose content is written by write, to
+// composer.
+func (job *Job) uploadFile(name string, write func(w io.Writer) error, uploader ArtifactUploader) error {
+	file, err := ioutil.TempFile("", "osbuild-worker-artifact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	err = write(file)
+	if err != nil {
+		return err
+	}
+
+	fileChecksums, err := ArtifactChecksums(file.Name(), nil)
+	if err != nil {
+		return err
+	}
+
+	err = uploader.UploadArtifact(job, name, file.Name(), "sha256:"+fileChecksums["sha256"])
+	if err != nil {
+		return fmt.Errorf("cannot send %s to composer: %v", name, err)
+	}
+	return nil
 }
@@ -0,0 +1,67 @@
+package jobqueue
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+func TestParseInstalledPackages(t *testing.T) {
+	output := "kernel\t0\t5.3.7\t301.fc31\tx86_64\n" +
+		"gpg-pubkey\t0\t3c3359c4\t5c6ae44d\t(none)\n" +
+		"bash\t0\t5.0.7\t1.fc31\tx86_64\n" +
+		"shim-x64\t1\t15\t8\tx86_64\n"
+
+	packages, err := parseInstalledPackages(output)
+	if err != nil {
+		t.Fatalf("cannot parse packages: %v", err)
+	}
+	expected := []rpmmd.PackageSpec{
+		{Name: "bash", Epoch: 0, Version: "5.0.7", Release: "1.fc31", Arch: "x86_64"},
+		{Name: "kernel", Epoch: 0, Version: "5.3.7", Release: "301.fc31", Arch: "x86_64"},
+		{Name: "shim-x64", Epoch: 1, Version: "15", Release: "8", Arch: "x86_64"},
+	}
+	if !reflect.DeepEqual(packages, expected) {
+		t.Errorf("unexpected packages %+v", packages)
+	}
+
+	if _, err := parseInstalledPackages("bash\t0\t5.0.7\n"); err == nil {
+		t.Errorf("invalid output was accepted")
+	}
+}
+
+func TestWriteOsbuildLog(t *testing.T) {
+	output := `{
+		"build": {
+			"stages": [{"name": "org.osbuild.rpm", "success": true, "output": "Installing build packages\n"}],
+			"success": true
+		},
+		"stages": [
+			{"name": "org.osbuild.rpm", "success": true, "output": "Installing packages\n"},
+			{"name": "org.osbuild.script", "success": false, "output": "exit status 1\n"}
+		],
+		"success": false
+	}`
+
+	var result osbuildResult
+	err := json.Unmarshal([]byte(output), &result)
+	if err != nil {
+		t.Fatalf("cannot parse result: %v", err)
+	}
+
+	var log bytes.Buffer
+	err = writeOsbuildLog(&log, &result, []byte("Traceback\n"))
+	if err != nil {
+		t.Fatalf("cannot write log: %v", err)
+	}
+	expected := "org.osbuild.rpm (succeeded):\nInstalling build packages\n\n" +
+		"org.osbuild.rpm (succeeded):\nInstalling packages\n\n" +
+		"org.osbuild.script (failed):\nexit status 1\n\n" +
+		"Traceback\n"
+	if log.String() != expected {
+		t.Errorf("unexpected log %q", log.String())
+	}
+}
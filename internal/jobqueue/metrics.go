@@ -0,0 +1,42 @@
+package jobqueue
+
+import (
+	"github.com/osbuild/osbuild-composer/internal/metrics"
+)
+
+var (
+	composeFailures = metrics.NewCounter(
+		"composer_compose_failures_total",
+		"Number of failed composes by output type and reason.",
+		"output_type", "reason",
+	)
+	targetDuration = metrics.NewHistogram(
+		"composer_target_duration_seconds",
+		"Time workers took to export images, by target type and result.",
+		metrics.ExponentialBuckets(1, 3, 9),
+		"target", "result",
+	)
+)
+
+func init() {
+	metrics.Register(composeFailures, targetDuration)
+}
+
+// observe records the metrics reported by a worker for a finished job.
+func observe(outputType string, status *JobStatus) {
+	if status.Status == "FAILED" {
+		reason := status.Failure
+		if reason == "" {
+			reason = "unknown"
+		}
+		composeFailures.Inc(outputType, reason)
+	}
+
+	for _, r := range status.Targets {
+		result := "success"
+		if r.Error != "" {
+			result = "failure"
+		}
+		targetDuration.Observe(r.Duration, r.Name, result)
+	}
+}
@@ -0,0 +1,162 @@
+// Package logging provides leveled, structured logging for composer and its
+// worker.
+//
+// A Logger carries a set of fields, such as the ID of the compose or request
+// it is logging about, which are attached to every message. Messages are
+// written as human-readable text, as JSON objects, or natively to the
+// systemd journal, where fields become journal fields.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RequestIDHeader is the HTTP header which carries the ID of the request
+// that caused an operation, from weldr through the job queue to the worker.
+const RequestIDHeader = "X-Request-ID"
+
+// A Level is the severity of a message.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warning
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < Debug || int(l) >= len(levelNames) {
+		return "invalid"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level called name.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level: %s", name)
+}
+
+// A Field is a named value attached to a message.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// An Entry is a single message.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// A Logger writes messages of at least its level to an output. All methods
+// can be called on a nil Logger, which discards all messages.
+type Logger struct {
+	output Output
+	level  Level
+	fields []Field
+}
+
+func New(output Output, level Level) *Logger {
+	return &Logger{output: output, level: level}
+}
+
+var defaultLogger = New(NewTextOutput(os.Stderr), Info)
+
+// Default returns the logger used by code which is not handed a logger
+// explicitly. It writes text to stderr until replaced with SetDefault.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger. It must be called before any
+// other goroutines are started.
+func SetDefault(logger *Logger) {
+	defaultLogger = logger
+}
+
+// With returns a copy of l which attaches the field key to all messages.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+
+	fields := make([]Field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	fields = append(fields, Field{key, fmt.Sprint(value)})
+
+	return &Logger{output: l.output, level: l.level, fields: fields}
+}
+
+// Enabled returns whether messages of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level >= l.level
+}
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	err := l.output.Write(&Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+		Fields:  l.fields,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot write log message: %v\n", err)
+	}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(Debug, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(Warning, format, args)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args)
+}
+
+// Fatalf logs an error and exits the process.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(Error, format, args)
+	os.Exit(1)
+}
+
+type contextKey int
+
+const loggerKey contextKey = 0
+
+// NewContext returns a copy of ctx which carries logger.
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger stored in ctx by NewContext, or the default
+// logger if there is none.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return logger
+	}
+	return Default()
+}
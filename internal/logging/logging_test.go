@@ -0,0 +1,98 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+)
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(logging.NewTextOutput(&buf), logging.Info)
+
+	logger.Debugf("not logged")
+	logger.With("compose_id", 42).With("blueprint", "my blueprint").Warningf("compose %s", "failed")
+	logger.Infof("plain")
+
+	expected := regexp.MustCompile(`^\S+Z warning compose failed compose_id=42 blueprint="my blueprint"\n\S+Z info plain\n$`)
+	if !expected.MatchString(buf.String()) {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(logging.NewJSONOutput(&buf), logging.Debug)
+
+	logger.With("request_id", "abc").Debugf("GET %s", "/api/status")
+
+	var entry map[string]string
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("cannot parse output %s: %v", buf.String(), err)
+	}
+	if entry["time"] == "" {
+		t.Errorf("entry has no time: %v", entry)
+	}
+	delete(entry, "time")
+
+	expected := map[string]string{"level": "debug", "message": "GET /api/status", "request_id": "abc"}
+	if len(entry) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, entry)
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %v, got %v", expected, entry)
+		}
+	}
+}
+
+func TestWithDoesNotShareFields(t *testing.T) {
+	var buf bytes.Buffer
+	base := logging.New(logging.NewTextOutput(&buf), logging.Info).With("a", 1)
+
+	// both loggers may share the backing array of base's fields
+	first := base.With("b", 2)
+	base.With("c", 3)
+	first.Infof("message")
+
+	if !regexp.MustCompile(` a=1 b=2\n$`).MatchString(buf.String()) {
+		t.Errorf("unexpected output: %s", buf.String())
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var logger *logging.Logger
+	logger.With("key", "value").Errorf("discarded")
+	if logger.Enabled(logging.Error) {
+		t.Errorf("nil logger claims to be enabled")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if logging.FromContext(context.Background()) != logging.Default() {
+		t.Errorf("context without logger does not return the default logger")
+	}
+
+	logger := logging.New(logging.NewTextOutput(&bytes.Buffer{}), logging.Info)
+	if logging.FromContext(logging.NewContext(context.Background(), logger)) != logger {
+		t.Errorf("context does not return its logger")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []logging.Level{logging.Debug, logging.Info, logging.Warning, logging.Error} {
+		parsed, err := logging.ParseLevel(level.String())
+		if err != nil || parsed != level {
+			t.Errorf("cannot parse %s: %v, %v", level, parsed, err)
+		}
+	}
+
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Errorf("parsed invalid level")
+	}
+}
@@ -0,0 +1,157 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// An Output writes entries somewhere. It must be safe to use from multiple
+// goroutines.
+type Output interface {
+	Write(e *Entry) error
+}
+
+// NewOutput returns an output in format, which is one of "text", "json",
+// "journal" or "auto". Text and JSON are written to w. Auto selects the
+// journal if composer's stderr is connected to it, and text otherwise.
+func NewOutput(format string, w io.Writer) (Output, error) {
+	switch format {
+	case "text":
+		return NewTextOutput(w), nil
+	case "json":
+		return NewJSONOutput(w), nil
+	case "journal":
+		if !journal.Enabled() {
+			return nil, fmt.Errorf("the systemd journal is not available")
+		}
+		return NewJournalOutput(), nil
+	case "auto":
+		// systemd sets JOURNAL_STREAM for services whose output goes to
+		// the journal
+		if os.Getenv("JOURNAL_STREAM") != "" && journal.Enabled() {
+			return NewJournalOutput(), nil
+		}
+		return NewTextOutput(w), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format: %s", format)
+}
+
+type textOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewTextOutput returns an output which writes one line per entry, such as
+//
+//	2019-12-01T10:00:00Z info compose started compose_id=30000000-...
+//
+// Values containing spaces or quotes are quoted.
+func NewTextOutput(w io.Writer) Output {
+	return &textOutput{w: w}
+}
+
+func (o *textOutput) Write(e *Entry) error {
+	var b strings.Builder
+	b.WriteString(e.Time.UTC().Format(time.RFC3339))
+	b.WriteByte(' ')
+	b.WriteString(e.Level.String())
+	b.WriteByte(' ')
+	b.WriteString(e.Message)
+	for _, f := range e.Fields {
+		value := f.Value
+		if value == "" || strings.ContainsAny(value, " \"=\n") {
+			value = strconv.Quote(value)
+		}
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err := io.WriteString(o.w, b.String())
+	return err
+}
+
+type jsonOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONOutput returns an output which writes one JSON object per line,
+// with the keys "time", "level", "message" and the entry's fields.
+func NewJSONOutput(w io.Writer) Output {
+	return &jsonOutput{w: w}
+}
+
+func (o *jsonOutput) Write(e *Entry) error {
+	object := make(map[string]string, len(e.Fields)+3)
+	for _, f := range e.Fields {
+		object[f.Key] = f.Value
+	}
+	object["time"] = e.Time.UTC().Format(time.RFC3339Nano)
+	object["level"] = e.Level.String()
+	object["message"] = e.Message
+
+	line, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	_, err = o.w.Write(line)
+	return err
+}
+
+type journalOutput struct{}
+
+// NewJournalOutput returns an output which sends entries to the systemd
+// journal. Field keys are converted to journal field names, for example
+// "compose_id" to "COMPOSE_ID".
+func NewJournalOutput() Output {
+	return journalOutput{}
+}
+
+var journalPriorities = map[Level]journal.Priority{
+	Debug:   journal.PriDebug,
+	Info:    journal.PriInfo,
+	Warning: journal.PriWarning,
+	Error:   journal.PriErr,
+}
+
+func (journalOutput) Write(e *Entry) error {
+	vars := make(map[string]string, len(e.Fields))
+	for _, f := range e.Fields {
+		vars[journalFieldName(f.Key)] = f.Value
+	}
+	return journal.Send(e.Message, journalPriorities[e.Level], vars)
+}
+
+// journalFieldName converts key to a valid journal field name, which only
+// consists of upper case letters, digits and underscores, and does not start
+// with an underscore.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
@@ -0,0 +1,132 @@
+// Package manifest describes how an image was built, in a file which can be
+// signed to vouch for the image.
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/pipeline"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// FileName is the name of the manifest in the results of a compose.
+const FileName = "manifest.json"
+
+// A Manifest describes the inputs and the result of a compose.
+type Manifest struct {
+	ComposeID uuid.UUID            `json:"compose_id"`
+	Blueprint *blueprint.Blueprint `json:"blueprint"`
+	// The packages installed in the image, as reported by the worker
+	// which built it. Empty until the image is built.
+	Packages []rpmmd.PackageSpec `json:"packages"`
+	Pipeline *pipeline.Pipeline  `json:"pipeline"`
+	// File name of the image and its checksums, keyed by algorithm.
+	Image     string            `json:"image,omitempty"`
+	Checksums map[string]string `json:"checksums,omitempty"`
+}
+
+// A Signer creates detached signatures.
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+	// Extension of the signature's file name, which is appended to the
+	// name of the signed file.
+	Extension() string
+}
+
+// NewSigner returns a signer of type "gpg" or "ssh". For gpg, key is the ID
+// of a secret key in the keyring in home, or in the default keyring if home
+// is empty. For ssh, key is the path of a private key, and home is ignored.
+func NewSigner(typ, key, home string) (Signer, error) {
+	switch typ {
+	case "gpg":
+		return &gpgSigner{key, home}, nil
+	case "ssh":
+		return &sshSigner{key}, nil
+	default:
+		return nil, fmt.Errorf("unknown signature type: %s", typ)
+	}
+}
+
+// Write writes m to FileName in dir, together with its signature if signer
+// is not nil.
+func Write(dir string, m *Manifest, signer Signer) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	path := filepath.Join(dir, FileName)
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	if signer == nil {
+		return nil
+	}
+
+	signature, err := signer.Sign(data)
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("cannot sign manifest: %v", err)
+	}
+
+	return ioutil.WriteFile(path+signer.Extension(), signature, 0644)
+}
+
+type gpgSigner struct {
+	key  string
+	home string
+}
+
+func (s *gpgSigner) Sign(data []byte) ([]byte, error) {
+	args := []string{"--batch", "--yes", "--armor", "--local-user", s.key, "--detach-sign", "--output", "-"}
+	if s.home != "" {
+		args = append([]string{"--homedir", s.home}, args...)
+	}
+	return run(exec.Command("gpg", args...), data)
+}
+
+func (s *gpgSigner) Extension() string {
+	return ".asc"
+}
+
+// Namespace of SSH signatures, which keeps them from being valid for other
+// purposes. It must be passed to "ssh-keygen -Y verify".
+const sshNamespace = "osbuild-composer"
+
+type sshSigner struct {
+	keyFile string
+}
+
+func (s *sshSigner) Sign(data []byte) ([]byte, error) {
+	// without any files, ssh-keygen signs its input
+	return run(exec.Command("ssh-keygen", "-q", "-Y", "sign", "-f", s.keyFile, "-n", sshNamespace), data)
+}
+
+func (s *sshSigner) Extension() string {
+	return ".sig"
+}
+
+func run(cmd *exec.Cmd, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v: %s", filepath.Base(cmd.Path), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
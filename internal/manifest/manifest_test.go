@@ -0,0 +1,103 @@
+package manifest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+)
+
+func TestWriteSSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "composer-manifest-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "key")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "composer", "-f", key).CombinedOutput()
+	if err != nil {
+		t.Fatalf("cannot create key: %v: %s", err, out)
+	}
+
+	signer, err := manifest.NewSigner("ssh", key, "")
+	if err != nil {
+		t.Fatalf("cannot create signer: %v", err)
+	}
+
+	m := &manifest.Manifest{
+		Blueprint: &blueprint.Blueprint{Name: "test"},
+		Image:     "disk.qcow2",
+		Checksums: map[string]string{"sha256": "ab"},
+	}
+	err = manifest.Write(dir, m, signer)
+	if err != nil {
+		t.Fatalf("cannot write manifest: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifest.FileName))
+	if err != nil {
+		t.Fatalf("cannot read manifest: %v", err)
+	}
+	var written manifest.Manifest
+	if err := json.Unmarshal(data, &written); err != nil || written.Image != "disk.qcow2" {
+		t.Errorf("unexpected manifest %s: %v", data, err)
+	}
+
+	publicKey, err := ioutil.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatalf("cannot read public key: %v", err)
+	}
+	allowedSigners := filepath.Join(dir, "allowed_signers")
+	err = ioutil.WriteFile(allowedSigners, append([]byte("composer "), publicKey...), 0644)
+	if err != nil {
+		t.Fatalf("cannot write allowed signers: %v", err)
+	}
+
+	verify := func(data []byte) error {
+		cmd := exec.Command("ssh-keygen", "-Y", "verify", "-f", allowedSigners, "-I", "composer",
+			"-n", "osbuild-composer", "-s", filepath.Join(dir, manifest.FileName+".sig"))
+		cmd.Stdin = bytes.NewReader(data)
+		return cmd.Run()
+	}
+	if err := verify(data); err != nil {
+		t.Errorf("signature is invalid: %v", err)
+	}
+	if err := verify(append(data, ' ')); err == nil {
+		t.Errorf("signature of a changed manifest is valid")
+	}
+}
+
+func TestWriteWithoutSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-manifest-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = manifest.Write(dir, &manifest.Manifest{}, nil)
+	if err != nil {
+		t.Fatalf("cannot write manifest: %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != manifest.FileName {
+		t.Errorf("unexpected files: %v", files)
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	if _, err := manifest.NewSigner("x509", "key", ""); err == nil {
+		t.Errorf("unknown signature type was accepted")
+	}
+}
@@ -0,0 +1,327 @@
+// Package metrics collects counters and histograms about composer's
+// operation and exposes them in the Prometheus text format.
+//
+// Metrics are usually declared as package variables and registered in the
+// default registry from an init function, so that they can be updated from
+// anywhere without threading them through constructors.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Metric can be registered in a Registry.
+type Metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// A Registry holds a set of metrics with unique names.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]Metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]Metric)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register adds metrics to the registry. It panics if a metric with the same
+// name has already been registered.
+func (r *Registry) Register(metrics ...Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range metrics {
+		if _, exists := r.metrics[m.name()]; exists {
+			panic("a metric with this name already exists: " + m.name())
+		}
+		r.metrics[m.name()] = m
+	}
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]Metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	return buf.Flush()
+}
+
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(writer)
+}
+
+// Register adds metrics to the default registry.
+func Register(metrics ...Metric) {
+	defaultRegistry.Register(metrics...)
+}
+
+// Handler returns a handler which serves the metrics of the default registry.
+func Handler() http.Handler {
+	return defaultRegistry
+}
+
+// labelSet maps combinations of label values to keys for the maps which
+// hold a metric's values.
+type labelSet struct {
+	labels []string
+	keys   map[string][]string
+}
+
+func newLabelSet(labels []string) labelSet {
+	return labelSet{labels, make(map[string][]string)}
+}
+
+// key returns a map key for values. It panics if the number of values does
+// not match the number of labels, because that is a programming error.
+func (l *labelSet) key(values []string) string {
+	if len(values) != len(l.labels) {
+		panic(fmt.Sprintf("expected %d label values, got %d", len(l.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, exists := l.keys[key]; !exists {
+		l.keys[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns all keys in a stable order.
+func (l *labelSet) sortedKeys() []string {
+	keys := make([]string, 0, len(l.keys))
+	for key := range l.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// format returns the label pairs for key, with extra appended, in the form
+// `{a="1",b="2"}`.
+func (l *labelSet) format(key string, extra ...string) string {
+	values := l.keys[key]
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, label := range l.labels {
+		pairs = append(pairs, formatLabel(label, values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, formatLabel(extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabel(label, value string) string {
+	return label + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// A Counter is a value which only ever increases, partitioned by labels.
+type Counter struct {
+	metricName string
+	help       string
+
+	mu     sync.Mutex
+	labels labelSet
+	values map[string]float64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{
+		metricName: name,
+		help:       help,
+		labels:     newLabelSet(labels),
+		values:     make(map[string]float64),
+	}
+}
+
+// Inc increments the counter for labelValues by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for labelValues by v, which must not be negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("counters cannot decrease")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.labels.key(labelValues)] += v
+}
+
+func (c *Counter) name() string {
+	return c.metricName
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, key := range c.labels.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labels.format(key), formatFloat(c.values[key]))
+	}
+}
+
+// A Histogram counts observations in configurable buckets, partitioned by
+// labels.
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+
+	mu     sync.Mutex
+	labels labelSet
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	// counts[i] is the number of observations in (buckets[i-1], buckets[i]]
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given upper bounds of its
+// buckets, which must be sorted in increasing order. A bucket for +Inf is
+// always added.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("histogram buckets must be sorted")
+	}
+
+	return &Histogram{
+		metricName: name,
+		help:       help,
+		buckets:    buckets,
+		labels:     newLabelSet(labels),
+		values:     make(map[string]*histogramValue),
+	}
+}
+
+// ExponentialBuckets returns count buckets, the first of which has an upper
+// bound of start and every following one factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Observe adds v to the histogram for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.labels.key(labelValues)
+	value, exists := h.values[key]
+	if !exists {
+		value = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = value
+	}
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, key := range h.labels.sortedKeys() {
+		value := h.values[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels.format(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labels.format(key, "le", "+Inf"), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labels.format(key), formatFloat(value.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labels.format(key), value.count)
+	}
+}
+
+// A GaugeFunc is a value which can go up and down, and is computed by a
+// function every time the metrics are read. The function returns the values
+// by the value of the gauge's only label.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	label      string
+	f          func() map[string]float64
+}
+
+func NewGaugeFunc(name, help, label string, f func() map[string]float64) *GaugeFunc {
+	return &GaugeFunc{name, help, label, f}
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := g.f()
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %s\n", g.metricName, formatLabel(g.label, key), formatFloat(values[key]))
+	}
+}
@@ -0,0 +1,90 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/osbuild/osbuild-composer/internal/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	counter := metrics.NewCounter("test_requests_total", "Number of requests.", "method")
+	counter.Inc("GET")
+	counter.Add(2, "POST")
+	counter.Inc("GET")
+
+	histogram := metrics.NewHistogram("test_duration_seconds", "Duration.", []float64{1, 10}, "kind")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(1, "a")
+	histogram.Observe(5, "a")
+	histogram.Observe(100, "a")
+
+	gauge := metrics.NewGaugeFunc("test_items", "Number of items.", "status", func() map[string]float64 {
+		return map[string]float64{"new": 3, "old \"stale\"": 1}
+	})
+
+	registry := metrics.NewRegistry()
+	registry.Register(counter, histogram, gauge)
+
+	var buf bytes.Buffer
+	err := registry.Write(&buf)
+	if err != nil {
+		t.Fatalf("cannot write metrics: %v", err)
+	}
+
+	expected := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{kind="a",le="1"} 2
+test_duration_seconds_bucket{kind="a",le="10"} 3
+test_duration_seconds_bucket{kind="a",le="+Inf"} 4
+test_duration_seconds_sum{kind="a"} 106.5
+test_duration_seconds_count{kind="a"} 4
+# HELP test_items Number of items.
+# TYPE test_items gauge
+test_items{status="new"} 3
+test_items{status="old \"stale\""} 1
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 2
+test_requests_total{method="POST"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Register(metrics.NewCounter("test_total", "Test."))
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a duplicate metric did not panic")
+		}
+	}()
+	registry.Register(metrics.NewCounter("test_total", "Test."))
+}
+
+func TestRouter(t *testing.T) {
+	router := metrics.NewRouter("test")
+	router.GET("/items/:id", func(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+		writer.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, id := range []string{"1", "2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/"+id, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	expected := `composer_http_requests_total{api="test",method="GET",route="/items/:id",code="418"} 2`
+	if !strings.Contains(recorder.Body.String(), expected+"\n") {
+		t.Errorf("expected metrics to contain %s, got:\n%s", expected, recorder.Body.String())
+	}
+}
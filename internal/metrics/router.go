@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var (
+	httpRequests = NewCounter(
+		"composer_http_requests_total",
+		"Number of HTTP requests by API, route and status code.",
+		"api", "method", "route", "code",
+	)
+	httpRequestDuration = NewHistogram(
+		"composer_http_request_duration_seconds",
+		"Time taken to handle HTTP requests by API and route.",
+		ExponentialBuckets(0.001, 4, 8),
+		"api", "method", "route",
+	)
+)
+
+func init() {
+	Register(httpRequests, httpRequestDuration)
+}
+
+// A Router is an httprouter.Router which records the number and duration of
+// the requests to each of its routes. Routes are labeled by the path they
+// were registered with, so that requests for different objects are counted
+// together.
+type Router struct {
+	*httprouter.Router
+	api string
+}
+
+// NewRouter returns a router whose metrics are labeled with api.
+func NewRouter(api string) *Router {
+	return &Router{httprouter.New(), api}
+}
+
+func (r *Router) Handle(method, path string, handle httprouter.Handle) {
+	r.Router.Handle(method, path, r.instrument(method, path, handle))
+}
+
+func (r *Router) GET(path string, handle httprouter.Handle) {
+	r.Handle("GET", path, handle)
+}
+
+func (r *Router) HEAD(path string, handle httprouter.Handle) {
+	r.Handle("HEAD", path, handle)
+}
+
+func (r *Router) POST(path string, handle httprouter.Handle) {
+	r.Handle("POST", path, handle)
+}
+
+func (r *Router) PUT(path string, handle httprouter.Handle) {
+	r.Handle("PUT", path, handle)
+}
+
+func (r *Router) PATCH(path string, handle httprouter.Handle) {
+	r.Handle("PATCH", path, handle)
+}
+
+func (r *Router) DELETE(path string, handle httprouter.Handle) {
+	r.Handle("DELETE", path, handle)
+}
+
+func (r *Router) instrument(method, path string, handle httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		start := time.Now()
+		recorder := &statusRecorder{writer, http.StatusOK}
+
+		handle(recorder, request, params)
+
+		httpRequests.Inc(r.api, method, path, strconv.Itoa(recorder.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.api, method, path)
+	}
+}
+
+// statusRecorder remembers the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush allows handlers to stream responses through the recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
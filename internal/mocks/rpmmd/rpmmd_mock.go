@@ -1,6 +1,9 @@
 package rpmmd_mock
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
 )
@@ -32,6 +35,22 @@ func (r *rpmmdMock) FetchPackageList(repos []rpmmd.RepoConfig) (rpmmd.PackageLis
 	return r.Fixture.fetchPackageList.ret, r.Fixture.fetchPackageList.err
 }
 
+func (r *rpmmdMock) FetchChecksums(repos []rpmmd.RepoConfig) (map[string]string, error) {
+	// dnf-json fetches checksums together with package lists
+	if r.Fixture.fetchPackageList.err != nil {
+		return nil, r.Fixture.fetchPackageList.err
+	}
+	checksums := make(map[string]string, len(repos))
+	for _, repo := range repos {
+		sum := sha256.Sum256([]byte(repo.Id))
+		checksums[repo.Id] = hex.EncodeToString(sum[:])
+	}
+	return checksums, nil
+}
+
 func (r *rpmmdMock) Depsolve(specs []string, repos []rpmmd.RepoConfig) ([]rpmmd.PackageSpec, error) {
 	return r.Fixture.depsolve.ret, r.Fixture.depsolve.err
 }
+
+func (r *rpmmdMock) Refresh() {
+}
@@ -1,5 +1,13 @@
 package pipeline
 
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"strings"
+)
+
 // The ScriptStageOptions specifies a custom script to run in the image
 type ScriptStageOptions struct {
 	Script string `json:"script"`
@@ -22,3 +30,71 @@ func NewScriptStage(options *ScriptStageOptions) *Stage {
 		Options: options,
 	}
 }
+
+// A ScriptDirectory is a directory created by the script of
+// NewFilesScriptStageOptions, together with its parents. Empty fields keep
+// their defaults.
+type ScriptDirectory struct {
+	Path  string
+	Mode  string
+	Owner string
+	Group string
+}
+
+// A ScriptFile is a file created by the script of
+// NewFilesScriptStageOptions. Its parent directories are created if they do
+// not exist.
+type ScriptFile struct {
+	Path  string
+	Mode  string
+	Owner string
+	Group string
+	Data  []byte
+}
+
+// NewFilesScriptStageOptions creates the options of a script stage which
+// creates directories and files in the image, directories first. osbuild
+// has no stage for files, so this is done by a shell script, which runs
+// in the image and can thus use the users and groups defined there.
+func NewFilesScriptStageOptions(directories []ScriptDirectory, files []ScriptFile) *ScriptStageOptions {
+	var script bytes.Buffer
+	script.WriteString("#!/bin/sh\nset -e\n")
+
+	for _, d := range directories {
+		fmt.Fprintf(&script, "mkdir -p %s\n", shellQuote(d.Path))
+		writeAttributes(&script, d.Path, d.Mode, d.Owner, d.Group)
+	}
+
+	for _, f := range files {
+		fmt.Fprintf(&script, "mkdir -p %s\n", shellQuote(path.Dir(f.Path)))
+		// base64 never contains the delimiter or quotes
+		fmt.Fprintf(&script, "base64 -d > %s <<'EOF'\n", shellQuote(f.Path))
+		encoded := base64.StdEncoding.EncodeToString(f.Data)
+		for len(encoded) > 76 {
+			script.WriteString(encoded[:76] + "\n")
+			encoded = encoded[76:]
+		}
+		script.WriteString(encoded + "\nEOF\n")
+		writeAttributes(&script, f.Path, f.Mode, f.Owner, f.Group)
+	}
+
+	return NewScriptStageOptions(script.String())
+}
+
+func writeAttributes(script *bytes.Buffer, path, mode, owner, group string) {
+	if mode != "" {
+		fmt.Fprintf(script, "chmod %s %s\n", shellQuote(mode), shellQuote(path))
+	}
+	// "owner:" would also change the group, to the owner's login group
+	if group != "" {
+		owner += ":" + group
+	}
+	if owner != "" {
+		fmt.Fprintf(script, "chown %s %s\n", shellQuote(owner), shellQuote(path))
+	}
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,193 @@
+package retention
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrObjectStoreBusy is returned by CollectObjectStore when a build is using
+// the object store.
+var ErrObjectStoreBusy = errors.New("the object store is in use")
+
+// An ObjectStoreLock keeps CollectObjectStore from removing objects while
+// osbuild uses them. The object store may be shared by all workers on a
+// host, so this is a lock on a file in the store.
+type ObjectStoreLock struct {
+	file *os.File
+}
+
+// LockObjectStore takes a shared lock on the object store in dir, waiting
+// for a running CollectObjectStore to finish. Any number of builds can hold
+// the lock at the same time.
+func LockObjectStore(dir string) (*ObjectStoreLock, error) {
+	return lockObjectStore(dir, syscall.LOCK_SH)
+}
+
+func lockObjectStore(dir string, how int) (*ObjectStoreLock, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &ObjectStoreLock{file}, nil
+}
+
+// Unlock releases the lock.
+func (l *ObjectStoreLock) Unlock() {
+	// closing the file releases the lock
+	l.file.Close()
+}
+
+// usedDir is the directory in the object store which holds an empty file
+// for each ref, whose modification time is when a build last used it.
+const usedDir = ".used"
+
+// MarkObjectsUsed records that a build used the refs with the given tree or
+// output IDs, so that CollectObjectStore keeps them. The caller must hold a
+// lock from LockObjectStore.
+func MarkObjectsUsed(dir string, ids []string, now time.Time) error {
+	err := os.MkdirAll(filepath.Join(dir, usedDir), 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = touch(filepath.Join(dir, usedDir, filepath.Base(id)), now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func touch(path string, now time.Time) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Chtimes(path, now, now)
+}
+
+// CollectObjectStore removes unused entries from an osbuild object store.
+//
+// osbuild keeps the trees and images it builds in `objects/` and points to
+// them from symlinks in `refs/`, named after the tree or output ID. Refs
+// which no build used for longer than maxUnused are removed, unless
+// maxUnused is zero. Refs which were never marked with MarkObjectsUsed count
+// as used when they are first seen. Then, all objects which no ref points
+// to are removed, which includes those left behind by builds which were
+// interrupted.
+//
+// It needs an exclusive lock on the store, and returns ErrObjectStoreBusy
+// instead of waiting if any build holds a lock from LockObjectStore.
+// Otherwise, it returns the number of removed objects.
+func CollectObjectStore(dir string, maxUnused time.Duration, now time.Time) (int, error) {
+	lock, err := lockObjectStore(dir, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return 0, ErrObjectStoreBusy
+	} else if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	refsDir := filepath.Join(dir, "refs")
+	objectsDir := filepath.Join(dir, "objects")
+
+	refs, err := ioutil.ReadDir(refsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, usedDir), 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := make(map[string]bool)
+	existing := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		refPath := filepath.Join(refsDir, ref.Name())
+		usedPath := filepath.Join(dir, usedDir, ref.Name())
+
+		used, err := os.Stat(usedPath)
+		if os.IsNotExist(err) {
+			err = touch(usedPath, now)
+		} else if err == nil && maxUnused > 0 && now.Sub(used.ModTime()) > maxUnused {
+			err = os.Remove(refPath)
+			if err == nil {
+				err = os.Remove(usedPath)
+			}
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		existing[ref.Name()] = true
+
+		target, err := os.Readlink(refPath)
+		if err != nil {
+			return 0, err
+		}
+		referenced[filepath.Base(target)] = true
+	}
+
+	// forget about refs which osbuild removed
+	marks, err := ioutil.ReadDir(filepath.Join(dir, usedDir))
+	if err != nil {
+		return 0, err
+	}
+	for _, mark := range marks {
+		if !existing[mark.Name()] {
+			err = os.Remove(filepath.Join(dir, usedDir, mark.Name()))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	objects, err := ioutil.ReadDir(objectsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	removed := 0
+	for _, object := range objects {
+		if referenced[object.Name()] {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(objectsDir, object.Name()))
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
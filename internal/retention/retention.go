@@ -0,0 +1,213 @@
+// Package retention removes old composes and their outputs, and unused
+// objects from the osbuild object store.
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+// A Policy describes which finished composes are kept. Composes which
+// have not finished yet are never removed. A zero value disables the
+// corresponding limit.
+type Policy struct {
+	// Finished composes older than this are removed.
+	MaxAge time.Duration
+	// Only this many of the most recent composes of each blueprint are
+	// kept.
+	MaxCountPerBlueprint int
+	// The oldest composes are removed until their outputs take up less
+	// than this many bytes: the first compose, counting from the newest
+	// one, which does not fit is removed together with all older ones.
+	MaxDiskUsage int64
+}
+
+// entry is a finished compose considered for removal.
+type entry struct {
+	ID       uuid.UUID
+	Compose  store.Compose
+	DiskSize int64
+}
+
+// Expired returns the IDs of the finished composes violating the policy,
+// oldest first. sizes contains the disk usage of each compose's outputs.
+func (p Policy) Expired(composes map[uuid.UUID]store.Compose, sizes map[uuid.UUID]int64, now time.Time) []uuid.UUID {
+	var entries []entry
+	for id, compose := range composes {
+		if compose.QueueStatus != "FINISHED" && compose.QueueStatus != "FAILED" {
+			continue
+		}
+		entries = append(entries, entry{id, compose, sizes[id]})
+	}
+
+	// newest first
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Compose.JobFinished.Equal(entries[j].Compose.JobFinished) {
+			return entries[i].ID.String() < entries[j].ID.String()
+		}
+		return entries[i].Compose.JobFinished.After(entries[j].Compose.JobFinished)
+	})
+
+	expired := make(map[uuid.UUID]bool)
+	perBlueprint := make(map[string]int)
+	var diskUsage int64
+	diskFull := false
+
+	for _, e := range entries {
+		name := ""
+		if e.Compose.Blueprint != nil {
+			name = e.Compose.Blueprint.Name
+		}
+		perBlueprint[name]++
+
+		switch {
+		case p.MaxAge > 0 && now.Sub(e.Compose.JobFinished) > p.MaxAge:
+			expired[e.ID] = true
+		case p.MaxCountPerBlueprint > 0 && perBlueprint[name] > p.MaxCountPerBlueprint:
+			expired[e.ID] = true
+		case diskFull || p.MaxDiskUsage > 0 && diskUsage+e.DiskSize > p.MaxDiskUsage:
+			diskFull = true
+			expired[e.ID] = true
+		default:
+			diskUsage += e.DiskSize
+		}
+	}
+
+	var ids []uuid.UUID
+	for i := len(entries) - 1; i >= 0; i-- {
+		if expired[entries[i].ID] {
+			ids = append(ids, entries[i].ID)
+		}
+	}
+
+	return ids
+}
+
+// A Reaper periodically removes the composes which violate its policy
+// from the store, together with their local outputs.
+type Reaper struct {
+	store *store.Store
+
+	mu     sync.Mutex
+	policy Policy
+}
+
+// NewReaper creates a reaper for the composes in store.
+func NewReaper(store *store.Store, policy Policy) *Reaper {
+	return &Reaper{
+		store:  store,
+		policy: policy,
+	}
+}
+
+// SetPolicy replaces the policy used by subsequent runs.
+func (r *Reaper) SetPolicy(policy Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = policy
+}
+
+// Policy returns the current policy.
+func (r *Reaper) Policy() Policy {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.policy
+}
+
+// Reap removes all composes violating the policy and returns their IDs.
+func (r *Reaper) Reap() []uuid.UUID {
+	composes := r.store.GetAllComposes()
+
+	sizes := make(map[uuid.UUID]int64)
+	for id, compose := range composes {
+		for _, location := range localOutputs(compose) {
+			size, err := diskUsage(location)
+			if err != nil && !os.IsNotExist(err) {
+				logging.Default().With("compose_id", id).Warningf("retention: cannot determine size of %s: %v", location, err)
+			}
+			sizes[id] += size
+		}
+	}
+
+	var removed []uuid.UUID
+	for _, id := range r.Policy().Expired(composes, sizes, time.Now()) {
+		compose, err := r.store.DeleteCompose(id)
+		if err != nil {
+			logging.Default().With("compose_id", id).Errorf("retention: cannot delete compose: %v", err)
+			continue
+		}
+
+		// this includes artifacts whose upload never finished
+		for _, location := range localOutputs(compose) {
+			err = os.RemoveAll(location)
+			if err != nil {
+				logging.Default().With("compose_id", id).Errorf("retention: cannot remove outputs: %v", err)
+			}
+		}
+
+		logging.Default().With("compose_id", id).Infof("retention: removed compose")
+		removed = append(removed, id)
+	}
+
+	return removed
+}
+
+// Run starts calling Reap every interval until stop is closed. The returned
+// channel is closed once it stopped, which waits for a running Reap to
+// finish.
+func (r *Reaper) Run(interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if removed := r.Reap(); len(removed) > 0 {
+					logging.Default().Infof("retention: removed %d composes", len(removed))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+func localOutputs(compose store.Compose) []string {
+	var locations []string
+	for _, t := range compose.Targets {
+		if options, ok := t.Options.(*target.LocalTargetOptions); ok && options.Location != "" {
+			locations = append(locations, options.Location)
+		}
+	}
+	return locations
+}
+
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,267 @@
+package retention_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/retention"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+)
+
+var now = time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+
+func compose(name, status string, age time.Duration) store.Compose {
+	return store.Compose{
+		QueueStatus: status,
+		Blueprint:   &blueprint.Blueprint{Name: name},
+		OutputType:  "tar",
+		JobFinished: now.Add(-age),
+	}
+}
+
+func TestExpired(t *testing.T) {
+	ids := []uuid.UUID{
+		uuid.MustParse("00000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000002"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000003"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000004"),
+	}
+
+	composes := map[uuid.UUID]store.Compose{
+		ids[0]: compose("a", "FINISHED", 30*24*time.Hour),
+		ids[1]: compose("a", "FAILED", 3*24*time.Hour),
+		ids[2]: compose("a", "FINISHED", 2*24*time.Hour),
+		ids[3]: compose("b", "FINISHED", 1*24*time.Hour),
+		ids[4]: compose("a", "RUNNING", 100*24*time.Hour),
+	}
+
+	sizes := map[uuid.UUID]int64{
+		ids[0]: 100,
+		ids[1]: 0,
+		ids[2]: 100,
+		ids[3]: 100,
+		ids[4]: 100,
+	}
+
+	cases := []struct {
+		Policy   retention.Policy
+		Expected []uuid.UUID
+	}{
+		{retention.Policy{}, nil},
+		{retention.Policy{MaxAge: 7 * 24 * time.Hour}, []uuid.UUID{ids[0]}},
+		{retention.Policy{MaxCountPerBlueprint: 1}, []uuid.UUID{ids[0], ids[1]}},
+		{retention.Policy{MaxDiskUsage: 250}, []uuid.UUID{ids[0]}},
+		{retention.Policy{MaxDiskUsage: 150}, []uuid.UUID{ids[0], ids[1], ids[2]}},
+		{retention.Policy{MaxAge: 36 * time.Hour, MaxCountPerBlueprint: 1}, []uuid.UUID{ids[0], ids[1], ids[2]}},
+	}
+
+	for _, c := range cases {
+		got := c.Policy.Expired(composes, sizes, now)
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("%+v: expected %v, got %v", c.Policy, c.Expected, got)
+		}
+	}
+}
+
+func TestExpiredDiskUsage(t *testing.T) {
+	ids := []uuid.UUID{
+		uuid.MustParse("00000000-0000-0000-0000-000000000000"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000002"),
+		uuid.MustParse("00000000-0000-0000-0000-000000000003"),
+	}
+
+	// newest first
+	composes := map[uuid.UUID]store.Compose{
+		ids[0]: compose("a", "FINISHED", 1*time.Hour),
+		ids[1]: compose("a", "FINISHED", 2*time.Hour),
+		ids[2]: compose("a", "FINISHED", 3*time.Hour),
+		ids[3]: compose("a", "FINISHED", 4*time.Hour),
+	}
+	sizes := map[uuid.UUID]int64{
+		ids[0]: 100,
+		ids[1]: 300,
+		ids[2]: 10,
+		ids[3]: 10,
+	}
+
+	cases := []struct {
+		MaxDiskUsage int64
+		Expected     []uuid.UUID
+	}{
+		{1000, nil},
+		{415, []uuid.UUID{ids[3]}},
+		// smaller, older composes are not kept instead of a larger one
+		{150, []uuid.UUID{ids[3], ids[2], ids[1]}},
+		{50, []uuid.UUID{ids[3], ids[2], ids[1], ids[0]}},
+	}
+
+	for _, c := range cases {
+		got := retention.Policy{MaxDiskUsage: c.MaxDiskUsage}.Expired(composes, sizes, now)
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("%d: expected %v, got %v", c.MaxDiskUsage, c.Expected, got)
+		}
+	}
+}
+
+func TestReap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := store.New(nil, distro.New("fedora-30"))
+
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	statuses := []string{"FINISHED", "FINISHED", "RUNNING"}
+	for i, id := range ids {
+		location := filepath.Join(dir, id.String())
+		err = os.MkdirAll(location, 0755)
+		if err != nil {
+			t.Fatalf("cannot create output directory: %v", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(location, "image.qcow2"), make([]byte, 10), 0644)
+		if err != nil {
+			t.Fatalf("cannot create image: %v", err)
+		}
+
+		c := compose("test", statuses[i], time.Duration(len(ids)-i)*time.Hour)
+		c.JobFinished = time.Now().Add(-time.Duration(len(ids)-i) * time.Hour)
+		c.Targets = []*target.Target{target.NewLocalTarget(&target.LocalTargetOptions{Location: location})}
+		s.Composes[id] = c
+	}
+
+	reaper := retention.NewReaper(s, retention.Policy{MaxDiskUsage: 15})
+	removed := reaper.Reap()
+
+	if !reflect.DeepEqual(removed, []uuid.UUID{ids[0]}) {
+		t.Fatalf("expected to remove %v, removed %v", ids[0], removed)
+	}
+
+	if _, exists := s.GetCompose(ids[0]); exists {
+		t.Errorf("removed compose is still in the store")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ids[0].String())); !os.IsNotExist(err) {
+		t.Errorf("outputs of removed compose still exist")
+	}
+	for _, id := range ids[1:] {
+		if _, exists := s.GetCompose(id); !exists {
+			t.Errorf("compose %s was removed", id)
+		}
+		if _, err := os.Stat(filepath.Join(dir, id.String())); err != nil {
+			t.Errorf("outputs of compose %s were removed: %v", id, err)
+		}
+	}
+}
+
+func TestCollectObjectStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, object := range []string{"used", "unused"} {
+		err = os.MkdirAll(filepath.Join(dir, "objects", object), 0755)
+		if err != nil {
+			t.Fatalf("cannot create object: %v", err)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, "refs"), 0755)
+	if err != nil {
+		t.Fatalf("cannot create refs: %v", err)
+	}
+	err = os.Symlink("../objects/used", filepath.Join(dir, "refs", "ref"))
+	if err != nil {
+		t.Fatalf("cannot create ref: %v", err)
+	}
+
+	// a running build keeps everything in place
+	lock, err := retention.LockObjectStore(dir)
+	if err != nil {
+		t.Fatalf("cannot lock object store: %v", err)
+	}
+	if _, err := retention.CollectObjectStore(dir, 0, time.Now()); err != retention.ErrObjectStoreBusy {
+		t.Errorf("expected ErrObjectStoreBusy, got %v", err)
+	}
+	lock.Unlock()
+
+	removed, err := retention.CollectObjectStore(dir, 0, time.Now())
+	if err != nil {
+		t.Fatalf("error collecting object store: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 removed object, got %d", removed)
+	}
+
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "objects"))
+	if len(entries) != 1 || entries[0].Name() != "used" {
+		t.Errorf("unexpected objects left: %v", entries)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "refs", "ref")); err != nil {
+		t.Errorf("ref was removed: %v", err)
+	}
+}
+
+func TestCollectObjectStoreUnused(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osbuild-composer-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"recent", "old"} {
+		err = os.MkdirAll(filepath.Join(dir, "objects", name), 0755)
+		if err != nil {
+			t.Fatalf("cannot create object: %v", err)
+		}
+		err = os.MkdirAll(filepath.Join(dir, "refs"), 0755)
+		if err != nil {
+			t.Fatalf("cannot create refs: %v", err)
+		}
+		err = os.Symlink("../objects/"+name, filepath.Join(dir, "refs", name))
+		if err != nil {
+			t.Fatalf("cannot create ref: %v", err)
+		}
+	}
+
+	err = retention.MarkObjectsUsed(dir, []string{"old"}, now.Add(-48*time.Hour))
+	if err != nil {
+		t.Fatalf("cannot mark objects as used: %v", err)
+	}
+	err = retention.MarkObjectsUsed(dir, []string{"recent"}, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("cannot mark objects as used: %v", err)
+	}
+
+	removed, err := retention.CollectObjectStore(dir, 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("error collecting object store: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 removed object, got %d", removed)
+	}
+
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "objects"))
+	if len(entries) != 1 || entries[0].Name() != "recent" {
+		t.Errorf("unexpected objects left: %v", entries)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "refs", "old")); !os.IsNotExist(err) {
+		t.Errorf("unused ref was not removed: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "refs", "recent")); err != nil {
+		t.Errorf("recently used ref was removed: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package rpmmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// dnfCall is a call of a dnf-json command.
+type dnfCall struct {
+	Command   string      `json:"command"`
+	Arguments interface{} `json:"arguments,omitempty"`
+}
+
+// A dnfProcess is a dnf-json process which serves calls one after another,
+// so that python and dnf are only loaded once.
+type dnfProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	// set when the process cannot be used anymore
+	broken bool
+}
+
+func startDNF(command []string) (*dnfProcess, error) {
+	cmd := exec.Command(command[0], append(command[1:], "--serve")...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &dnfProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// call runs command and decodes its result into result. It returns a
+// *DNFError if dnf failed. All other errors break the process.
+func (p *dnfProcess) call(command string, arguments interface{}, result interface{}) error {
+	err := json.NewEncoder(p.stdin).Encode(dnfCall{command, arguments})
+	if err != nil {
+		p.close()
+		return fmt.Errorf("cannot send call to dnf-json: %v", err)
+	}
+
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		p.close()
+		return fmt.Errorf("cannot read reply of dnf-json: %v", err)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *DNFError       `json:"error"`
+	}
+	err = json.Unmarshal(line, &response)
+	if err != nil {
+		p.close()
+		return fmt.Errorf("invalid reply of dnf-json: %v", err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// close stops the process, which exits once its input is closed.
+func (p *dnfProcess) close() {
+	p.broken = true
+	p.stdin.Close()
+	p.cmd.Wait()
+}
+
+// A dnfPool runs calls in a limited number of dnf-json processes, which are
+// started when they are first needed and restarted when they break.
+type dnfPool struct {
+	command []string
+	// idle processes; nil for processes which are not running
+	processes chan *dnfProcess
+}
+
+func newDNFPool(command []string, size int) *dnfPool {
+	pool := &dnfPool{
+		command:   command,
+		processes: make(chan *dnfProcess, size),
+	}
+	for i := 0; i < size; i++ {
+		pool.processes <- nil
+	}
+	return pool
+}
+
+// call runs command in the next idle process, waiting for one if all of them
+// are busy.
+func (pool *dnfPool) call(command string, arguments interface{}, result interface{}) error {
+	p := <-pool.processes
+	defer func() {
+		if p != nil && p.broken {
+			p = nil
+		}
+		pool.processes <- p
+	}()
+
+	if p == nil {
+		var err error
+		p, err = startDNF(pool.command)
+		if err != nil {
+			return fmt.Errorf("cannot start dnf-json: %v", err)
+		}
+	}
+
+	return p.call(command, arguments, result)
+}
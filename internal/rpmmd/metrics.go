@@ -0,0 +1,26 @@
+package rpmmd
+
+import (
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/metrics"
+)
+
+var dnfDuration = metrics.NewHistogram(
+	"composer_dnf_duration_seconds",
+	"Time taken by dnf to fetch package lists and depsolve, by command and result.",
+	metrics.ExponentialBuckets(0.1, 2, 10),
+	"command", "result",
+)
+
+func init() {
+	metrics.Register(dnfDuration)
+}
+
+func observeDNF(command string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	dnfDuration.Observe(time.Since(start).Seconds(), command, result)
+}
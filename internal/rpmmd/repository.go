@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/gobwas/glob"
@@ -20,6 +21,41 @@ type RepoConfig struct {
 	MirrorList string `json:"mirrorlist,omitempty"`
 	Checksum   string `json:"checksum,omitempty"`
 	GPGKey     string `json:"gpgkey,omitempty"`
+
+	// Verify the signatures of packages, with GPGKey or the keys at
+	// GPGKeyURLs.
+	CheckGPG   bool     `json:"check_gpg,omitempty"`
+	GPGKeyURLs []string `json:"gpgkey_urls,omitempty"`
+
+	// Do not verify the certificate of the server with the system's CAs.
+	IgnoreSSL bool `json:"ignore_ssl,omitempty"`
+
+	// URL of the proxy to download from the repository through.
+	Proxy string `json:"proxy,omitempty"`
+
+	// PEM encoded CA certificate to verify the server with, and client
+	// certificate and key to authenticate to it with.
+	SSLCACert     string `json:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty"`
+}
+
+// CheckBuildOptions returns an error if packages of repo cannot be installed
+// into images with all of its options. osbuild's dnf stage only takes the
+// URL of a repository, the GPG key to verify its packages with and the
+// checksum of its metadata.
+func (repo *RepoConfig) CheckBuildOptions() error {
+	switch {
+	case repo.CheckGPG && repo.GPGKey == "":
+		return fmt.Errorf("repository %s: packages can only be verified with a gpgkey in image builds", repo.Id)
+	case repo.IgnoreSSL:
+		return fmt.Errorf("repository %s: ssl verification cannot be disabled in image builds", repo.Id)
+	case repo.Proxy != "":
+		return fmt.Errorf("repository %s: proxies are not supported in image builds", repo.Id)
+	case repo.SSLCACert != "" || repo.SSLClientCert != "" || repo.SSLClientKey != "":
+		return fmt.Errorf("repository %s: ssl certificates are not supported in image builds", repo.Id)
+	}
+	return nil
 }
 
 type PackageList []Package
@@ -94,7 +130,13 @@ type PackageInfo struct {
 
 type RPMMD interface {
 	FetchPackageList(repos []RepoConfig) (PackageList, error)
+	// FetchChecksums returns the sha256 checksums of the metadata of
+	// repos, keyed by their ids. They are cached like package lists.
+	FetchChecksums(repos []RepoConfig) (map[string]string, error)
 	Depsolve(specs []string, repos []RepoConfig) ([]PackageSpec, error)
+	// Refresh makes the next call of FetchPackageList check whether the
+	// metadata of any cached repository changed.
+	Refresh()
 }
 
 type DNFError struct {
@@ -115,7 +157,7 @@ func runDNF(command string, arguments interface{}, result interface{}) error {
 		arguments,
 	}
 
-	cmd := exec.Command("python3", "dnf-json")
+	cmd := exec.Command(defaultDNFCommand[0], defaultDNFCommand[1:]...)
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -166,34 +208,265 @@ func runDNF(command string, arguments interface{}, result interface{}) error {
 	return nil
 }
 
-type rpmmdImpl struct{}
+var defaultDNFCommand = []string{"python3", "dnf-json"}
+
+// Options contains the settings of an RPMMD. A zero value selects the
+// default.
+type Options struct {
+	// How long the package list of a repository is used before checking
+	// whether its metadata changed. Defaults to an hour.
+	RefreshInterval time.Duration
+	// How long the package list of a repository is kept after it was last
+	// used. Defaults to a day.
+	CacheExpiry time.Duration
+	// Number of dnf-json processes, which limits how many calls run at
+	// the same time. Defaults to one.
+	Processes int
+	// Command running dnf-json. Defaults to "python3 dnf-json".
+	Command []string
+}
+
+// A cachedRepo is the package list of a repository.
+type cachedRepo struct {
+	// checksum of the repository's metadata
+	checksum string
+	// when the checksum was last compared to the repository's
+	checked time.Time
+	// when the package list was last requested
+	used time.Time
+	// sorted by name
+	packages PackageList
+}
+
+type rpmmdImpl struct {
+	dnf             *dnfPool
+	refreshInterval time.Duration
+	cacheExpiry     time.Duration
+
+	mu    sync.Mutex             // protects repos
+	repos map[string]*cachedRepo // keyed by repoKey
+}
 
 func NewRPMMD() RPMMD {
-	return &rpmmdImpl{}
+	return NewRPMMDWithOptions(Options{})
 }
 
-func (*rpmmdImpl) FetchPackageList(repos []RepoConfig) (PackageList, error) {
-	var arguments = struct {
-		Repos []RepoConfig `json:"repos"`
-	}{repos}
+// NewRPMMDWithOptions returns an RPMMD which calls dnf-json in long-running
+// processes, and caches the package list of each repository until its
+// metadata changes.
+func NewRPMMDWithOptions(options Options) RPMMD {
+	if options.RefreshInterval == 0 {
+		options.RefreshInterval = time.Hour
+	}
+	if options.CacheExpiry == 0 {
+		options.CacheExpiry = 24 * time.Hour
+	}
+	if options.Processes == 0 {
+		options.Processes = 1
+	}
+	if options.Command == nil {
+		options.Command = defaultDNFCommand
+	}
+
+	return &rpmmdImpl{
+		dnf:             newDNFPool(options.Command, options.Processes),
+		refreshInterval: options.RefreshInterval,
+		cacheExpiry:     options.CacheExpiry,
+		repos:           make(map[string]*cachedRepo),
+	}
+}
+
+// repoKey identifies the package list of repo in the cache. Repositories
+// with the same id but different settings are cached separately.
+func repoKey(repo RepoConfig) string {
+	key, _ := json.Marshal(repo)
+	return string(key)
+}
+
+func (r *rpmmdImpl) FetchPackageList(repos []RepoConfig) (PackageList, error) {
+	entries, err := r.cachedRepos(repos)
+	if err != nil {
+		return nil, err
+	}
+
 	var packages PackageList
-	err := runDNF("dump", arguments, &packages)
-	sort.Slice(packages, func(i, j int) bool {
+	for _, repo := range repos {
+		packages = append(packages, entries[repoKey(repo)].packages...)
+	}
+	sort.SliceStable(packages, func(i, j int) bool {
 		return packages[i].Name < packages[j].Name
 	})
-	return packages, err
+	return packages, nil
 }
 
-func (*rpmmdImpl) Depsolve(specs []string, repos []RepoConfig) ([]PackageSpec, error) {
+func (r *rpmmdImpl) FetchChecksums(repos []RepoConfig) (map[string]string, error) {
+	entries, err := r.cachedRepos(repos)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := make(map[string]string, len(repos))
+	for _, repo := range repos {
+		checksums[repo.Id] = entries[repoKey(repo)].checksum
+	}
+	return checksums, nil
+}
+
+// cachedRepos returns the cache entries of repos, keyed by repoKey, and
+// fetches those which are missing or were not checked for a while.
+func (r *rpmmdImpl) cachedRepos(repos []RepoConfig) (map[string]*cachedRepo, error) {
+	// dnf-json refers to repositories by their id
+	ids := make(map[string]bool)
+	for _, repo := range repos {
+		if ids[repo.Id] {
+			return nil, fmt.Errorf("repository id %s is used more than once", repo.Id)
+		}
+		ids[repo.Id] = true
+	}
+
+	var stale []RepoConfig
+	known := make(map[string]*cachedRepo)
+	entries := make(map[string]*cachedRepo)
+	requested := make(map[string]bool)
+
+	r.mu.Lock()
+	now := time.Now()
+	for _, repo := range repos {
+		key := repoKey(repo)
+		requested[key] = true
+		cached := r.repos[key]
+		if cached == nil {
+			stale = append(stale, repo)
+			continue
+		}
+		cached.used = now
+		if now.Sub(cached.checked) >= r.refreshInterval {
+			stale = append(stale, repo)
+			known[key] = cached
+		} else {
+			entries[key] = cached
+		}
+	}
+	// forget repositories which are not configured anymore
+	for key, cached := range r.repos {
+		if !requested[key] && now.Sub(cached.used) >= r.cacheExpiry {
+			delete(r.repos, key)
+		}
+	}
+	r.mu.Unlock()
+
+	if len(stale) > 0 {
+		fetched, err := r.fetchRepos(stale, known)
+		if err != nil {
+			return nil, err
+		}
+		for key, cached := range fetched {
+			entries[key] = cached
+		}
+	}
+
+	return entries, nil
+}
+
+// fetchRepos updates the cached package lists of repos and returns them,
+// keyed by repoKey. Known contains the cached package lists of some of repos,
+// which are only fetched again if the checksum of their metadata changed.
+func (r *rpmmdImpl) fetchRepos(repos []RepoConfig, known map[string]*cachedRepo) (map[string]*cachedRepo, error) {
+	knownIds := make(map[string]string)
+	for _, repo := range repos {
+		if cached, ok := known[repoKey(repo)]; ok {
+			knownIds[repo.Id] = cached.checksum
+		}
+	}
+
+	var arguments = struct {
+		Repos []RepoConfig      `json:"repos"`
+		Known map[string]string `json:"known"`
+	}{repos, knownIds}
+	var result map[string]struct {
+		Checksum  string      `json:"checksum"`
+		Unchanged bool        `json:"unchanged"`
+		Packages  PackageList `json:"packages"`
+	}
+	start := time.Now()
+	err := r.dnf.call("dump", arguments, &result)
+	observeDNF("dump", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fetched := make(map[string]*cachedRepo)
+	for _, repo := range repos {
+		dumped, ok := result[repo.Id]
+		if !ok {
+			return nil, fmt.Errorf("dnf-json did not return the packages of repository %s", repo.Id)
+		}
+
+		key := repoKey(repo)
+		if dumped.Unchanged {
+			cached, ok := known[key]
+			if !ok {
+				return nil, fmt.Errorf("dnf-json returned no packages for repository %s", repo.Id)
+			}
+			cached.checked = start
+			cached.used = start
+			r.repos[key] = cached
+			fetched[key] = cached
+			continue
+		}
+
+		sort.SliceStable(dumped.Packages, func(i, j int) bool {
+			return dumped.Packages[i].Name < dumped.Packages[j].Name
+		})
+		cached := &cachedRepo{
+			checksum: dumped.Checksum,
+			checked:  start,
+			used:     start,
+			packages: dumped.Packages,
+		}
+		r.repos[key] = cached
+		fetched[key] = cached
+	}
+
+	return fetched, nil
+}
+
+func (r *rpmmdImpl) Depsolve(specs []string, repos []RepoConfig) ([]PackageSpec, error) {
 	var arguments = struct {
 		PackageSpecs []string     `json:"package-specs"`
 		Repos        []RepoConfig `json:"repos"`
 	}{specs, repos}
 	var dependencies []PackageSpec
-	err := runDNF("depsolve", arguments, &dependencies)
+	start := time.Now()
+	err := r.dnf.call("depsolve", arguments, &dependencies)
+	observeDNF("depsolve", start, err)
 	return dependencies, err
 }
 
+func (r *rpmmdImpl) Refresh() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, cached := range r.repos {
+		cached.checked = time.Time{}
+	}
+}
+
+// CheckDNF returns an error if dnf-json cannot be run. It depsolves an empty
+// set of packages without any repositories, which does not need network
+// access.
+func CheckDNF() error {
+	var arguments = struct {
+		PackageSpecs []string     `json:"package-specs"`
+		Repos        []RepoConfig `json:"repos"`
+	}{[]string{}, []RepoConfig{}}
+	var dependencies []PackageSpec
+	return runDNF("depsolve", arguments, &dependencies)
+}
+
 func (packages PackageList) Search(globPatterns ...string) (PackageList, error) {
 	var globs []glob.Glob
 
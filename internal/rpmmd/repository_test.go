@@ -0,0 +1,233 @@
+package rpmmd_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
+)
+
+// fakeDNF serves calls like dnf-json. The checksum of each repository is
+// read from a file named after it, and every call is logged together with
+// the id of the process which served it.
+const fakeDNF = `
+import json, os, sys
+
+dir = os.path.dirname(sys.argv[0])
+assert sys.argv[1] == "--serve"
+
+for line in sys.stdin:
+    call = json.loads(line)
+    arguments = call["arguments"]
+    with open(os.path.join(dir, "calls"), "a") as f:
+        f.write("%d %s %s\n" % (os.getpid(), call["command"], json.dumps(arguments.get("known"), sort_keys=True)))
+
+    if call["command"] == "dump":
+        result = {}
+        for repo in arguments["repos"]:
+            with open(os.path.join(dir, repo["id"])) as f:
+                checksum = f.read()
+            if arguments["known"].get(repo["id"]) == checksum:
+                result[repo["id"]] = {"checksum": checksum, "unchanged": True}
+            else:
+                result[repo["id"]] = {"checksum": checksum, "packages": [{"name": repo["id"] + "-" + checksum}]}
+        response = {"result": result}
+    elif arguments["package-specs"] == ["exit"]:
+        sys.exit(1)
+    elif arguments["package-specs"] == ["missing"]:
+        response = {"error": {"kind": "MarkingErrors", "reason": "no package matches missing"}}
+    else:
+        response = {"result": [{"name": spec} for spec in arguments["package-specs"]]}
+
+    print(json.dumps(response), flush=True)
+`
+
+// calls returns the logged calls of fakeDNF, without the process ids, and
+// the number of processes which served them.
+func calls(t *testing.T, dir string) ([]string, int) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("cannot read calls: %v", err)
+	}
+
+	var result []string
+	processes := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		processes[fields[0]] = true
+		result = append(result, fields[1])
+	}
+	return result, len(processes)
+}
+
+func names(packages rpmmd.PackageList) string {
+	var result []string
+	for _, pkg := range packages {
+		result = append(result, pkg.Name)
+	}
+	return strings.Join(result, " ")
+}
+
+func TestCache(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "rpmmd-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"fake-dnf-json": fakeDNF, "b": "1", "a": "1"}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+
+	rpm := rpmmd.NewRPMMDWithOptions(rpmmd.Options{
+		RefreshInterval: time.Hour,
+		Command:         []string{"python3", filepath.Join(dir, "fake-dnf-json")},
+	})
+	repos := []rpmmd.RepoConfig{{Id: "b"}, {Id: "a"}}
+
+	fetch := func(expected string) {
+		packages, err := rpm.FetchPackageList(repos)
+		if err != nil {
+			t.Fatalf("cannot fetch package list: %v", err)
+		}
+		if names(packages) != expected {
+			t.Errorf("expected packages %q, got %q", expected, names(packages))
+		}
+	}
+
+	fetch("a-1 b-1")
+	// cached
+	fetch("a-1 b-1")
+	// refreshed, but unchanged
+	rpm.Refresh()
+	fetch("a-1 b-1")
+
+	err = ioutil.WriteFile(filepath.Join(dir, "b"), []byte("2"), 0644)
+	if err != nil {
+		t.Fatalf("cannot change repository: %v", err)
+	}
+	// cached until refreshed
+	fetch("a-1 b-1")
+	rpm.Refresh()
+	fetch("a-1 b-2")
+
+	// from the cache
+	checksums, err := rpm.FetchChecksums(repos)
+	if err != nil {
+		t.Fatalf("cannot fetch checksums: %v", err)
+	}
+	if !reflect.DeepEqual(checksums, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("unexpected checksums: %v", checksums)
+	}
+
+	expected := []string{
+		`dump {}`,
+		`dump {"a": "1", "b": "1"}`,
+		`dump {"a": "1", "b": "1"}`,
+	}
+	actual, processes := calls(t, dir)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected calls:\n%s", strings.Join(actual, "\n"))
+	}
+	if processes != 1 {
+		t.Errorf("calls were served by %d processes", processes)
+	}
+
+	dependencies, err := rpm.Depsolve([]string{"tmux"}, repos)
+	if err != nil || len(dependencies) != 1 || dependencies[0].Name != "tmux" {
+		t.Errorf("unexpected dependencies %v: %v", dependencies, err)
+	}
+	_, err = rpm.Depsolve([]string{"missing"}, repos)
+	if dnfErr, ok := err.(*rpmmd.DNFError); !ok || dnfErr.Kind != "MarkingErrors" {
+		t.Errorf("expected a DNFError, got %v", err)
+	}
+
+	// a process which exits is restarted
+	_, err = rpm.Depsolve([]string{"exit"}, repos)
+	if err == nil {
+		t.Errorf("exited process did not return an error")
+	}
+	dependencies, err = rpm.Depsolve([]string{"tmux"}, repos)
+	if err != nil || len(dependencies) != 1 {
+		t.Errorf("unexpected dependencies after restart %v: %v", dependencies, err)
+	}
+	if _, processes := calls(t, dir); processes != 2 {
+		t.Errorf("calls were served by %d processes, expected 2", processes)
+	}
+}
+
+func TestCacheExpiry(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "rpmmd-test-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"fake-dnf-json": fakeDNF, "b": "1", "a": "1"}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+
+	rpm := rpmmd.NewRPMMDWithOptions(rpmmd.Options{
+		RefreshInterval: time.Hour,
+		CacheExpiry:     time.Nanosecond,
+		Command:         []string{"python3", filepath.Join(dir, "fake-dnf-json")},
+	})
+
+	fetch := func(repos []rpmmd.RepoConfig, expected string) {
+		packages, err := rpm.FetchPackageList(repos)
+		if err != nil {
+			t.Fatalf("cannot fetch package list: %v", err)
+		}
+		if names(packages) != expected {
+			t.Errorf("expected packages %q, got %q", expected, names(packages))
+		}
+	}
+
+	fetch([]rpmmd.RepoConfig{{Id: "a"}, {Id: "b"}}, "a-1 b-1")
+	// b is forgotten when it is not used
+	time.Sleep(time.Millisecond)
+	fetch([]rpmmd.RepoConfig{{Id: "a"}}, "a-1")
+	fetch([]rpmmd.RepoConfig{{Id: "b"}}, "b-1")
+	// a repository with different settings is cached separately
+	fetch([]rpmmd.RepoConfig{{Id: "b", BaseURL: "http://example.com/b"}}, "b-1")
+
+	expected := []string{
+		`dump {}`,
+		`dump {}`,
+		`dump {}`,
+	}
+	actual, _ := calls(t, dir)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected calls:\n%s", strings.Join(actual, "\n"))
+	}
+
+	_, err = rpm.FetchPackageList([]rpmmd.RepoConfig{{Id: "b"}, {Id: "b", BaseURL: "http://example.com/b"}})
+	if err == nil {
+		t.Errorf("repositories with the same id were accepted")
+	}
+}
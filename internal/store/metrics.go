@@ -0,0 +1,24 @@
+package store
+
+import (
+	"github.com/osbuild/osbuild-composer/internal/metrics"
+)
+
+var (
+	composeWaitDuration = metrics.NewHistogram(
+		"composer_compose_wait_seconds",
+		"Time composes spent waiting for a worker, by distro and output type.",
+		metrics.ExponentialBuckets(1, 4, 8),
+		"distro", "output_type",
+	)
+	composeBuildDuration = metrics.NewHistogram(
+		"composer_compose_build_seconds",
+		"Time workers took to build composes, by distro, output type and result.",
+		metrics.ExponentialBuckets(30, 2, 10),
+		"distro", "output_type", "status",
+	)
+)
+
+func init() {
+	metrics.Register(composeWaitDuration, composeBuildDuration)
+}
@@ -3,12 +3,13 @@
 package store
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -19,6 +20,8 @@ import (
 
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
 	"github.com/osbuild/osbuild-composer/internal/pipeline"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/target"
@@ -34,11 +37,33 @@ type Store struct {
 	Composes          map[uuid.UUID]Compose                  `json:"composes"`
 	Sources           map[string]SourceConfig                `json:"sources"`
 	BlueprintsChanges map[string]map[string]blueprint.Change `json:"changes"`
+	// Commit of the committed version of each blueprint
+	NewestCommits map[string]string `json:"newest_commits,omitempty"`
+	// Blueprint saved in each change, by name and commit, which the
+	// changes themselves do not marshal
+	ChangeBlueprints map[string]map[string]blueprint.Blueprint `json:"change_blueprints,omitempty"`
 
 	mu           sync.RWMutex // protects all fields
 	pendingJobs  chan Job
 	stateChannel chan []byte
 	distro       distro.Distro
+	outputDir    string
+
+	stateFile    string
+	stateWritten chan struct{} // closed once all states have been written
+	stateMu      sync.Mutex    // protects stateErr
+	stateErr     error         // result of the last write of the state
+}
+
+// Options contains the settings of a Store which are not persisted. A zero
+// value selects the default.
+type Options struct {
+	// Directory in which composes store their outputs, in a subdirectory
+	// named after the compose.
+	OutputDir string
+	// Maximum number of composes which wait for a worker. PushCompose
+	// fails once this is reached.
+	MaxPendingJobs int
 }
 
 // A Compose represent the task of building one image. It contains all the information
@@ -52,6 +77,54 @@ type Compose struct {
 	JobStarted  time.Time            `json:"job_started"`
 	JobFinished time.Time            `json:"job_finished"`
 	Image       *Image               `json:"image"`
+	Webhooks    []string             `json:"webhooks,omitempty"`
+	Deliveries  []WebhookDelivery    `json:"webhook_deliveries,omitempty"`
+	User        string               `json:"user,omitempty"`
+	RequestID   string               `json:"request_id,omitempty"`
+	// Values of the blueprint's variables which were applied to it.
+	Variables map[string]interface{} `json:"variables,omitempty"`
+	// Commit of the blueprint which was composed, or "WORKSPACE" if it
+	// was its uncommitted workspace. Empty for inline blueprints.
+	Commit string `json:"commit,omitempty"`
+	// Inputs of the compose, which are its metadata and are signed when
+	// it finishes. Missing for composes from before they were recorded.
+	Manifest *manifest.Manifest `json:"manifest,omitempty"`
+}
+
+// ComposeOptions contains the optional settings of a new compose.
+type ComposeOptions struct {
+	// URLs to notify when the compose finishes, in addition to the global
+	// webhooks.
+	Webhooks []string
+	// The user who requested the compose.
+	User string
+	// The ID of the API request which started the compose, which is passed
+	// on to the worker to correlate their logs.
+	RequestID string
+	// Values of the blueprint's variables, which have already been
+	// applied to it.
+	Variables map[string]interface{}
+	// Commit of the blueprint, or "WORKSPACE".
+	Commit string
+	// Repositories which the image's packages are installed from. The
+	// distro's repositories by default.
+	Repositories []rpmmd.RepoConfig
+	// Inputs of the compose. PushCompose fills in the compose ID and the
+	// pipeline.
+	Manifest *manifest.Manifest
+}
+
+// A WebhookDelivery records the outcome of notifying one webhook about a
+// compose or one of its upload targets reaching a final state.
+type WebhookDelivery struct {
+	URL        string     `json:"url"`
+	Event      string     `json:"event"`
+	TargetID   *uuid.UUID `json:"target_id,omitempty"`
+	Attempts   int        `json:"attempts"`
+	StatusCode int        `json:"status_code,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Delivered  bool       `json:"delivered"`
+	Timestamp  time.Time  `json:"timestamp"`
 }
 
 // A Job contains the information about a compose a worker needs to process it.
@@ -60,6 +133,7 @@ type Job struct {
 	Pipeline   *pipeline.Pipeline
 	Targets    []*target.Target
 	OutputType string
+	RequestID  string
 }
 
 // An Image represents the image resulting from a compose.
@@ -67,6 +141,9 @@ type Image struct {
 	Path string
 	Mime string
 	Size int64
+	// Checksums of the image as hexadecimal strings, keyed by algorithm,
+	// such as "sha256".
+	Checksums map[string]string `json:",omitempty"`
 }
 
 type SourceConfig struct {
@@ -76,6 +153,15 @@ type SourceConfig struct {
 	CheckGPG bool   `json:"check_gpg"`
 	CheckSSL bool   `json:"check_ssl"`
 	System   bool   `json:"system"`
+
+	GPGKey     string   `json:"gpgkey,omitempty"`
+	GPGKeyURLs []string `json:"gpgkey_urls,omitempty"`
+	Proxy      string   `json:"proxy,omitempty"`
+
+	// PEM encoded, as files on this host are not available to workers
+	SSLCACert     string `json:"sslcacert,omitempty"`
+	SSLClientCert string `json:"sslclientcert,omitempty"`
+	SSLClientKey  string `json:"sslclientkey,omitempty"`
 }
 
 type NotFoundError struct {
@@ -102,6 +188,14 @@ func (e *NotRunningError) Error() string {
 	return e.message
 }
 
+type QueueFullError struct {
+	message string
+}
+
+func (e *QueueFullError) Error() string {
+	return e.message
+}
+
 type InvalidRequestError struct {
 	message string
 }
@@ -111,6 +205,10 @@ func (e *InvalidRequestError) Error() string {
 }
 
 func New(stateFile *string, distro distro.Distro) *Store {
+	return NewWithOptions(stateFile, distro, Options{})
+}
+
+func NewWithOptions(stateFile *string, distro distro.Distro, options Options) *Store {
 	var s Store
 
 	if stateFile != nil {
@@ -118,22 +216,31 @@ func New(stateFile *string, distro distro.Distro) *Store {
 		if state != nil {
 			err := json.Unmarshal(state, &s)
 			if err != nil {
-				log.Fatalf("invalid initial state: %v", err)
+				logging.Default().Fatalf("invalid initial state: %v", err)
 			}
 		} else if !os.IsNotExist(err) {
-			log.Fatalf("cannot read state: %v", err)
+			logging.Default().Fatalf("cannot read state: %v", err)
 		}
 
 		s.stateChannel = make(chan []byte, 128)
+		s.stateFile = *stateFile
+		s.stateWritten = make(chan struct{})
 
-		go func() {
-			for {
-				err := writeFileAtomically(*stateFile, <-s.stateChannel, 0755)
+		go func(states <-chan []byte) {
+			defer close(s.stateWritten)
+
+			// every write contains the full state, so a failed write
+			// is repaired by the next successful one
+			for state := range states {
+				err := writeFileAtomically(*stateFile, state, 0755)
 				if err != nil {
-					log.Fatalf("cannot write state: %v", err)
+					logging.Default().Errorf("cannot write state: %v", err)
 				}
+				s.stateMu.Lock()
+				s.stateErr = err
+				s.stateMu.Unlock()
 			}
-		}()
+		}(s.stateChannel)
 	}
 
 	if s.Blueprints == nil {
@@ -152,13 +259,86 @@ func New(stateFile *string, distro distro.Distro) *Store {
 	if s.BlueprintsChanges == nil {
 		s.BlueprintsChanges = make(map[string]map[string]blueprint.Change)
 	}
-	s.pendingJobs = make(chan Job, 200)
+	if s.NewestCommits == nil {
+		s.NewestCommits = make(map[string]string)
+	}
+	if s.ChangeBlueprints == nil {
+		// states from before the blueprints of changes were recorded
+		// only have the blueprint of the newest commit
+		s.ChangeBlueprints = make(map[string]map[string]blueprint.Blueprint)
+		for name, bp := range s.Blueprints {
+			if commit := s.newestCommit(name); commit != "" {
+				s.ChangeBlueprints[name] = map[string]blueprint.Blueprint{commit: bp}
+			}
+		}
+	}
+	if options.MaxPendingJobs == 0 {
+		options.MaxPendingJobs = 200
+	}
+	s.pendingJobs = make(chan Job, options.MaxPendingJobs)
+
+	if options.OutputDir == "" {
+		options.OutputDir = "/var/lib/osbuild-composer/outputs"
+	}
+	s.outputDir = options.OutputDir
 
 	s.distro = distro
 
 	return &s
 }
 
+// CheckState returns an error if the state cannot be persisted, because
+// the last write failed, writes are falling behind, or the directory of the
+// state file is not writable.
+func (s *Store) CheckState() error {
+	s.mu.RLock()
+	stateChannel := s.stateChannel
+	s.mu.RUnlock()
+
+	if stateChannel == nil {
+		return nil
+	}
+
+	s.stateMu.Lock()
+	err := s.stateErr
+	s.stateMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot write state: %v", err)
+	}
+
+	if len(stateChannel) == cap(stateChannel) {
+		return errors.New("writing the state is falling behind")
+	}
+
+	probe, err := ioutil.TempFile(filepath.Dir(s.stateFile), ".health-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// Close waits until all changes have been written to the state file and
+// returns the error of the last write. Changes made after Close are not
+// persisted anymore.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	stateChannel := s.stateChannel
+	s.stateChannel = nil
+	s.mu.Unlock()
+
+	if stateChannel == nil {
+		return nil
+	}
+
+	close(stateChannel)
+	<-s.stateWritten
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	return s.stateErr
+}
+
 func writeFileAtomically(filename string, data []byte, mode os.FileMode) error {
 	dir, name := filepath.Dir(filename), filepath.Base(filename)
 
@@ -250,6 +430,9 @@ func (s *Store) GetAllComposes() map[uuid.UUID]Compose {
 		newBlueprint := *compose.Blueprint
 		newCompose.Blueprint = &newBlueprint
 
+		newCompose.Webhooks = append([]string(nil), compose.Webhooks...)
+		newCompose.Deliveries = append([]WebhookDelivery(nil), compose.Deliveries...)
+
 		composes[id] = newCompose
 	}
 
@@ -287,12 +470,19 @@ func (s *Store) GetBlueprint(name string) (*blueprint.Blueprint, bool) {
 }
 
 func (s *Store) GetBlueprintCommitted(name string) *blueprint.Blueprint {
+	bp, _ := s.GetBlueprintNewest(name)
+	return bp
+}
+
+// GetBlueprintNewest returns the committed version of the blueprint called
+// name together with its commit, or nil if it does not exist.
+func (s *Store) GetBlueprintNewest(name string) (*blueprint.Blueprint, string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	bp, ok := s.Blueprints[name]
 	if !ok {
-		return nil
+		return nil, ""
 	}
 
 	// cockpit-composer cannot deal with missing "packages" or "modules"
@@ -309,7 +499,22 @@ func (s *Store) GetBlueprintCommitted(name string) *blueprint.Blueprint {
 		bp.Version = "0.0.0"
 	}
 
-	return &bp
+	return &bp, s.newestCommit(name)
+}
+
+// newestCommit returns the commit of the committed version of the blueprint
+// called name, or an empty string if it has no changes.
+func (s *Store) newestCommit(name string) string {
+	commit, ok := s.NewestCommits[name]
+	if !ok {
+		// states from before newest commits were recorded
+		for _, change := range s.BlueprintsChanges[name] {
+			if change.Timestamp > s.BlueprintsChanges[name][commit].Timestamp {
+				commit = change.Commit
+			}
+		}
+	}
+	return commit
 }
 
 func (s *Store) GetBlueprintChange(name string, commit string) *blueprint.Change {
@@ -320,16 +525,40 @@ func (s *Store) GetBlueprintChange(name string, commit string) *blueprint.Change
 	if !ok {
 		return nil
 	}
+	change.Blueprint = s.ChangeBlueprints[name][commit]
 	return &change
 }
 
+// GetBlueprintChangeAsOf returns the newest change of the blueprint called
+// name whose timestamp is not after timestamp, or nil if there is none.
+func (s *Store) GetBlueprintChangeAsOf(name string, timestamp string) *blueprint.Change {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	newest := s.newestCommit(name)
+	var found *blueprint.Change
+	for commit, change := range s.BlueprintsChanges[name] {
+		if change.Timestamp > timestamp {
+			continue
+		}
+		// timestamps only have seconds, prefer the newest commit of a tie
+		if found == nil || change.Timestamp > found.Timestamp || change.Timestamp == found.Timestamp && commit == newest {
+			change := change
+			change.Blueprint = s.ChangeBlueprints[name][commit]
+			found = &change
+		}
+	}
+	return found
+}
+
 func (s *Store) GetBlueprintChanges(name string) []blueprint.Change {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var changes []blueprint.Change
 
-	for _, change := range s.BlueprintsChanges[name] {
+	for commit, change := range s.BlueprintsChanges[name] {
+		change.Blueprint = s.ChangeBlueprints[name][commit]
 		changes = append(changes, change)
 	}
 
@@ -351,39 +580,86 @@ func bumpVersion(str string) string {
 	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2]+1)
 }
 
-func (s *Store) PushBlueprint(bp blueprint.Blueprint, commitMsg string) {
+// PushBlueprint commits bp on behalf of user, who may be empty if unknown.
+func (s *Store) PushBlueprint(bp blueprint.Blueprint, commitMsg string, user string) {
 	s.change(func() error {
-		hash := sha1.New()
-		// Hash timestamp to create unique hash
-		hash.Write([]byte(time.Now().String()))
-		// Get hash as a byte slice
-		commitBytes := hash.Sum(nil)
-		// Get hash as a hex string
-		commit := hex.EncodeToString(commitBytes)
+		if old, ok := s.Blueprints[bp.Name]; ok {
+			if bp.Version == "" || bp.Version == old.Version {
+				bp.Version = bumpVersion(old.Version)
+			}
+		}
+
+		commit := newCommit()
 		timestamp := time.Now().Format("2006-01-02T15:04:05Z")
 		change := blueprint.Change{
 			Commit:    commit,
 			Message:   commitMsg,
 			Timestamp: timestamp,
-			Blueprint: bp,
+			User:      user,
 		}
 
 		delete(s.Workspace, bp.Name)
 		if s.BlueprintsChanges[bp.Name] == nil {
 			s.BlueprintsChanges[bp.Name] = make(map[string]blueprint.Change)
 		}
+		if s.ChangeBlueprints[bp.Name] == nil {
+			s.ChangeBlueprints[bp.Name] = make(map[string]blueprint.Blueprint)
+		}
 		s.BlueprintsChanges[bp.Name][commit] = change
+		s.ChangeBlueprints[bp.Name][commit] = bp
+		s.NewestCommits[bp.Name] = commit
 
-		if old, ok := s.Blueprints[bp.Name]; ok {
-			if bp.Version == "" || bp.Version == old.Version {
-				bp.Version = bumpVersion(old.Version)
+		s.Blueprints[bp.Name] = bp
+		return nil
+	})
+}
+
+// ImportBlueprint replaces the blueprint called bp.Name and its history with
+// bp and changes, and discards its workspace. If changes is empty, a single
+// change importing bp is recorded on behalf of user.
+func (s *Store) ImportBlueprint(bp blueprint.Blueprint, changes []blueprint.Change, user string) {
+	if len(changes) == 0 {
+		changes = []blueprint.Change{{
+			Message:   "Recipe " + bp.Name + ", version " + bp.Version + " imported.",
+			Timestamp: time.Now().Format("2006-01-02T15:04:05Z"),
+			User:      user,
+			Blueprint: bp,
+		}}
+	}
+
+	s.change(func() error {
+		history := make(map[string]blueprint.Change, len(changes))
+		blueprints := make(map[string]blueprint.Blueprint, len(changes))
+		var newest blueprint.Change
+		for _, change := range changes {
+			if change.Commit == "" {
+				change.Commit = newCommit()
+			}
+			blueprints[change.Commit] = change.Blueprint
+			change.Blueprint = blueprint.Blueprint{}
+			history[change.Commit] = change
+			if change.Timestamp >= newest.Timestamp {
+				newest = change
 			}
 		}
+
+		delete(s.Workspace, bp.Name)
+		s.BlueprintsChanges[bp.Name] = history
+		s.ChangeBlueprints[bp.Name] = blueprints
+		s.NewestCommits[bp.Name] = newest.Commit
 		s.Blueprints[bp.Name] = bp
 		return nil
 	})
 }
 
+// newCommit returns a new, unique commit hash for a blueprint change.
+func newCommit() string {
+	hash := sha1.New()
+	// Hash timestamp to create unique hash
+	hash.Write([]byte(time.Now().String()))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 func (s *Store) PushBlueprintToWorkspace(bp blueprint.Blueprint) {
 	s.change(func() error {
 		s.Workspace[bp.Name] = bp
@@ -395,6 +671,7 @@ func (s *Store) DeleteBlueprint(name string) {
 	s.change(func() error {
 		delete(s.Workspace, name)
 		delete(s.Blueprints, name)
+		delete(s.NewestCommits, name)
 		return nil
 	})
 }
@@ -406,11 +683,15 @@ func (s *Store) DeleteBlueprintFromWorkspace(name string) {
 	})
 }
 
-func (s *Store) PushCompose(composeID uuid.UUID, bp *blueprint.Blueprint, composeType string, uploadTarget *target.Target) error {
+func (s *Store) PushCompose(composeID uuid.UUID, bp *blueprint.Blueprint, composeType string, uploadTarget *target.Target, options *ComposeOptions) error {
+	if options == nil {
+		options = &ComposeOptions{}
+	}
+
 	targets := []*target.Target{
 		target.NewLocalTarget(
 			&target.LocalTargetOptions{
-				Location: "/var/lib/osbuild-composer/outputs/" + composeID.String(),
+				Location: filepath.Join(s.outputDir, composeID.String()),
 			},
 		),
 	}
@@ -419,32 +700,70 @@ func (s *Store) PushCompose(composeID uuid.UUID, bp *blueprint.Blueprint, compos
 		targets = append(targets, uploadTarget)
 	}
 
-	pipeline, err := s.distro.Pipeline(bp, composeType)
+	repos := options.Repositories
+	if repos == nil {
+		repos = s.distro.Repositories()
+	}
+	pipeline, err := s.distro.Pipeline(bp, repos, composeType)
 	if err != nil {
 		return err
 	}
-	s.change(func() error {
+	var m *manifest.Manifest
+	if options.Manifest != nil {
+		m = &manifest.Manifest{}
+		*m = *options.Manifest
+		m.ComposeID = composeID
+		m.Pipeline = pipeline
+	}
+
+	job := Job{
+		ComposeID:  composeID,
+		Pipeline:   pipeline,
+		Targets:    targets,
+		OutputType: composeType,
+		RequestID:  options.RequestID,
+	}
+	return s.change(func() error {
+		// queue the job while holding the lock, so that it cannot be
+		// popped before the compose exists
+		select {
+		case s.pendingJobs <- job:
+		default:
+			return &QueueFullError{"too many composes are waiting for a worker"}
+		}
+
 		s.Composes[composeID] = Compose{
 			QueueStatus: "WAITING",
 			Blueprint:   bp,
 			OutputType:  composeType,
 			Targets:     targets,
 			JobCreated:  time.Now(),
+			Webhooks:    options.Webhooks,
+			User:        options.User,
+			RequestID:   options.RequestID,
+			Variables:   options.Variables,
+			Commit:      options.Commit,
+			Manifest:    m,
 		}
 		return nil
 	})
-	s.pendingJobs <- Job{
-		ComposeID:  composeID,
-		Pipeline:   pipeline,
-		Targets:    targets,
-		OutputType: composeType,
-	}
-
-	return nil
 }
 
 func (s *Store) PopCompose() Job {
-	job := <-s.pendingJobs
+	job, _ := s.PopComposeContext(context.Background())
+	return job
+}
+
+// PopComposeContext waits for a compose like PopCompose, but gives up and
+// returns the context's error when ctx is done.
+func (s *Store) PopComposeContext(ctx context.Context) (Job, error) {
+	var job Job
+	select {
+	case job = <-s.pendingJobs:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
 	s.change(func() error {
 		compose, exists := s.Composes[job.ComposeID]
 		if !exists || compose.QueueStatus != "WAITING" {
@@ -452,13 +771,14 @@ func (s *Store) PopCompose() Job {
 		}
 		compose.JobStarted = time.Now()
 		compose.QueueStatus = "RUNNING"
+		composeWaitDuration.Observe(compose.JobStarted.Sub(compose.JobCreated).Seconds(), s.distro.Name(), compose.OutputType)
 		for _, t := range compose.Targets {
 			t.Status = "RUNNING"
 		}
 		s.Composes[job.ComposeID] = compose
 		return nil
 	})
-	return job
+	return job, nil
 }
 
 func (s *Store) UpdateCompose(composeID uuid.UUID, status string, image *Image) error {
@@ -481,6 +801,7 @@ func (s *Store) UpdateCompose(composeID uuid.UUID, status string, image *Image)
 			switch compose.QueueStatus {
 			case "RUNNING":
 				compose.JobFinished = time.Now()
+				composeBuildDuration.Observe(compose.JobFinished.Sub(compose.JobStarted).Seconds(), s.distro.Name(), compose.OutputType, status)
 			default:
 				return &NotRunningError{"compose was not running"}
 			}
@@ -501,6 +822,82 @@ func (s *Store) UpdateCompose(composeID uuid.UUID, status string, image *Image)
 	})
 }
 
+// GetComposeArtifactDir returns the directory in which the artifacts of the
+// running compose composeID are kept, as they are received from the worker.
+func (s *Store) GetComposeArtifactDir(composeID uuid.UUID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	compose, exists := s.Composes[composeID]
+	if !exists {
+		return "", &NotFoundError{"compose does not exist"}
+	}
+	if compose.QueueStatus != "RUNNING" {
+		return "", &NotRunningError{"compose is not running"}
+	}
+
+	for _, t := range compose.Targets {
+		if options, ok := t.Options.(*target.LocalTargetOptions); ok {
+			return options.Location, nil
+		}
+	}
+	return "", &InvalidRequestError{"compose does not keep its artifacts"}
+}
+
+// SetManifestPackages records packages, which were installed in the image of
+// a compose, in its manifest. Composes without a manifest are left alone.
+func (s *Store) SetManifestPackages(composeID uuid.UUID, packages []rpmmd.PackageSpec) error {
+	return s.change(func() error {
+		compose, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if compose.Manifest == nil {
+			return nil
+		}
+
+		// the manifest may be shared with callers of GetCompose
+		m := *compose.Manifest
+		m.Packages = packages
+		compose.Manifest = &m
+		s.Composes[composeID] = compose
+		return nil
+	})
+}
+
+// DeleteCompose removes a finished or failed compose from the store and
+// returns it, so that the caller can clean up its outputs.
+func (s *Store) DeleteCompose(composeID uuid.UUID) (Compose, error) {
+	var compose Compose
+	err := s.change(func() error {
+		var exists bool
+		compose, exists = s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		if compose.QueueStatus != "FINISHED" && compose.QueueStatus != "FAILED" {
+			return &InvalidRequestError{"compose is still " + strings.ToLower(compose.QueueStatus)}
+		}
+		delete(s.Composes, composeID)
+		return nil
+	})
+	return compose, err
+}
+
+// AddWebhookDelivery appends a delivery record to the webhook log of a
+// compose.
+func (s *Store) AddWebhookDelivery(composeID uuid.UUID, delivery WebhookDelivery) error {
+	return s.change(func() error {
+		compose, exists := s.Composes[composeID]
+		if !exists {
+			return &NotFoundError{"compose does not exist"}
+		}
+		compose.Deliveries = append(compose.Deliveries, delivery)
+		s.Composes[composeID] = compose
+		return nil
+	})
+}
+
 func (s *Store) PushSource(source SourceConfig) {
 	s.change(func() error {
 		s.Sources[source.Name] = source
@@ -553,10 +950,14 @@ func (s *Store) GetAllSources() map[string]SourceConfig {
 
 func NewSourceConfig(repo rpmmd.RepoConfig, system bool) SourceConfig {
 	sc := SourceConfig{
-		Name:     repo.Id,
-		CheckGPG: true,
-		CheckSSL: true,
-		System:   system,
+		Name: repo.Id,
+		// osbuild verifies packages with the repository's gpgkey,
+		// even if gpgcheck is not set
+		CheckGPG:   repo.CheckGPG || repo.GPGKey != "",
+		CheckSSL:   !repo.IgnoreSSL,
+		System:     system,
+		GPGKeyURLs: repo.GPGKeyURLs,
+		Proxy:      repo.Proxy,
 	}
 
 	if repo.BaseURL != "" {
@@ -578,6 +979,14 @@ func (s *SourceConfig) RepoConfig() rpmmd.RepoConfig {
 
 	repo.Name = s.Name
 	repo.Id = s.Name
+	repo.CheckGPG = s.CheckGPG
+	repo.GPGKey = s.GPGKey
+	repo.GPGKeyURLs = s.GPGKeyURLs
+	repo.IgnoreSSL = !s.CheckSSL
+	repo.Proxy = s.Proxy
+	repo.SSLCACert = s.SSLCACert
+	repo.SSLClientCert = s.SSLClientCert
+	repo.SSLClientKey = s.SSLClientKey
 
 	if s.Type == "yum-baseurl" {
 		repo.BaseURL = s.URL
@@ -1,7 +1,19 @@
 package store
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 )
 
 func TestBumpVersion(t *testing.T) {
@@ -28,3 +40,203 @@ func TestBumpVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestQueueFull(t *testing.T) {
+	s := NewWithOptions(nil, distro.New("fedora-30"), Options{MaxPendingJobs: 1})
+	bp := &blueprint.Blueprint{Name: "test"}
+
+	err := s.PushCompose(uuid.New(), bp, "tar", nil, nil)
+	if err != nil {
+		t.Fatalf("cannot push first compose: %v", err)
+	}
+
+	id := uuid.New()
+	err = s.PushCompose(id, bp, "tar", nil, nil)
+	if _, ok := err.(*QueueFullError); !ok {
+		t.Fatalf("expected QueueFullError, got %v", err)
+	}
+	if _, exists := s.GetCompose(id); exists {
+		t.Errorf("rejected compose was added to the store")
+	}
+
+	s.PopCompose()
+	err = s.PushCompose(id, bp, "tar", nil, nil)
+	if err != nil {
+		t.Errorf("cannot push compose after the queue was drained: %v", err)
+	}
+}
+
+func TestBlueprintNewest(t *testing.T) {
+	s := NewWithOptions(nil, distro.New("fedora-30"), Options{})
+
+	if bp, commit := s.GetBlueprintNewest("test"); bp != nil || commit != "" {
+		t.Errorf("unknown blueprint has commit %s", commit)
+	}
+
+	s.ImportBlueprint(blueprint.Blueprint{Name: "test", Version: "0.0.2"}, []blueprint.Change{
+		{Commit: "b", Timestamp: "2020-01-02T00:00:00Z"},
+		{Commit: "a", Timestamp: "2020-01-01T00:00:00Z"},
+	}, "")
+	if _, commit := s.GetBlueprintNewest("test"); commit != "b" {
+		t.Errorf("imported blueprint has newest commit %s, expected b", commit)
+	}
+
+	// commits in the same second are ordered by when they were made
+	for i := 0; i < 3; i++ {
+		s.PushBlueprint(blueprint.Blueprint{Name: "test"}, "change "+strconv.Itoa(i), "")
+		bp, commit := s.GetBlueprintNewest("test")
+		change := s.GetBlueprintChange("test", commit)
+		if bp == nil || change == nil || change.Message != "change "+strconv.Itoa(i) {
+			t.Errorf("unexpected newest commit %s: %+v", commit, change)
+		}
+	}
+
+	// states from before newest commits were recorded
+	s.ImportBlueprint(blueprint.Blueprint{Name: "test"}, []blueprint.Change{
+		{Commit: "a", Timestamp: "2020-01-01T00:00:00Z"},
+		{Commit: "b", Timestamp: "2020-01-02T00:00:00Z"},
+	}, "")
+	delete(s.NewestCommits, "test")
+	if _, commit := s.GetBlueprintNewest("test"); commit != "b" {
+		t.Errorf("blueprint without newest commit has commit %s, expected b", commit)
+	}
+
+	s.DeleteBlueprint("test")
+	if bp, commit := s.GetBlueprintNewest("test"); bp != nil || commit != "" {
+		t.Errorf("deleted blueprint has commit %s", commit)
+	}
+}
+
+func TestSourceConfig(t *testing.T) {
+	repos := []rpmmd.RepoConfig{
+		{
+			Id:      "plain",
+			Name:    "plain",
+			BaseURL: "http://example.com/plain/os",
+		},
+		{
+			Id:         "mirror",
+			Name:       "mirror",
+			MirrorList: "https://mirror.example.com/mirrorlist",
+			CheckGPG:   true,
+			GPGKeyURLs: []string{"https://mirror.example.com/RPM-GPG-KEY"},
+			IgnoreSSL:  true,
+			Proxy:      "http://proxy.example.com:3128",
+		},
+	}
+
+	for _, repo := range repos {
+		source := NewSourceConfig(repo, false)
+		if source.CheckGPG != repo.CheckGPG || source.CheckSSL == repo.IgnoreSSL {
+			t.Errorf("%s: unexpected checks of source: %+v", repo.Id, source)
+		}
+		if actual := source.RepoConfig(); !reflect.DeepEqual(actual, repo) {
+			t.Errorf("%s: source changed repository:\n%+v\nexpected:\n%+v", repo.Id, actual, repo)
+		}
+	}
+}
+
+func TestChangeBlueprints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-store-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	s := New(&stateFile, distro.New("fedora-30"))
+	s.PushBlueprint(blueprint.Blueprint{Name: "test", Description: "first", Version: "0.0.1"}, "first", "alice")
+	_, first := s.GetBlueprintNewest("test")
+	s.PushBlueprint(blueprint.Blueprint{Name: "test", Description: "second"}, "second", "alice")
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("cannot close store: %v", err)
+	}
+
+	reopened := New(&stateFile, distro.New("fedora-30"))
+	change := reopened.GetBlueprintChange("test", first)
+	if change == nil || change.Blueprint.Description != "first" || change.Blueprint.Version != "0.0.1" {
+		t.Errorf("blueprint of the first change was not written: %+v", change)
+	}
+	_, newest := reopened.GetBlueprintNewest("test")
+	change = reopened.GetBlueprintChange("test", newest)
+	if change == nil || change.Blueprint.Description != "second" || change.Blueprint.Version != "0.0.2" {
+		t.Errorf("blueprint of the newest change was not written: %+v", change)
+	}
+
+	// states from before the blueprints of changes were recorded
+	state, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("cannot read state: %v", err)
+	}
+	var old map[string]interface{}
+	err = json.Unmarshal(state, &old)
+	if err != nil {
+		t.Fatalf("cannot parse state: %v", err)
+	}
+	delete(old, "change_blueprints")
+	state, _ = json.Marshal(old)
+	err = ioutil.WriteFile(stateFile, state, 0600)
+	if err != nil {
+		t.Fatalf("cannot write state: %v", err)
+	}
+
+	migrated := New(&stateFile, distro.New("fedora-30"))
+	if change := migrated.GetBlueprintChange("test", newest); change == nil || change.Blueprint.Description != "second" {
+		t.Errorf("newest change of an old state has no blueprint: %+v", change)
+	}
+	if change := migrated.GetBlueprintChange("test", first); change == nil || change.Blueprint.Name != "" {
+		t.Errorf("older change of an old state has a blueprint: %+v", change)
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-store-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	s := New(&stateFile, distro.New("fedora-30"))
+	if err := s.CheckState(); err != nil {
+		t.Errorf("check failed on a writable state directory: %v", err)
+	}
+
+	stateFile = filepath.Join(dir, "missing", "state.json")
+	s = New(&stateFile, distro.New("fedora-30"))
+	if err := s.CheckState(); err == nil {
+		t.Errorf("check passed on a nonexistent state directory")
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "composer-store-")
+	if err != nil {
+		t.Fatalf("cannot create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	stateFile := filepath.Join(dir, "state.json")
+	s := New(&stateFile, distro.New("fedora-30"))
+	for i := 0; i < 10; i++ {
+		s.PushBlueprint(blueprint.Blueprint{Name: "test", Description: strconv.Itoa(i)}, "change", "alice")
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatalf("cannot close store: %v", err)
+	}
+
+	reopened := New(&stateFile, distro.New("fedora-30"))
+	if bp := reopened.GetBlueprintCommitted("test"); bp == nil || bp.Description != "9" {
+		t.Errorf("last change was not written: %+v", bp)
+	}
+
+	stateFile = filepath.Join(dir, "missing", "state.json")
+	s = New(&stateFile, distro.New("fedora-30"))
+	s.PushBlueprint(blueprint.Blueprint{Name: "test"}, "change", "alice")
+	if err := s.Close(); err == nil {
+		t.Errorf("close did not report a failed write")
+	}
+}
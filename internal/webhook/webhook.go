@@ -0,0 +1,243 @@
+// Package webhook notifies HTTP endpoints when composes and their upload
+// targets reach a final state.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/store"
+)
+
+// SignatureHeader is the HTTP header carrying the HMAC-SHA256 signature of
+// the payload, in the form "sha256=<hex digest>".
+const SignatureHeader = "X-Composer-Signature"
+
+// EventHeader is the HTTP header carrying the event type of the payload.
+const EventHeader = "X-Composer-Event"
+
+// ValidateURL checks that webhook is an absolute http(s) URL.
+func ValidateURL(webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+
+	return nil
+}
+
+// A Payload is the JSON document POSTed to every webhook.
+type Payload struct {
+	Event       string     `json:"event"`
+	ComposeID   uuid.UUID  `json:"compose_id"`
+	Blueprint   string     `json:"blueprint"`
+	Version     string     `json:"version"`
+	ComposeType string     `json:"compose_type"`
+	Status      string     `json:"status"`
+	TargetID    *uuid.UUID `json:"target_id,omitempty"`
+	TargetName  string     `json:"target_name,omitempty"`
+	ImageName   string     `json:"image_name,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
+
+// A Notifier delivers payloads to the globally configured webhooks and to
+// the webhooks registered on each compose, and records every delivery in
+// the store.
+type Notifier struct {
+	store  *store.Store
+	client *http.Client
+
+	// Retries is the number of times a delivery is retried after the
+	// first attempt failed. Backoff is the delay before the first retry,
+	// doubled for each subsequent one.
+	Retries int
+	Backoff time.Duration
+
+	mu     sync.RWMutex
+	urls   []string
+	secret []byte
+
+	wg sync.WaitGroup
+}
+
+// New creates a notifier which sends every event to urls in addition to
+// the webhooks of the compose. Payloads are signed with secret, unless it
+// is empty.
+func New(store *store.Store, urls []string, secret []byte) *Notifier {
+	return &Notifier{
+		store:   store,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		Retries: 4,
+		Backoff: 5 * time.Second,
+		urls:    urls,
+		secret:  secret,
+	}
+}
+
+// SetURLs replaces the list of global webhooks.
+func (n *Notifier) SetURLs(urls []string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.urls = urls
+}
+
+// SetSecret replaces the key used to sign payloads.
+func (n *Notifier) SetSecret(secret []byte) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.secret = secret
+}
+
+// ComposeFinished sends one "compose" event for the compose, and one
+// "upload" event for each of its upload targets, to every webhook. It
+// returns immediately; deliveries happen in the background.
+func (n *Notifier) ComposeFinished(id uuid.UUID, compose store.Compose) {
+	n.mu.RLock()
+	urls := append(append([]string(nil), n.urls...), compose.Webhooks...)
+	secret := n.secret
+	n.mu.RUnlock()
+
+	if len(urls) == 0 {
+		return
+	}
+
+	payloads := []Payload{newPayload("compose", id, compose)}
+	for _, t := range compose.Targets {
+		if t.Name == "org.osbuild.local" {
+			continue
+		}
+		p := newPayload("upload", id, compose)
+		targetID := t.Uuid
+		p.TargetID = &targetID
+		p.TargetName = t.Name
+		p.ImageName = t.ImageName
+		p.Status = t.Status
+		payloads = append(payloads, p)
+	}
+
+	for _, url := range urls {
+		for _, payload := range payloads {
+			n.wg.Add(1)
+			go func(url string, payload Payload) {
+				defer n.wg.Done()
+				n.deliver(url, payload, secret)
+			}(url, payload)
+		}
+	}
+}
+
+// Wait blocks until all pending deliveries have either succeeded or
+// exhausted their retries.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func newPayload(event string, id uuid.UUID, compose store.Compose) Payload {
+	p := Payload{
+		Event:       event,
+		ComposeID:   id,
+		ComposeType: compose.OutputType,
+		Status:      compose.QueueStatus,
+		Timestamp:   time.Now(),
+	}
+	if compose.Blueprint != nil {
+		p.Blueprint = compose.Blueprint.Name
+		p.Version = compose.Blueprint.Version
+	}
+	return p
+}
+
+// Sign returns the value of the signature header for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) deliver(url string, payload Payload, secret []byte) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		// we know all the types that go into the payload
+		panic(err)
+	}
+
+	delivery := store.WebhookDelivery{
+		URL:      url,
+		Event:    payload.Event,
+		TargetID: payload.TargetID,
+	}
+
+	backoff := n.Backoff
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		delivery.Attempts++
+		delivery.StatusCode, err = n.post(url, payload.Event, body, secret)
+		if err == nil {
+			delivery.Delivered = true
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+	}
+
+	logger := logging.Default().With("compose_id", payload.ComposeID).With("webhook", url)
+
+	delivery.Timestamp = time.Now()
+	if !delivery.Delivered {
+		logger.Warningf("giving up on delivering %s event: %s", payload.Event, delivery.Error)
+	}
+
+	err = n.store.AddWebhookDelivery(payload.ComposeID, delivery)
+	if err != nil {
+		logger.Errorf("cannot record webhook delivery: %v", err)
+	}
+}
+
+func (n *Notifier) post(url, event string, body, secret []byte) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	if len(secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,170 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
+	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/store"
+	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/webhook"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	payloads []webhook.Payload
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(request.Body)
+	var payload webhook.Payload
+	json.Unmarshal(body, &payload)
+
+	r.payloads = append(r.payloads, payload)
+	r.headers = append(r.headers, request.Header)
+	r.bodies = append(r.bodies, body)
+}
+
+func newStore(id uuid.UUID, targets ...*target.Target) *store.Store {
+	s := store.New(nil, distro.New("fedora-30"))
+	s.Composes[id] = store.Compose{
+		QueueStatus: "FINISHED",
+		Blueprint:   &blueprint.Blueprint{Name: "test", Version: "0.0.1"},
+		OutputType:  "tar",
+		Targets:     targets,
+	}
+	return s
+}
+
+func TestComposeFinished(t *testing.T) {
+	id := uuid.MustParse("30000000-0000-0000-0000-000000000000")
+	awsTarget := target.NewAWSTarget(&target.AWSTargetOptions{})
+	awsTarget.Status = "FINISHED"
+	s := newStore(id, target.NewLocalTarget(&target.LocalTargetOptions{}), awsTarget)
+
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	secret := []byte("secret")
+	n := webhook.New(s, []string{server.URL}, secret)
+	compose, _ := s.GetCompose(id)
+	n.ComposeFinished(id, compose)
+	n.Wait()
+
+	if len(r.payloads) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(r.payloads))
+	}
+
+	events := map[string]webhook.Payload{}
+	for i, p := range r.payloads {
+		events[p.Event] = p
+		if got, want := r.headers[i].Get(webhook.SignatureHeader), webhook.Sign(secret, r.bodies[i]); got != want {
+			t.Errorf("bad signature: got %s, want %s", got, want)
+		}
+		if got := r.headers[i].Get(webhook.EventHeader); got != p.Event {
+			t.Errorf("bad event header: got %s, want %s", got, p.Event)
+		}
+	}
+
+	if p, ok := events["compose"]; !ok || p.ComposeID != id || p.Status != "FINISHED" || p.Blueprint != "test" {
+		t.Errorf("unexpected compose event: %+v", p)
+	}
+	if p, ok := events["upload"]; !ok || p.TargetID == nil || *p.TargetID != awsTarget.Uuid || p.TargetName != "org.osbuild.aws" {
+		t.Errorf("unexpected upload event: %+v", p)
+	}
+
+	compose, _ = s.GetCompose(id)
+	if len(compose.Deliveries) != 2 {
+		t.Fatalf("expected 2 entries in the delivery log, got %d", len(compose.Deliveries))
+	}
+	for _, d := range compose.Deliveries {
+		if !d.Delivered || d.Attempts != 1 || d.StatusCode != http.StatusOK {
+			t.Errorf("unexpected delivery: %+v", d)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	id := uuid.MustParse("30000000-0000-0000-0000-000000000000")
+	s := newStore(id)
+
+	r := &receiver{failures: 2}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	// the compose-specific webhook must be used in addition to the global ones
+	compose, _ := s.GetCompose(id)
+	compose.Webhooks = []string{server.URL}
+
+	n := webhook.New(s, nil, nil)
+	n.Backoff = time.Millisecond
+	n.ComposeFinished(id, compose)
+	n.Wait()
+
+	if len(r.payloads) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(r.payloads))
+	}
+	if sig := r.headers[0].Get(webhook.SignatureHeader); sig != "" {
+		t.Errorf("unexpected signature without a secret: %s", sig)
+	}
+
+	compose, _ = s.GetCompose(id)
+	if len(compose.Deliveries) != 1 || !compose.Deliveries[0].Delivered || compose.Deliveries[0].Attempts != 3 {
+		t.Errorf("unexpected delivery log: %+v", compose.Deliveries)
+	}
+
+	r.mu.Lock()
+	r.failures = 100
+	r.mu.Unlock()
+	n.Retries = 1
+	compose.Webhooks = []string{server.URL}
+	n.ComposeFinished(id, compose)
+	n.Wait()
+
+	compose, _ = s.GetCompose(id)
+	last := compose.Deliveries[len(compose.Deliveries)-1]
+	if last.Delivered || last.Attempts != 2 || last.StatusCode != http.StatusServiceUnavailable || last.Error == "" {
+		t.Errorf("unexpected failed delivery: %+v", last)
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	var cases = []struct {
+		URL   string
+		Valid bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://localhost:8080", true},
+		{"ftp://example.com/hook", false},
+		{"https:///hook", false},
+		{"example.com/hook", false},
+		{"://", false},
+	}
+
+	for _, c := range cases {
+		if err := webhook.ValidateURL(c.URL); (err == nil) != c.Valid {
+			t.Errorf("%s: unexpected result %v", c.URL, err)
+		}
+	}
+}
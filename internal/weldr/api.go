@@ -2,11 +2,11 @@ package weldr
 
 import (
 	"archive/tar"
+	"context"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -20,11 +20,18 @@ import (
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/osbuild/osbuild-composer/internal/audit"
+	"github.com/osbuild/osbuild-composer/internal/auth"
 	"github.com/osbuild/osbuild-composer/internal/blueprint"
 	"github.com/osbuild/osbuild-composer/internal/distro"
+	"github.com/osbuild/osbuild-composer/internal/health"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+	"github.com/osbuild/osbuild-composer/internal/metrics"
 	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
 	"github.com/osbuild/osbuild-composer/internal/target"
+	"github.com/osbuild/osbuild-composer/internal/webhook"
 )
 
 type API struct {
@@ -33,13 +40,22 @@ type API struct {
 	rpmmd  rpmmd.RPMMD
 	distro distro.Distro
 
-	logger *log.Logger
-	router *httprouter.Router
+	logger *logging.Logger
+	router *metrics.Router
+	auth   *auth.Authenticator
+
+	auditLog *audit.Log
+	health   *health.Checker
+
+	server *http.Server
 }
 
-func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *log.Logger, store *store.Store) *API {
-	// This needs to be shared with the worker API so that they can communicate with each other
-	// builds := make(chan queue.Build, 200)
+// New creates a weldr API. It logs to the default logger if logger is nil.
+func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *logging.Logger, store *store.Store) *API {
+	if logger == nil {
+		logger = logging.Default()
+	}
+
 	api := &API{
 		store:  store,
 		rpmmd:  rpmmd,
@@ -47,13 +63,21 @@ func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *log.Logger, store *sto
 		logger: logger,
 	}
 
-	api.router = httprouter.New()
+	api.server = &http.Server{
+		Handler: api,
+	}
+
+	api.router = metrics.NewRouter("weldr")
 	api.router.RedirectTrailingSlash = false
 	api.router.RedirectFixedPath = false
 	api.router.MethodNotAllowed = http.HandlerFunc(methodNotAllowedHandler)
 	api.router.NotFound = http.HandlerFunc(notFoundHandler)
 
+	api.router.GET("/metrics", api.metricsHandler)
+	api.router.GET("/health/live", api.healthLiveHandler)
+	api.router.GET("/health/ready", api.healthReadyHandler)
 	api.router.GET("/api/status", api.statusHandler)
+	api.router.GET("/api/v:version/audit", api.auditHandler)
 	api.router.GET("/api/v:version/projects/source/list", api.sourceListHandler)
 	api.router.GET("/api/v:version/projects/source/info/", api.sourceEmptyInfoHandler)
 	api.router.GET("/api/v:version/projects/source/info/:sources", api.sourceInfoHandler)
@@ -79,6 +103,8 @@ func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *log.Logger, store *sto
 	api.router.GET("/api/v:version/blueprints/freeze/*blueprints", api.blueprintsFreezeHandler)
 	api.router.GET("/api/v:version/blueprints/diff/:blueprint/:from/:to", api.blueprintsDiffHandler)
 	api.router.GET("/api/v:version/blueprints/changes/*blueprints", api.blueprintsChangesHandler)
+	api.router.GET("/api/v:version/blueprints/export", api.blueprintsExportHandler)
+	api.router.POST("/api/v:version/blueprints/import", api.blueprintsImportHandler)
 	api.router.POST("/api/v:version/blueprints/new", api.blueprintsNewHandler)
 	api.router.POST("/api/v:version/blueprints/workspace", api.blueprintsWorkspaceHandler)
 	api.router.POST("/api/v:version/blueprints/undo/:blueprint/:commit", api.blueprintUndoHandler)
@@ -93,7 +119,11 @@ func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *log.Logger, store *sto
 	api.router.GET("/api/v:version/compose/finished", api.composeFinishedHandler)
 	api.router.GET("/api/v:version/compose/failed", api.composeFailedHandler)
 	api.router.GET("/api/v:version/compose/image/:uuid", api.composeImageHandler)
+	api.router.HEAD("/api/v:version/compose/image/:uuid", api.composeImageHandler)
 	api.router.GET("/api/v:version/compose/logs/:uuid", api.composeLogsHandler)
+	api.router.GET("/api/v:version/compose/metadata/:uuid", api.composeMetadataHandler)
+	api.router.GET("/api/v:version/compose/results/:uuid", api.composeResultsHandler)
+	api.router.GET("/api/v:version/compose/webhooks/:uuid", api.composeWebhooksHandler)
 	api.router.POST("/api/v:version/compose/uploads/schedule/:uuid", api.uploadsScheduleHandler)
 
 	api.router.DELETE("/api/v:version/upload/delete/:uuid", api.uploadsDeleteHandler)
@@ -109,10 +139,25 @@ func New(rpmmd rpmmd.RPMMD, distro distro.Distro, logger *log.Logger, store *sto
 	return api
 }
 
-func (api *API) Serve(listener net.Listener) error {
-	server := http.Server{Handler: api}
+// RequireAuthentication makes the API identify the caller of every request
+// with authenticator, and reject requests from callers whose role does not
+// allow them.
+func (api *API) RequireAuthentication(authenticator *auth.Authenticator) {
+	api.auth = authenticator
+}
 
-	err := server.Serve(listener)
+// SetAuditLog makes the API record all changes in auditLog.
+func (api *API) SetAuditLog(auditLog *audit.Log) {
+	api.auditLog = auditLog
+}
+
+// SetHealthChecker makes /health/ready report the results of checker.
+func (api *API) SetHealthChecker(checker *health.Checker) {
+	api.health = checker
+}
+
+func (api *API) Serve(listener net.Listener) error {
+	err := api.server.Serve(auth.Listener(listener))
 	if err != nil && err != http.ErrServerClosed {
 		return err
 	}
@@ -120,15 +165,109 @@ func (api *API) Serve(listener net.Listener) error {
 	return nil
 }
 
+// Shutdown stops accepting requests and waits until all requests in flight
+// have finished, or until ctx is done.
+func (api *API) Shutdown(ctx context.Context) error {
+	return api.server.Shutdown(ctx)
+}
+
 func (api *API) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
-	if api.logger != nil {
-		log.Println(request.Method, request.URL.Path)
+	// reuse the ID of a request which caused this one, so that they can
+	// be correlated in the logs
+	requestID := request.Header.Get(logging.RequestIDHeader)
+	if requestID == "" {
+		requestID = uuid.New().String()
+		request.Header.Set(logging.RequestIDHeader, requestID)
 	}
+	writer.Header().Set(logging.RequestIDHeader, requestID)
+
+	logger := api.logger.With("request_id", requestID)
+	request = request.WithContext(logging.NewContext(request.Context(), logger))
 
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	// load balancers probe health without credentials
+	if api.auth != nil && !strings.HasPrefix(request.URL.Path, "/health/") {
+		identity, err := api.auth.Identify(request)
+		if err != nil {
+			errors := responseError{
+				ID:  "Unauthorized",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusUnauthorized, errors)
+			return
+		}
+
+		logger = logger.With("user", identity.User)
+		request = request.WithContext(logging.NewContext(auth.WithIdentity(request.Context(), identity), logger))
+
+		if role := requiredRole(request); identity.Role < role {
+			api.audit(request, "denied", request.Method+" "+request.URL.Path, nil)
+			errors := responseError{
+				ID:  "Forbidden",
+				Msg: fmt.Sprintf("%s needs the %s role for this request", identity.User, role),
+			}
+			statusResponseError(writer, http.StatusForbidden, errors)
+			return
+		}
+	}
+
+	logger.Debugf("%s %s", request.Method, request.URL.Path)
 	api.router.ServeHTTP(writer, request)
 }
 
+// requiredRole returns the role needed for request. Sources and upload
+// providers affect all users of composer, so only admins may change them.
+// The audit log is only visible to admins.
+func requiredRole(request *http.Request) auth.Role {
+	// strip "/api/v<version>/"
+	route := request.URL.Path
+	if parts := strings.SplitN(route, "/", 4); len(parts) == 4 {
+		route = parts[3]
+	}
+
+	if route == "audit" {
+		return auth.Admin
+	}
+
+	if request.Method == "GET" || request.Method == "HEAD" {
+		return auth.Viewer
+	}
+
+	if strings.HasPrefix(route, "projects/source/") || strings.HasPrefix(route, "upload/providers/") {
+		return auth.Admin
+	}
+
+	return auth.Composer
+}
+
+// userName returns the name of the caller of request, or an empty string if
+// the API does not authenticate callers.
+func userName(request *http.Request) string {
+	if identity := auth.IdentityFromContext(request.Context()); identity != nil {
+		return identity.User
+	}
+	return ""
+}
+
+// audit records a change made by the caller of request in the audit log.
+// Failing to do so does not undo the change, and is only logged.
+func (api *API) audit(request *http.Request, action, object string, details map[string]string) {
+	if api.auditLog == nil {
+		return
+	}
+
+	err := api.auditLog.Append(audit.Record{
+		User:    userName(request),
+		Action:  action,
+		Object:  object,
+		Details: details,
+	})
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("cannot write audit record: %v", err)
+	}
+}
+
 func verifyRequestVersion(writer http.ResponseWriter, params httprouter.Params, minVersion uint) bool {
 	versionString := params.ByName("version")
 
@@ -215,6 +354,75 @@ func (api *API) statusHandler(writer http.ResponseWriter, request *http.Request,
 	})
 }
 
+func (api *API) metricsHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	metrics.Handler().ServeHTTP(writer, request)
+}
+
+func (api *API) healthLiveHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	health.LiveHandler(writer, request)
+}
+
+func (api *API) healthReadyHandler(writer http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	api.health.ServeHTTP(writer, request)
+}
+
+func (api *API) auditHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	if api.auditLog == nil {
+		errors := responseError{
+			ID:  "AuditLogDisabled",
+			Msg: "this composer does not keep an audit log",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	query := request.URL.Query()
+	filter := audit.Filter{
+		User:   query.Get("user"),
+		Action: query.Get("action"),
+	}
+	offset, limit, err := parseOffsetAndLimit(query)
+	if err == nil {
+		filter.Since, err = parseTime(query, "since")
+	}
+	if err == nil {
+		filter.Until, err = parseTime(query, "until")
+	}
+	if err != nil {
+		errors := responseError{
+			ID:  "BadRequest",
+			Msg: fmt.Sprintf("BadRequest: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	records, err := api.auditLog.Query(filter)
+	if err != nil {
+		errors := responseError{
+			ID:  "AuditLogError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusInternalServerError, errors)
+		return
+	}
+
+	total := uint(len(records))
+	offset = min(offset, total)
+	limit = min(limit, total-offset)
+
+	json.NewEncoder(writer).Encode(struct {
+		Records []audit.Record `json:"records"`
+		Total   uint           `json:"total"`
+		Offset  uint           `json:"offset"`
+		Limit   uint           `json:"limit"`
+	}{records[offset : offset+limit], total, offset, limit})
+}
+
 func (api *API) sourceListHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	if !verifyRequestVersion(writer, params, 0) {
 		return
@@ -268,6 +476,11 @@ func (api *API) sourceInfoHandler(writer http.ResponseWriter, request *http.Requ
 	// if names is "*" we want all sources
 	if names == "*" {
 		sources = api.store.GetAllSources()
+		for name, source := range sources {
+			// the client key is a secret
+			source.SSLClientKey = ""
+			sources[name] = source
+		}
 		for _, repo := range api.distro.Repositories() {
 			sources[repo.Id] = store.NewSourceConfig(repo, true)
 		}
@@ -287,6 +500,8 @@ func (api *API) sourceInfoHandler(writer http.ResponseWriter, request *http.Requ
 			}
 			// check if the source is in the store
 			if source := api.store.GetSource(name); source != nil {
+				// the client key is a secret
+				source.SSLClientKey = ""
 				sources[source.Name] = *source
 			} else {
 				error := responseError{
@@ -331,7 +546,39 @@ func (api *API) sourceNewHandler(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
+	// unlike dnf, these options contain the certificates instead of
+	// naming files on this host
+	options := []struct {
+		Name  string
+		Value string
+	}{
+		{"sslcacert", source.SSLCACert},
+		{"sslclientcert", source.SSLClientCert},
+		{"sslclientkey", source.SSLClientKey},
+	}
+	for _, option := range options {
+		if block, _ := pem.Decode([]byte(option.Value)); option.Value != "" && block == nil {
+			errors := responseError{
+				ID:  "ProjectsError",
+				Msg: fmt.Sprintf("%s must be PEM encoded", option.Name),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+	if source.GPGKey != "" && !strings.HasPrefix(strings.TrimSpace(source.GPGKey), "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		errors := responseError{
+			ID:  "ProjectsError",
+			Msg: "gpgkey must be an ASCII armored public key",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
 	api.store.PushSource(source)
+	// a changed source may point to a repository with other packages
+	api.rpmmd.Refresh()
+	api.audit(request, "source.new", source.Name, map[string]string{"type": source.Type, "url": source.URL})
 
 	statusResponseOK(writer)
 }
@@ -355,6 +602,7 @@ func (api *API) sourceDeleteHandler(writer http.ResponseWriter, request *http.Re
 
 	// remove leading / from first name
 	api.store.DeleteSource(name[0][1:])
+	api.audit(request, "source.delete", name[0][1:], nil)
 
 	statusResponseOK(writer)
 }
@@ -718,6 +966,33 @@ func (api *API) blueprintsInfoHandler(writer http.ResponseWriter, request *http.
 		return
 	}
 
+	resolved := false
+	if v := q.Get("resolved"); v != "" {
+		resolved, err = strconv.ParseBool(v)
+		if err != nil {
+			errors := responseError{
+				ID:  "InvalidChars",
+				Msg: fmt.Sprintf("invalid `resolved` parameter: %s", v),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+	if resolved {
+		for i := range blueprints {
+			bp, err := api.resolveBlueprint(&blueprints[i], false)
+			if err != nil {
+				errors := responseError{
+					ID:  "BlueprintsError",
+					Msg: err.Error(),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return
+			}
+			blueprints[i] = *bp
+		}
+	}
+
 	format := q.Get("format")
 	if format == "json" || format == "" {
 		json.NewEncoder(writer).Encode(reply{
@@ -791,27 +1066,17 @@ func (api *API) blueprintsDepsolveHandler(writer http.ResponseWriter, request *h
 			return
 		}
 
-		specs := make([]string, len(blueprint.Packages))
-		for i, pkg := range blueprint.Packages {
-			specs[i] = pkg.Name
-			// If a package has version "*" the package name suffix must be equal to "-*-*.*"
-			// Using just "-*" would find any other package containing the package name
-			if pkg.Version != "" && pkg.Version != "*" {
-				specs[i] += "-" + pkg.Version
-			} else if pkg.Version == "*" {
-				specs[i] += "-*-*.*"
+		blueprint, err := api.resolveBlueprint(blueprint, false)
+		if err != nil {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
 			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
 		}
 
-		var repos []rpmmd.RepoConfig
-		for _, repo := range api.distro.Repositories() {
-			repos = append(repos, repo)
-		}
-		for _, source := range api.store.GetAllSources() {
-			repos = append(repos, source.RepoConfig())
-		}
-
-		dependencies, err := api.rpmmd.Depsolve(specs, repos)
+		dependencies, err := api.depsolveBlueprint(blueprint, api.repositories())
 
 		if err != nil {
 			errors := responseError{
@@ -831,6 +1096,82 @@ func (api *API) blueprintsDepsolveHandler(writer http.ResponseWriter, request *h
 	})
 }
 
+// depsolveBlueprint returns the packages of bp and all their dependencies,
+// resolved from repos.
+func (api *API) depsolveBlueprint(bp *blueprint.Blueprint, repos []rpmmd.RepoConfig) ([]rpmmd.PackageSpec, error) {
+	specs := make([]string, len(bp.Packages))
+	for i, pkg := range bp.Packages {
+		specs[i] = pkg.Name
+		// If a package has version "*" the package name suffix must be equal to "-*-*.*"
+		// Using just "-*" would find any other package containing the package name
+		if pkg.Version != "" && pkg.Version != "*" {
+			specs[i] += "-" + pkg.Version
+		} else if pkg.Version == "*" {
+			specs[i] += "-*-*.*"
+		}
+	}
+
+	return api.rpmmd.Depsolve(specs, repos)
+}
+
+// repositories returns the repositories which packages of composes are
+// installed from: the distro's repositories, followed by all sources in
+// order of their names.
+func (api *API) repositories() []rpmmd.RepoConfig {
+	repos := append([]rpmmd.RepoConfig{}, api.distro.Repositories()...)
+
+	sources := api.store.GetAllSources()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		source := sources[name]
+		repos = append(repos, source.RepoConfig())
+	}
+
+	return repos
+}
+
+// pinRepositories returns repos with the checksums of their metadata, which
+// osbuild's dnf stage requires to install packages from the same versions
+// of the repositories that composer lists packages from.
+func (api *API) pinRepositories(repos []rpmmd.RepoConfig) ([]rpmmd.RepoConfig, error) {
+	checksums, err := api.rpmmd.FetchChecksums(repos)
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := make([]rpmmd.RepoConfig, len(repos))
+	for i, repo := range repos {
+		if repo.Checksum == "" {
+			repo.Checksum = "sha256:" + checksums[repo.Id]
+		}
+		pinned[i] = repo
+	}
+	return pinned, nil
+}
+
+// freezeBlueprint returns a copy of bp whose packages have the exact versions
+// they were resolved to in dependencies, which must be sorted by name.
+func freezeBlueprint(bp *blueprint.Blueprint, dependencies []rpmmd.PackageSpec) *blueprint.Blueprint {
+	frozen := *bp
+	frozen.Packages = make([]blueprint.Package, len(bp.Packages))
+	copy(frozen.Packages, bp.Packages)
+
+	for pkgIndex, pkg := range frozen.Packages {
+		i := sort.Search(len(dependencies), func(i int) bool {
+			return dependencies[i].Name >= pkg.Name
+		})
+		if i < len(dependencies) && dependencies[i].Name == pkg.Name {
+			frozen.Packages[pkgIndex].Version = dependencies[i].Version + "-" + dependencies[i].Release + "." + dependencies[i].Arch
+		}
+	}
+
+	return &frozen
+}
+
 func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	if !verifyRequestVersion(writer, params, 0) {
 		return
@@ -873,38 +1214,18 @@ func (api *API) blueprintsFreezeHandler(writer http.ResponseWriter, request *htt
 			return
 		}
 
-		specs := make([]string, len(blueprint.Packages))
-		for i, pkg := range blueprint.Packages {
-			specs[i] = pkg.Name
-			// If a package has version "*" the package name suffix must be equal to "-*-*.*"
-			// Using just "-*" would find any other package containing the package name
-			if pkg.Version != "" && pkg.Version != "*" {
-				specs[i] += "-" + pkg.Version
-			} else if pkg.Version == "*" {
-				specs[i] += "-*-*.*"
-			}
-		}
-
-		var repos []rpmmd.RepoConfig
-		for _, repo := range api.distro.Repositories() {
-			repos = append(repos, repo)
-		}
-		for _, source := range api.store.GetAllSources() {
-			repos = append(repos, source.RepoConfig())
-		}
-
-		dependencies, _ := api.rpmmd.Depsolve(specs, repos)
-
-		for pkgIndex, pkg := range blueprint.Packages {
-			i := sort.Search(len(dependencies), func(i int) bool {
-				return dependencies[i].Name >= pkg.Name
+		blueprint, err := api.resolveBlueprint(blueprint, false)
+		if err != nil {
+			errors = append(errors, responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
 			})
-			if i < len(dependencies) && dependencies[i].Name == pkg.Name {
-				blueprint.Packages[pkgIndex].Version = dependencies[i].Version + "-" + dependencies[i].Release + "." + dependencies[i].Arch
-			}
+			continue
 		}
 
-		blueprints = append(blueprints, blueprintFrozen{*blueprint})
+		dependencies, _ := api.depsolveBlueprint(blueprint, api.repositories())
+
+		blueprints = append(blueprints, blueprintFrozen{*freezeBlueprint(blueprint, dependencies)})
 	}
 
 	json.NewEncoder(writer).Encode(reply{
@@ -918,17 +1239,8 @@ func (api *API) blueprintsDiffHandler(writer http.ResponseWriter, request *http.
 		return
 	}
 
-	type pack struct {
-		Package blueprint.Package `json:"Package"`
-	}
-
-	type diff struct {
-		New *pack `json:"new"`
-		Old *pack `json:"old"`
-	}
-
 	type reply struct {
-		Diffs []diff `json:"diff"`
+		Diffs []blueprint.Difference `json:"diff"`
 	}
 
 	name := params.ByName("blueprint")
@@ -944,7 +1256,27 @@ func (api *API) blueprintsDiffHandler(writer http.ResponseWriter, request *http.
 		statusResponseError(writer, http.StatusNotFound, errors)
 		return
 	}
-	if fromCommit != "NEWEST" {
+
+	if api.store.GetBlueprintCommitted(name) == nil {
+		errors := responseError{
+			ID:  "UnknownBlueprint",
+			Msg: fmt.Sprintf("Unknown blueprint name: %s", name),
+		}
+		statusResponseError(writer, http.StatusNotFound, errors)
+		return
+	}
+
+	// Fetch old and new blueprint details from store and return error if not found
+	oldBlueprint, err := api.blueprintAtCommit(name, fromCommit)
+	if err != nil {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+	if oldBlueprint == nil {
 		errors := responseError{
 			ID:  "UnknownCommit",
 			Msg: fmt.Sprintf("ggit-error: revspec '%s' not found (-3)", fromCommit),
@@ -952,56 +1284,53 @@ func (api *API) blueprintsDiffHandler(writer http.ResponseWriter, request *http.
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
-	if toCommit != "WORKSPACE" {
+	newBlueprint, err := api.blueprintAtCommit(name, toCommit)
+	if err != nil {
 		errors := responseError{
-			ID:  "UnknownCommit",
-			Msg: fmt.Sprintf("ggit-error: revspec '%s' not found (-3)", toCommit),
+			ID:  "BlueprintsError",
+			Msg: err.Error(),
 		}
 		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
-
-	// Fetch old and new blueprint details from store and return error if not found
-	oldBlueprint := api.store.GetBlueprintCommitted(name)
-	newBlueprint, _ := api.store.GetBlueprint(name)
-	if oldBlueprint == nil || newBlueprint == nil {
+	if newBlueprint == nil {
 		errors := responseError{
-			ID:  "UnknownBlueprint",
-			Msg: fmt.Sprintf("Unknown blueprint name: %s", name),
+			ID:  "UnknownCommit",
+			Msg: fmt.Sprintf("ggit-error: revspec '%s' not found (-3)", toCommit),
 		}
-		statusResponseError(writer, http.StatusNotFound, errors)
+		statusResponseError(writer, http.StatusBadRequest, errors)
 		return
 	}
 
-	newSlice := newBlueprint.Packages
-	oldMap := make(map[string]blueprint.Package)
-	diffs := []diff{}
-
-	for _, oldPackage := range oldBlueprint.Packages {
-		oldMap[oldPackage.Name] = oldPackage
+	diffs := blueprint.Diff(oldBlueprint, newBlueprint)
+	if diffs == nil {
+		diffs = []blueprint.Difference{}
 	}
 
-	// For each package in new blueprint check if the old one contains it
-	for _, newPackage := range newSlice {
-		oldPackage, found := oldMap[newPackage.Name]
-		// If found remove from old packages map but otherwise create a diff with the added package
-		if found {
-			delete(oldMap, oldPackage.Name)
-			// Create a diff if the versions changed
-			if oldPackage.Version != newPackage.Version {
-				diffs = append(diffs, diff{Old: &pack{oldPackage}, New: &pack{newPackage}})
-			}
-		} else {
-			diffs = append(diffs, diff{Old: nil, New: &pack{newPackage}})
-		}
-	}
+	json.NewEncoder(writer).Encode(reply{diffs})
+}
 
-	// All packages remaining in the old packages map have been removed in the new blueprint so create a diff
-	for _, oldPackage := range oldMap {
-		diffs = append(diffs, diff{Old: &pack{oldPackage}, New: nil})
+// blueprintAtCommit returns the blueprint called name as it was saved in
+// commit, which can also be "NEWEST" for the latest commit or "WORKSPACE" for
+// the workspace, or nil if there is no such commit. It returns an error if
+// the commit exists, but its blueprint was not stored.
+func (api *API) blueprintAtCommit(name, commit string) (*blueprint.Blueprint, error) {
+	switch commit {
+	case "NEWEST":
+		return api.store.GetBlueprintCommitted(name), nil
+	case "WORKSPACE":
+		bp, _ := api.store.GetBlueprint(name)
+		return bp, nil
 	}
 
-	json.NewEncoder(writer).Encode(reply{diffs})
+	change := api.store.GetBlueprintChange(name, commit)
+	if change == nil {
+		return nil, nil
+	}
+	if change.Blueprint.Name == "" {
+		return nil, fmt.Errorf("the blueprint of commit %s of %s is not available", commit, name)
+	}
+	return &change.Blueprint, nil
 }
 
 func (api *API) blueprintsChangesHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -1109,8 +1438,19 @@ func (api *API) blueprintsNewHandler(writer http.ResponseWriter, request *http.R
 		return
 	}
 
+	err = blueprint.Validate()
+	if err != nil {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
 	commitMsg := "Recipe " + blueprint.Name + ", version " + blueprint.Version + " saved."
-	api.store.PushBlueprint(blueprint, commitMsg)
+	api.store.PushBlueprint(blueprint, commitMsg, userName(request))
+	api.audit(request, "blueprint.new", blueprint.Name, nil)
 
 	statusResponseOK(writer)
 }
@@ -1141,7 +1481,18 @@ func (api *API) blueprintsWorkspaceHandler(writer http.ResponseWriter, request *
 		return
 	}
 
+	err = blueprint.Validate()
+	if err != nil {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
 	api.store.PushBlueprintToWorkspace(blueprint)
+	api.audit(request, "blueprint.workspace", blueprint.Name, nil)
 
 	statusResponseOK(writer)
 }
@@ -1154,9 +1505,26 @@ func (api *API) blueprintUndoHandler(writer http.ResponseWriter, request *http.R
 	name := params.ByName("blueprint")
 	commit := params.ByName("commit")
 	bpChange := api.store.GetBlueprintChange(name, commit)
+	if bpChange == nil {
+		errors := responseError{
+			ID:  "UnknownCommit",
+			Msg: fmt.Sprintf("Unknown commit: %s", commit),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
 	bp := bpChange.Blueprint
+	if bp.Name == "" {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: fmt.Sprintf("the blueprint of commit %s of %s is not available", commit, name),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
 	commitMsg := name + ".toml reverted to commit " + commit
-	api.store.PushBlueprint(bp, commitMsg)
+	api.store.PushBlueprint(bp, commitMsg, userName(request))
+	api.audit(request, "blueprint.undo", name, map[string]string{"commit": commit})
 	statusResponseOK(writer)
 }
 
@@ -1166,6 +1534,7 @@ func (api *API) blueprintDeleteHandler(writer http.ResponseWriter, request *http
 	}
 
 	api.store.DeleteBlueprint(params.ByName("blueprint"))
+	api.audit(request, "blueprint.delete", params.ByName("blueprint"), nil)
 	statusResponseOK(writer)
 }
 
@@ -1175,26 +1544,255 @@ func (api *API) blueprintDeleteWorkspaceHandler(writer http.ResponseWriter, requ
 	}
 
 	api.store.DeleteBlueprintFromWorkspace(params.ByName("blueprint"))
+	api.audit(request, "blueprint.workspace.delete", params.ByName("blueprint"), nil)
 	statusResponseOK(writer)
 }
 
-// Schedule new compose by first translating the appropriate blueprint into a pipeline and then
-// pushing it into the channel for waiting builds.
-func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	if !verifyRequestVersion(writer, params, 0) {
+func (api *API) blueprintsExportHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
 		return
 	}
 
-	// https://weldr.io/lorax/pylorax.api.html#pylorax.api.v0.v0_compose_start
-	type ComposeRequest struct {
-		BlueprintName string         `json:"blueprint_name"`
-		ComposeType   string         `json:"compose_type"`
-		Branch        string         `json:"branch"`
-		Upload        *UploadRequest `json:"upload"`
-	}
-	type ComposeReply struct {
-		BuildID uuid.UUID `json:"build_id"`
-		Status  bool      `json:"status"`
+	var blueprints []blueprint.Archived
+	for _, name := range api.store.ListBlueprints() {
+		bp := api.store.GetBlueprintCommitted(name)
+		if bp == nil {
+			continue
+		}
+
+		changes := api.store.GetBlueprintChanges(name)
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].Timestamp < changes[j].Timestamp
+		})
+		blueprints = append(blueprints, blueprint.Archived{Blueprint: *bp, Changes: changes})
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename=blueprints.tar")
+	writer.Header().Set("Content-Type", "application/x-tar")
+
+	err := blueprint.WriteArchive(writer, blueprints)
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("cannot export blueprints: %v", err)
+	}
+}
+
+// blueprintsImportHandler imports the blueprints in an archive written by
+// the export route or in a lorax blueprint repository. The "conflict"
+// parameter decides what happens to existing blueprints: "skip" keeps
+// them, "overwrite" replaces them and their history, and "new-version"
+// commits the imported blueprint as their next version. With "dry_run",
+// only the report of what would happen is returned.
+func (api *API) blueprintsImportHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	type importedBlueprint struct {
+		Name    string `json:"name"`
+		Action  string `json:"action"`
+		Changes int    `json:"changes"`
+	}
+
+	type reply struct {
+		Status     bool                `json:"status"`
+		DryRun     bool                `json:"dry_run"`
+		Blueprints []importedBlueprint `json:"blueprints"`
+		Errors     []responseError     `json:"errors"`
+	}
+
+	query := request.URL.Query()
+	conflict := query.Get("conflict")
+	switch conflict {
+	case "":
+		conflict = "skip"
+	case "skip", "overwrite", "new-version":
+	default:
+		errors := responseError{
+			ID:  "BadRequest",
+			Msg: fmt.Sprintf("BadRequest: invalid value for 'conflict': %s", conflict),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	dryRun := false
+	if v := query.Get("dry_run"); v != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			errors := responseError{
+				ID:  "BadRequest",
+				Msg: fmt.Sprintf("BadRequest: invalid value for 'dry_run': %s", v),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	contentType := request.Header.Get("Content-Type")
+	if contentType != "application/x-tar" && contentType != "application/gzip" && contentType != "application/x-gzip" {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: "blueprints must be a tar archive",
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, blueprint.MaxArchiveSize)
+	archived, err := blueprint.ReadArchive(request.Body)
+	if err != nil {
+		errors := responseError{
+			ID:  "BlueprintsError",
+			Msg: err.Error(),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	imported := []importedBlueprint{}
+	errors := []responseError{}
+	seen := make(map[string]bool)
+	for _, a := range archived {
+		name := a.Blueprint.Name
+		if err := a.Blueprint.Validate(); err != nil {
+			errors = append(errors, responseError{ID: "InvalidBlueprint", Msg: err.Error()})
+			continue
+		}
+		if seen[name] {
+			msg := fmt.Sprintf("%s: archive contains the blueprint more than once", name)
+			errors = append(errors, responseError{ID: "InvalidBlueprint", Msg: msg})
+			continue
+		}
+		seen[name] = true
+
+		action := "create"
+		if api.store.GetBlueprintCommitted(name) != nil {
+			action = conflict
+		}
+		imported = append(imported, importedBlueprint{name, action, len(a.Changes)})
+
+		if dryRun {
+			continue
+		}
+
+		switch action {
+		case "create", "overwrite":
+			api.store.ImportBlueprint(a.Blueprint, a.Changes, userName(request))
+		case "new-version":
+			// let the store bump the version of the existing blueprint
+			a.Blueprint.Version = ""
+			api.store.PushBlueprint(a.Blueprint, "Recipe "+name+" imported.", userName(request))
+		case "skip":
+			continue
+		}
+		api.audit(request, "blueprint.import", name, map[string]string{"action": action})
+	}
+
+	json.NewEncoder(writer).Encode(reply{
+		Status:     true,
+		DryRun:     dryRun,
+		Blueprints: imported,
+		Errors:     errors,
+	})
+}
+
+// resolveBlueprint merges the blueprints which bp includes into it. They are
+// looked up among the committed blueprints if committed is set, and in the
+// workspace otherwise.
+func (api *API) resolveBlueprint(bp *blueprint.Blueprint, committed bool) (*blueprint.Blueprint, error) {
+	return bp.Resolve(func(name string) *blueprint.Blueprint {
+		if committed {
+			return api.store.GetBlueprintCommitted(name)
+		}
+		included, _ := api.store.GetBlueprint(name)
+		return included
+	})
+}
+
+// resolveBlueprintForCompose merges the blueprints which bp, the version of a
+// blueprint requested with commit for a compose, includes into it. The
+// workspace includes the workspaces of other blueprints and a historical
+// commit the versions which were committed at the time. Otherwise, the
+// committed versions are included.
+func (api *API) resolveBlueprintForCompose(bp *blueprint.Blueprint, commit string) (*blueprint.Blueprint, error) {
+	switch commit {
+	case "", "NEWEST":
+		return api.resolveBlueprint(bp, true)
+	case "WORKSPACE":
+		return api.resolveBlueprint(bp, false)
+	}
+
+	change := api.store.GetBlueprintChange(bp.Name, commit)
+	if change == nil {
+		return nil, fmt.Errorf("unknown commit %s of %s", commit, bp.Name)
+	}
+
+	var lookupErr error
+	resolved, err := bp.Resolve(func(name string) *blueprint.Blueprint {
+		included := api.store.GetBlueprintChangeAsOf(name, change.Timestamp)
+		if included == nil {
+			return nil
+		}
+		if included.Blueprint.Name == "" {
+			lookupErr = fmt.Errorf("the blueprint of commit %s of %s is not available", included.Commit, name)
+			return nil
+		}
+		return &included.Blueprint
+	})
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return resolved, err
+}
+
+// blueprintForCompose returns the blueprint called name at commit, which is
+// one of the values of blueprintAtCommit, and the commit to record for a
+// compose of it. It returns nil if there is no such blueprint or commit, and
+// the errors of blueprintAtCommit.
+func (api *API) blueprintForCompose(name, commit string) (*blueprint.Blueprint, string, error) {
+	switch commit {
+	case "", "NEWEST":
+		bp, commit := api.store.GetBlueprintNewest(name)
+		return bp, commit, nil
+	case "WORKSPACE":
+		bp, inWorkspace := api.store.GetBlueprint(name)
+		if bp != nil && !inWorkspace {
+			// the workspace is the same as the newest commit
+			bp, commit := api.store.GetBlueprintNewest(name)
+			return bp, commit, nil
+		}
+		return bp, commit, nil
+	default:
+		bp, err := api.blueprintAtCommit(name, commit)
+		return bp, commit, err
+	}
+}
+
+// Schedule new compose by first translating the appropriate blueprint into a pipeline and then
+// pushing it into the channel for waiting builds.
+func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	// https://weldr.io/lorax/pylorax.api.html#pylorax.api.v0.v0_compose_start
+	type ComposeRequest struct {
+		BlueprintName string                 `json:"blueprint_name"`
+		ComposeType   string                 `json:"compose_type"`
+		Branch        string                 `json:"branch"`
+		Upload        *UploadRequest         `json:"upload"`
+		Webhooks      []string               `json:"webhooks,omitempty"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+		// A blueprint which is not stored, instead of blueprint_name
+		Blueprint json.RawMessage `json:"blueprint,omitempty"`
+		// Commit of the blueprint to compose, "WORKSPACE" for its
+		// workspace or "NEWEST", the default
+		Commit string `json:"commit,omitempty"`
+	}
+	type ComposeReply struct {
+		BuildID uuid.UUID `json:"build_id"`
+		Status  bool      `json:"status"`
 	}
 
 	contentType := request.Header["Content-Type"]
@@ -1219,6 +1817,16 @@ func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
+	// blueprints only have a single history, use commit to pick from it
+	if cr.Branch != "" && cr.Branch != "master" {
+		errors := responseError{
+			ID:  "UnknownBranch",
+			Msg: fmt.Sprintf("Unknown branch: %s", cr.Branch),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
 	reply := ComposeReply{
 		BuildID: uuid.New(),
 		Status:  true,
@@ -1239,15 +1847,138 @@ func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request
 		}
 	}
 
-	bp := api.store.GetBlueprintCommitted(cr.BlueprintName)
+	for _, hook := range cr.Webhooks {
+		if err := webhook.ValidateURL(hook); err != nil {
+			errors := responseError{
+				ID:  "InvalidWebhook",
+				Msg: fmt.Sprintf("invalid webhook %s: %s", hook, err.Error()),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
+
+	var bp *blueprint.Blueprint
+	var commit string
+	if cr.Blueprint != nil {
+		if cr.BlueprintName != "" || cr.Commit != "" {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: "blueprint_name and commit cannot be set with blueprint",
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		bp, err = parseInlineBlueprint(cr.Blueprint)
+		if err != nil {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		cr.BlueprintName = bp.Name
+	} else {
+		bp, commit, err = api.blueprintForCompose(cr.BlueprintName, cr.Commit)
+		if err != nil {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+		if bp == nil && api.store.GetBlueprintCommitted(cr.BlueprintName) != nil {
+			// only the commit is unknown
+			errors := responseError{
+				ID:  "UnknownCommit",
+				Msg: fmt.Sprintf("ggit-error: revspec '%s' not found (-3)", cr.Commit),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+	}
 
 	if bp != nil {
-		err := api.store.PushCompose(reply.BuildID, bp, cr.ComposeType, uploadTarget)
+		bp, err := api.resolveBlueprintForCompose(bp, cr.Commit)
+		if err != nil {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		bp, variables, err := bp.ApplyVariables(cr.Variables)
+		if err != nil {
+			errors := responseError{
+				ID:  "InvalidVariable",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		// variables may be referenced in paths, so validate again
+		err = bp.ValidateFiles()
+		if err != nil {
+			errors := responseError{
+				ID:  "BlueprintsError",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		repos := api.repositories()
+		for _, repo := range repos {
+			err = repo.CheckBuildOptions()
+			if err != nil {
+				errors := responseError{
+					ID:  "ProjectsError",
+					Msg: err.Error(),
+				}
+				statusResponseError(writer, http.StatusBadRequest, errors)
+				return
+			}
+		}
+		repos, err = api.pinRepositories(repos)
+		if err != nil {
+			errors := responseError{
+				ID:  "ProjectsError",
+				Msg: fmt.Sprintf("msg: %s", err.Error()),
+			}
+			statusResponseError(writer, http.StatusBadRequest, errors)
+			return
+		}
+
+		// the worker adds the packages it installed to the manifest
+		err = api.store.PushCompose(reply.BuildID, bp, cr.ComposeType, uploadTarget, &store.ComposeOptions{
+			Webhooks:     cr.Webhooks,
+			User:         userName(request),
+			RequestID:    request.Header.Get(logging.RequestIDHeader),
+			Variables:    variables,
+			Commit:       commit,
+			Repositories: repos,
+			Manifest: &manifest.Manifest{
+				Blueprint: bp,
+			},
+		})
 
 		// TODO: we should probably do some kind of blueprint validation in future
 		// for now, let's just 500 and bail out
-		if err != nil {
-			log.Println("error when pushing new compose: ", err.Error())
+		if _, ok := err.(*store.QueueFullError); ok {
+			errors := responseError{
+				ID:  "QueueFull",
+				Msg: err.Error(),
+			}
+			statusResponseError(writer, http.StatusServiceUnavailable, errors)
+			return
+		} else if err != nil {
+			logging.FromContext(request.Context()).With("compose_id", reply.BuildID).Errorf("cannot push compose: %v", err)
 			errors := responseError{
 				ID:  "ComposePushErrored",
 				Msg: err.Error(),
@@ -1264,6 +1995,19 @@ func (api *API) composeHandler(writer http.ResponseWriter, request *http.Request
 		return
 	}
 
+	details := map[string]string{
+		"blueprint":    cr.BlueprintName,
+		"compose_type": cr.ComposeType,
+	}
+	if uploadTarget != nil {
+		details["upload"] = cr.Upload.Provider
+	}
+	if cr.Blueprint != nil {
+		details["inline"] = "true"
+	}
+	api.audit(request, "compose.start", reply.BuildID.String(), details)
+	logging.FromContext(request.Context()).With("compose_id", reply.BuildID).Infof("queued %s compose of blueprint %s", cr.ComposeType, cr.BlueprintName)
+
 	json.NewEncoder(writer).Encode(reply)
 }
 
@@ -1292,32 +2036,56 @@ func (api *API) composeQueueHandler(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
+	filter, ok := parseComposeFilterOrFail(writer, request)
+	if !ok {
+		return
+	}
+
 	reply := struct {
 		New []*ComposeEntry `json:"new"`
 		Run []*ComposeEntry `json:"run"`
-	}{[]*ComposeEntry{}, []*ComposeEntry{}}
+		composePage
+	}{New: []*ComposeEntry{}, Run: []*ComposeEntry{}}
 
-	composes := api.store.GetAllComposes()
-	for id, compose := range composes {
-		switch compose.QueueStatus {
+	// waiting composes come before running ones, unless they are sorted
+	var waiting, running []*ComposeEntry
+	composes := filter.Filter(api.store.GetAllComposes())
+	for _, entry := range composesToComposeEntries(composes, nil, isRequestVersionAtLeast(params, 1)) {
+		switch entry.QueueStatus {
 		case "WAITING":
-			reply.New = append(reply.New, composeToComposeEntry(id, compose, isRequestVersionAtLeast(params, 1)))
+			waiting = append(waiting, entry)
 		case "RUNNING":
-			reply.Run = append(reply.Run, composeToComposeEntry(id, compose, isRequestVersionAtLeast(params, 1)))
+			running = append(running, entry)
 		}
 	}
 
+	// the queue is paginated as a whole and split up again
+	entries, total := filter.Apply(append(waiting, running...))
+	for _, entry := range entries {
+		if entry.QueueStatus == "WAITING" {
+			reply.New = append(reply.New, entry)
+		} else {
+			reply.Run = append(reply.Run, entry)
+		}
+	}
+	reply.composePage = filter.Page(total)
+
 	json.NewEncoder(writer).Encode(reply)
 }
 
 func (api *API) composeStatusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	// TODO: lorax has some params: /api/v0/compose/status/<uuids>[?blueprint=<blueprint_name>&status=<compose_status>&type=<compose_type>]
 	if !verifyRequestVersion(writer, params, 0) {
 		return
 	}
 
+	filter, ok := parseComposeFilterOrFail(writer, request)
+	if !ok {
+		return
+	}
+
 	var reply struct {
 		UUIDs []*ComposeEntry `json:"uuids"`
+		composePage
 	}
 
 	uuidsParam := params.ByName("uuids")
@@ -1340,9 +2108,11 @@ func (api *API) composeStatusHandler(writer http.ResponseWriter, request *http.R
 			uuids = append(uuids, id)
 		}
 	}
-	composes := api.store.GetAllComposes()
+	composes := filter.Filter(api.store.GetAllComposes())
 
-	reply.UUIDs = composesToComposeEntries(composes, uuids, isRequestVersionAtLeast(params, 1))
+	var total uint
+	reply.UUIDs, total = filter.Apply(composesToComposeEntries(composes, uuids, isRequestVersionAtLeast(params, 1)))
+	reply.composePage = filter.Page(total)
 
 	json.NewEncoder(writer).Encode(reply)
 }
@@ -1375,15 +2145,17 @@ func (api *API) composeInfoHandler(writer http.ResponseWriter, request *http.Req
 	}
 
 	var reply struct {
-		ID          uuid.UUID            `json:"id"`
-		Config      string               `json:"config"`    // anaconda config, let's ignore this field
-		Blueprint   *blueprint.Blueprint `json:"blueprint"` // blueprint not frozen!
-		Commit      string               `json:"commit"`    // empty for now
-		Deps        []string             `json:"deps"`      // empty for now
-		ComposeType string               `json:"compose_type"`
-		QueueStatus string               `json:"queue_status"`
-		ImageSize   int64                `json:"image_size"`
-		Uploads     []UploadResponse     `json:"uploads,omitempty"`
+		ID          uuid.UUID              `json:"id"`
+		Config      string                 `json:"config"`    // anaconda config, let's ignore this field
+		Blueprint   *blueprint.Blueprint   `json:"blueprint"` // blueprint not frozen!
+		Commit      string                 `json:"commit"`
+		Deps        []string               `json:"deps"` // empty for now
+		ComposeType string                 `json:"compose_type"`
+		QueueStatus string                 `json:"queue_status"`
+		ImageSize   int64                  `json:"image_size"`
+		Checksums   map[string]string      `json:"checksums,omitempty"`
+		Uploads     []UploadResponse       `json:"uploads,omitempty"`
+		Variables   map[string]interface{} `json:"variables,omitempty"`
 	}
 
 	reply.ID = id
@@ -1391,8 +2163,11 @@ func (api *API) composeInfoHandler(writer http.ResponseWriter, request *http.Req
 	reply.Deps = []string{}
 	reply.ComposeType = compose.OutputType
 	reply.QueueStatus = compose.QueueStatus
+	reply.Variables = compose.Variables
+	reply.Commit = compose.Commit
 	if compose.Image != nil {
 		reply.ImageSize = compose.Image.Size
+		reply.Checksums = compose.Image.Checksums
 	}
 
 	if isRequestVersionAtLeast(params, 1) {
@@ -1458,11 +2233,29 @@ func (api *API) composeImageHandler(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Build %s is missing file %s!", uuidString, imageName),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
 	writer.Header().Set("Content-Disposition", "attachment; filename="+uuid.String()+"-"+imageName)
 	writer.Header().Set("Content-Type", compose.Image.Mime)
-	writer.Header().Set("Content-Length", fmt.Sprintf("%d", compose.Image.Size))
+	// images never change, so their checksum identifies them; ServeContent
+	// uses it to answer If-None-Match and If-Range
+	if checksum, ok := compose.Image.Checksums["sha256"]; ok {
+		writer.Header().Set("ETag", `"sha256:`+checksum+`"`)
+	}
 
-	io.Copy(writer, file)
+	// ServeContent also handles range requests, so that interrupted
+	// downloads can be resumed, and HEAD requests
+	http.ServeContent(writer, request, "", info.ModTime(), file)
 }
 
 func (api *API) composeLogsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -1504,18 +2297,175 @@ func (api *API) composeLogsHandler(writer http.ResponseWriter, request *http.Req
 	writer.Header().Set("Content-Type", "application/x-tar")
 
 	tw := tar.NewWriter(writer)
+	err = writeComposeLogs(tw, compose)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("cannot send logs of compose %s: %v", id, err)
+	}
+}
+
+func (api *API) composeMetadataHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if compose.Manifest == nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Compose %s has no metadata", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+id.String()+"-metadata.tar")
+	writer.Header().Set("Content-Type", "application/x-tar")
+
+	tw := tar.NewWriter(writer)
+	err = writeComposeMetadata(tw, compose.Manifest)
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("cannot send metadata of compose %s: %v", id, err)
+	}
+}
+
+func (api *API) composeResultsHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 0) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if compose.QueueStatus != "FINISHED" {
+		errors := responseError{
+			ID:  "BuildInWrongState",
+			Msg: fmt.Sprintf("Build %s is in wrong state: %s", uuidString, compose.QueueStatus),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	if compose.Manifest == nil || compose.Image == nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Compose %s has no metadata or image", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	// check that the image exists before starting the reply
+	artifacts, err := composeArtifacts(compose.Image.Path)
+	if err != nil {
+		errors := responseError{
+			ID:  "BuildMissingFile",
+			Msg: fmt.Sprintf("Build %s is missing file %s!", uuidString, filepath.Base(compose.Image.Path)),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
+
+	writer.Header().Set("Content-Disposition", "attachment; filename="+id.String()+".tar")
+	writer.Header().Set("Content-Type", "application/x-tar")
+
+	tw := tar.NewWriter(writer)
+	err = writeComposeMetadata(tw, compose.Manifest)
+	if err == nil {
+		err = writeComposeLogs(tw, compose)
+	}
+	for _, path := range artifacts {
+		if err != nil {
+			break
+		}
+		err = addFileToTar(tw, filepath.Base(path), path)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		logging.FromContext(request.Context()).Errorf("cannot send results of compose %s: %v", id, err)
+	}
+}
 
-	// TODO: return real log from osbuild
-	fileContents := []byte("SUCCESS\n")
+func (api *API) composeWebhooksHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if !verifyRequestVersion(writer, params, 1) {
+		return
+	}
+
+	uuidString := params.ByName("uuid")
+	id, err := uuid.Parse(uuidString)
+	if err != nil {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("%s is not a valid build uuid", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
+	}
 
-	header := &tar.Header{
-		Name: "logs/osbuild.log",
-		Mode: 0644,
-		Size: int64(len(fileContents)),
+	compose, exists := api.store.GetCompose(id)
+	if !exists {
+		errors := responseError{
+			ID:  "UnknownUUID",
+			Msg: fmt.Sprintf("Compose %s doesn't exist", uuidString),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return
 	}
 
-	tw.WriteHeader(header)
-	tw.Write(fileContents)
+	reply := struct {
+		Webhooks   []string                `json:"webhooks"`
+		Deliveries []store.WebhookDelivery `json:"deliveries"`
+	}{[]string{}, []store.WebhookDelivery{}}
+
+	reply.Webhooks = append(reply.Webhooks, compose.Webhooks...)
+	reply.Deliveries = append(reply.Deliveries, compose.Deliveries...)
+
+	json.NewEncoder(writer).Encode(reply)
 }
 
 func (api *API) composeFinishedHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -1523,11 +2473,17 @@ func (api *API) composeFinishedHandler(writer http.ResponseWriter, request *http
 		return
 	}
 
+	filter, ok := parseComposeFilterOrFail(writer, request)
+	if !ok {
+		return
+	}
+
 	reply := struct {
 		Finished []*ComposeEntry `json:"finished"`
-	}{[]*ComposeEntry{}}
+		composePage
+	}{Finished: []*ComposeEntry{}}
 
-	composes := api.store.GetAllComposes()
+	composes := filter.Filter(api.store.GetAllComposes())
 	for _, entry := range composesToComposeEntries(composes, nil, isRequestVersionAtLeast(params, 1)) {
 		switch entry.QueueStatus {
 		case "FINISHED":
@@ -1535,6 +2491,10 @@ func (api *API) composeFinishedHandler(writer http.ResponseWriter, request *http
 		}
 	}
 
+	var total uint
+	reply.Finished, total = filter.Apply(reply.Finished)
+	reply.composePage = filter.Page(total)
+
 	json.NewEncoder(writer).Encode(reply)
 }
 
@@ -1543,11 +2503,17 @@ func (api *API) composeFailedHandler(writer http.ResponseWriter, request *http.R
 		return
 	}
 
+	filter, ok := parseComposeFilterOrFail(writer, request)
+	if !ok {
+		return
+	}
+
 	reply := struct {
 		Failed []*ComposeEntry `json:"failed"`
-	}{[]*ComposeEntry{}}
+		composePage
+	}{Failed: []*ComposeEntry{}}
 
-	composes := api.store.GetAllComposes()
+	composes := filter.Filter(api.store.GetAllComposes())
 	for _, entry := range composesToComposeEntries(composes, nil, isRequestVersionAtLeast(params, 1)) {
 		switch entry.QueueStatus {
 		case "FAILED":
@@ -1555,19 +2521,30 @@ func (api *API) composeFailedHandler(writer http.ResponseWriter, request *http.R
 		}
 	}
 
+	var total uint
+	reply.Failed, total = filter.Apply(reply.Failed)
+	reply.composePage = filter.Page(total)
+
 	json.NewEncoder(writer).Encode(reply)
 }
 
-func (api *API) fetchPackageList() (rpmmd.PackageList, error) {
-	var repos []rpmmd.RepoConfig
-	for _, repo := range api.distro.Repositories() {
-		repos = append(repos, repo)
-	}
-	for _, source := range api.store.GetAllSources() {
-		repos = append(repos, source.RepoConfig())
+// parseComposeFilterOrFail parses the filter of a compose listing request,
+// writing an error response and returning false if it is invalid.
+func parseComposeFilterOrFail(writer http.ResponseWriter, request *http.Request) (*composeFilter, bool) {
+	filter, err := parseComposeFilter(request.URL.Query())
+	if err != nil {
+		errors := responseError{
+			ID:  "BadRequest",
+			Msg: fmt.Sprintf("BadRequest: %s", err.Error()),
+		}
+		statusResponseError(writer, http.StatusBadRequest, errors)
+		return nil, false
 	}
+	return filter, true
+}
 
-	return api.rpmmd.FetchPackageList(repos)
+func (api *API) fetchPackageList() (rpmmd.PackageList, error) {
+	return api.rpmmd.FetchPackageList(api.repositories())
 }
 
 func (api *API) uploadsScheduleHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
@@ -1,10 +1,29 @@
 package weldr
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 	"github.com/google/uuid"
+	"github.com/osbuild/osbuild-composer/internal/jobqueue"
+	"github.com/osbuild/osbuild-composer/internal/logging"
+	"github.com/osbuild/osbuild-composer/internal/manifest"
+	"github.com/osbuild/osbuild-composer/internal/rpmmd"
 	"github.com/osbuild/osbuild-composer/internal/store"
-	"log"
-	"sort"
+	"github.com/osbuild/osbuild-composer/internal/target"
 )
 
 type ComposeEntry struct {
@@ -45,7 +64,7 @@ func composeToComposeEntry(id uuid.UUID, compose store.Compose, includeUploads b
 		if compose.Image != nil {
 			composeEntry.ImageSize = compose.Image.Size
 		} else {
-			log.Printf("finished compose with id %s has nil image\n", id.String())
+			logging.Default().With("compose_id", id).Warningf("finished compose has no image")
 			composeEntry.ImageSize = 0
 		}
 
@@ -87,3 +106,291 @@ func composesToComposeEntries(composes map[uuid.UUID]store.Compose, uuids []uuid
 
 	return composeEntries
 }
+
+// A composeFilter selects, orders and paginates compose entries according
+// to the query parameters of a compose listing request. The zero value
+// matches every compose and keeps the default order.
+type composeFilter struct {
+	Blueprint   string
+	Status      string
+	ComposeType string
+	Since       *time.Time
+	Until       *time.Time
+	Sort        string
+
+	Paginate bool
+	Offset   uint
+	Limit    uint
+}
+
+// parseComposeFilter reads the `blueprint`, `status`, `type`, `since`,
+// `until`, `sort`, `offset` and `limit` query parameters. Pagination is
+// only enabled when either `offset` or `limit` is passed, so that listings
+// stay complete for clients which do not know about it.
+func parseComposeFilter(query url.Values) (*composeFilter, error) {
+	var f composeFilter
+
+	f.Blueprint = query.Get("blueprint")
+	f.ComposeType = query.Get("type")
+
+	f.Status = query.Get("status")
+	switch f.Status {
+	case "", "WAITING", "RUNNING", "FINISHED", "FAILED":
+	default:
+		return nil, errors.New("invalid value for 'status': " + f.Status)
+	}
+
+	f.Sort = query.Get("sort")
+	switch f.Sort {
+	case "", "asc", "desc":
+	default:
+		return nil, errors.New("invalid value for 'sort': " + f.Sort)
+	}
+
+	var err error
+	f.Since, err = parseTime(query, "since")
+	if err != nil {
+		return nil, err
+	}
+	f.Until, err = parseTime(query, "until")
+	if err != nil {
+		return nil, err
+	}
+
+	_, hasOffset := query["offset"]
+	_, hasLimit := query["limit"]
+	if hasOffset || hasLimit {
+		f.Paginate = true
+		f.Offset, f.Limit, err = parseOffsetAndLimit(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &f, nil
+}
+
+// parseTime accepts either a unix timestamp, as used in compose entries,
+// or an RFC 3339 date.
+func parseTime(query url.Values, name string) (*time.Time, error) {
+	v := query.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	invalid := errors.New("invalid value for '" + name + "': must be a unix timestamp or an RFC 3339 date")
+
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		// the comparison is false for NaN as well
+		nanoseconds := seconds * 1e9
+		if !(nanoseconds >= math.MinInt64 && nanoseconds < math.MaxInt64) {
+			return nil, invalid
+		}
+		t := time.Unix(0, int64(nanoseconds))
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, invalid
+	}
+
+	return &t, nil
+}
+
+func (f *composeFilter) matches(compose store.Compose) bool {
+	if f.Blueprint != "" && (compose.Blueprint == nil || compose.Blueprint.Name != f.Blueprint) {
+		return false
+	}
+	if f.Status != "" && compose.QueueStatus != f.Status {
+		return false
+	}
+	if f.ComposeType != "" && compose.OutputType != f.ComposeType {
+		return false
+	}
+	if f.Since != nil && compose.JobCreated.Before(*f.Since) {
+		return false
+	}
+	if f.Until != nil && compose.JobCreated.After(*f.Until) {
+		return false
+	}
+	return true
+}
+
+// Filter returns the composes matching the filter.
+func (f *composeFilter) Filter(composes map[uuid.UUID]store.Compose) map[uuid.UUID]store.Compose {
+	filtered := make(map[uuid.UUID]store.Compose)
+	for id, compose := range composes {
+		if f.matches(compose) {
+			filtered[id] = compose
+		}
+	}
+	return filtered
+}
+
+// A composePage is embedded in the replies of compose listings. Its fields
+// are only set when the client asked for pagination.
+type composePage struct {
+	Total  *uint `json:"total,omitempty"`
+	Offset *uint `json:"offset,omitempty"`
+	Limit  *uint `json:"limit,omitempty"`
+}
+
+func (f *composeFilter) Page(total uint) composePage {
+	if !f.Paginate {
+		return composePage{}
+	}
+	offset, limit := f.Offset, f.Limit
+	return composePage{&total, &offset, &limit}
+}
+
+// Apply sorts entries and cuts out the requested page. It also returns the
+// number of entries before pagination.
+func (f *composeFilter) Apply(entries []*ComposeEntry) ([]*ComposeEntry, uint) {
+	if f.Sort != "" {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if f.Sort == "desc" {
+				return entries[i].JobCreated > entries[j].JobCreated
+			}
+			return entries[i].JobCreated < entries[j].JobCreated
+		})
+	}
+
+	total := uint(len(entries))
+	if !f.Paginate {
+		return entries, total
+	}
+
+	start := min(f.Offset, total)
+	n := min(f.Limit, total-start)
+
+	return entries[start : start+n], total
+}
+
+// writeComposeMetadata adds the metadata of a compose to tw, from which it can
+// be reproduced: the blueprint as it was composed, the same blueprint with the
+// exact versions of its packages, all installed packages and the pipeline.
+func writeComposeMetadata(tw *tar.Writer, m *manifest.Manifest) error {
+	var blueprintTOML, frozenTOML bytes.Buffer
+	err := toml.NewEncoder(&blueprintTOML).Encode(m.Blueprint)
+	if err != nil {
+		return err
+	}
+	err = toml.NewEncoder(&frozenTOML).Encode(freezeBlueprint(m.Blueprint, m.Packages))
+	if err != nil {
+		return err
+	}
+
+	packages := m.Packages
+	if packages == nil {
+		packages = []rpmmd.PackageSpec{}
+	}
+	packagesJSON, err := json.MarshalIndent(packages, "", "  ")
+	if err != nil {
+		return err
+	}
+	pipelineJSON, err := json.MarshalIndent(m.Pipeline, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"blueprint.toml", blueprintTOML.Bytes()},
+		{"frozen.toml", frozenTOML.Bytes()},
+		{"deps.json", append(packagesJSON, '\n')},
+		{"pipeline.json", append(pipelineJSON, '\n')},
+	}
+	for _, f := range files {
+		err = addDataToTar(tw, f.name, f.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeComposeLogs adds the logs of a compose to tw. Only composes which keep
+// their artifacts have logs, which the worker sent along with the image.
+func writeComposeLogs(tw *tar.Writer, compose store.Compose) error {
+	for _, t := range compose.Targets {
+		options, ok := t.Options.(*target.LocalTargetOptions)
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(options.Location, jobqueue.OsbuildLogName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil
+		}
+		return addFileToTar(tw, "logs/"+jobqueue.OsbuildLogName, path)
+	}
+	return nil
+}
+
+// composeArtifacts returns the paths of the image at imagePath and of all
+// other files which were stored next to it, such as its checksums and signed
+// manifest. osbuild's log is left out, as it is part of the compose's logs.
+func composeArtifacts(imagePath string) ([]string, error) {
+	_, err := os.Stat(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Dir(imagePath))
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{imagePath}
+	for _, info := range infos {
+		path := filepath.Join(filepath.Dir(imagePath), info.Name())
+		// hidden files are partial uploads
+		if path == imagePath || strings.HasPrefix(info.Name(), ".") || info.Name() == jobqueue.OsbuildLogName || !info.Mode().IsRegular() {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func addDataToTar(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+func addFileToTar(tw *tar.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -1,9 +1,15 @@
 package weldr
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"net/url"
 	"strconv"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/osbuild/osbuild-composer/internal/blueprint"
 )
 
 func parseOffsetAndLimit(query url.Values) (uint, uint, error) {
@@ -33,3 +39,30 @@ func min(a, b uint) uint {
 	}
 	return b
 }
+
+// parseInlineBlueprint parses a blueprint which is part of a request, either
+// as a JSON object or as a string in TOML format.
+func parseInlineBlueprint(data json.RawMessage) (*blueprint.Blueprint, error) {
+	var bp blueprint.Blueprint
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var content string
+		err := json.Unmarshal(data, &content)
+		if err != nil {
+			return nil, err
+		}
+		_, err = toml.Decode(content, &bp)
+		if err != nil {
+			return nil, errors.New("invalid blueprint: " + err.Error())
+		}
+	} else {
+		err := json.Unmarshal(data, &bp)
+		if err != nil {
+			return nil, errors.New("invalid blueprint: " + err.Error())
+		}
+	}
+
+	if bp.Name == "" {
+		return nil, errors.New("blueprint must have a name")
+	}
+	return &bp, nil
+}